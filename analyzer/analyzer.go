@@ -0,0 +1,444 @@
+// Package analyzer checks scripts statically, simulating the stack height of every
+// path through OP_IF, OP_NOTIF and OP_ELSE with unknown unlocking script inputs.
+//
+// Numbers pushed by the script are tracked, so the depth of OP_PICK and OP_ROLL and
+// the key counts of OP_CHECKMULTISIG are known when they are pushed as constants, as
+// they are in every script of this library. Values computed by the script are unknown,
+// so conditions computed at runtime take both branches.
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+)
+
+// maxPaths bounds the paths simulated, which double with each runtime condition
+const maxPaths = 4096
+
+// Report is the result of analyzing a script
+type Report struct {
+	// Size is the length of the script in bytes
+	Size int
+	// Ops is the number of opcodes in the script
+	Ops int
+	// MaxStack is the most items on the main and alt stacks together on any path, including the inputs
+	MaxStack int
+	// FinalHeights are the heights the main stack can be left at, in increasing order
+	FinalHeights []int
+	// Paths is the number of paths through the script that were simulated
+	Paths int
+	// Problems are the problems found, in order of offset
+	Problems []Problem
+}
+
+// Problem is a problem found at an opcode
+type Problem struct {
+	Offset  int
+	Opcode  string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s at %d: %s", p.Opcode, p.Offset, p.Message)
+}
+
+// Err returns the problems as an error, or nil if there are none
+func (r *Report) Err() error {
+	if len(r.Problems) == 0 {
+		return nil
+	}
+	messages := make([]string, len(r.Problems))
+	for i, p := range r.Problems {
+		messages[i] = p.String()
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Check analyzes the script and returns its problems as an error, for use in tests of templates
+func Check(s *bscript.Script, inputs int) error {
+	r, err := Analyze(s, inputs)
+	if err != nil {
+		return err
+	}
+	return r.Err()
+}
+
+// item is a value on the stack, with the number it is if it is known
+type item struct {
+	known bool
+	n     int64
+}
+
+// path is the state of one path through the script
+type path struct {
+	stack []item
+	alt   []item
+	// exec holds whether each enclosing branch is executed
+	exec []bool
+	done bool
+}
+
+func (p *path) executing() bool {
+	for _, e := range p.exec {
+		if !e {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *path) clone() *path {
+	return &path{
+		stack: append([]item{}, p.stack...),
+		alt:   append([]item{}, p.alt...),
+		exec:  append([]bool{}, p.exec...),
+		done:  p.done,
+	}
+}
+
+// key identifies paths which behave the same from here on, so they are simulated once
+func (p *path) key() string {
+	var b strings.Builder
+	fmt.Fprint(&b, p.exec, p.done, len(p.alt))
+	for _, it := range append(append([]item{}, p.stack...), p.alt...) {
+		if it.known {
+			fmt.Fprintf(&b, " %d", it.n)
+		} else {
+			b.WriteString(" ?")
+		}
+	}
+	return b.String()
+}
+
+// Analyze simulates the script with the number of unknown inputs on the stack
+func Analyze(s *bscript.Script, inputs int) (*Report, error) {
+	ops, err := asm.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{Size: len(*s), Ops: len(ops)}
+	problems := map[int]Problem{}
+	problem := func(op asm.Op, format string, args ...interface{}) {
+		if _, ok := problems[op.Offset]; !ok {
+			problems[op.Offset] = Problem{Offset: op.Offset, Opcode: opcodeName(op), Message: fmt.Sprintf(format, args...)}
+		}
+	}
+
+	paths := []*path{{stack: make([]item, inputs)}}
+	r.MaxStack = inputs
+	for _, op := range ops {
+		var next []*path
+		seen := map[string]bool{}
+		for _, p := range paths {
+			for _, q := range step(p, op, problem) {
+				if k := q.key(); !seen[k] {
+					seen[k] = true
+					next = append(next, q)
+				}
+				if n := len(q.stack) + len(q.alt); n > r.MaxStack {
+					r.MaxStack = n
+				}
+			}
+		}
+		if len(next) > maxPaths {
+			return nil, fmt.Errorf("script has more than %d paths", maxPaths)
+		}
+		if len(next) == 0 {
+			// every path failed, so there is nothing left to check
+			paths = nil
+			break
+		}
+		paths = next
+	}
+
+	heights := map[int]bool{}
+	for _, p := range paths {
+		if len(p.exec) > 0 && !p.done {
+			problem(ops[len(ops)-1], "script ends with %d unterminated OP_IF", len(p.exec))
+		}
+		heights[len(p.stack)] = true
+	}
+	r.Paths = len(paths)
+	for h := range heights {
+		r.FinalHeights = append(r.FinalHeights, h)
+	}
+	sort.Ints(r.FinalHeights)
+	for _, p := range problems {
+		r.Problems = append(r.Problems, p)
+	}
+	sort.Slice(r.Problems, func(i, j int) bool { return r.Problems[i].Offset < r.Problems[j].Offset })
+	return r, nil
+}
+
+func opcodeName(op asm.Op) string {
+	s := bscript.Script{op.Opcode}
+	if op.Opcode >= bscript.OpDATA1 && op.Opcode <= bscript.OpPUSHDATA4 {
+		return fmt.Sprintf("push of %d bytes", len(op.Data))
+	}
+	name, _ := s.ToASM()
+	return name
+}
+
+// step returns the paths after the opcode, none if the path fails
+func step(p *path, op asm.Op, problem func(asm.Op, string, ...interface{})) []*path {
+	if p.done {
+		return []*path{p}
+	}
+	p = p.clone()
+	fail := func(format string, args ...interface{}) []*path {
+		problem(op, format, args...)
+		return nil
+	}
+
+	// conditionals are followed whether or not the branch is executed
+	switch op.Opcode {
+	case bscript.OpIF, bscript.OpNOTIF:
+		if !p.executing() {
+			p.exec = append(p.exec, false)
+			return []*path{p}
+		}
+		if len(p.stack) < 1 {
+			return fail("needs 1 item, the stack is empty")
+		}
+		cond := p.pop()
+		if cond.known {
+			p.exec = append(p.exec, (cond.n != 0) == (op.Opcode == bscript.OpIF))
+			return []*path{p}
+		}
+		q := p.clone()
+		p.exec = append(p.exec, true)
+		q.exec = append(q.exec, false)
+		return []*path{p, q}
+	case bscript.OpELSE:
+		if len(p.exec) == 0 {
+			return fail("OP_ELSE without OP_IF")
+		}
+		p.exec[len(p.exec)-1] = !p.exec[len(p.exec)-1]
+		return []*path{p}
+	case bscript.OpENDIF:
+		if len(p.exec) == 0 {
+			return fail("OP_ENDIF without OP_IF")
+		}
+		p.exec = p.exec[:len(p.exec)-1]
+		return []*path{p}
+	case bscript.OpVERIF, bscript.OpVERNOTIF:
+		return fail("invalid opcode, even when not executed")
+	}
+	if !p.executing() {
+		return []*path{p}
+	}
+
+	// need checks the stack has n items
+	need := func(n int) bool {
+		if len(p.stack) < n {
+			problem(op, "needs %d items, the stack has %d", n, len(p.stack))
+			return false
+		}
+		return true
+	}
+	// effect pops n unknown items and pushes m
+	effect := func(n, m int) []*path {
+		if !need(n) {
+			return nil
+		}
+		p.stack = p.stack[:len(p.stack)-n]
+		for i := 0; i < m; i++ {
+			p.push(item{})
+		}
+		return []*path{p}
+	}
+	// permute replaces the top n items with the items at the positions from the top
+	permute := func(n int, positions ...int) []*path {
+		if !need(n) {
+			return nil
+		}
+		top := append([]item{}, p.stack[len(p.stack)-n:]...)
+		p.stack = p.stack[:len(p.stack)-n]
+		for _, i := range positions {
+			p.push(top[n-1-i])
+		}
+		return []*path{p}
+	}
+
+	switch {
+	case op.Opcode == bscript.Op0 || (op.Opcode >= bscript.OpDATA1 && op.Opcode <= bscript.OpPUSHDATA4):
+		n, ok := decodeNumber(op.Data)
+		p.push(item{known: ok, n: n})
+		return []*path{p}
+	case op.Opcode == bscript.Op1NEGATE:
+		p.push(item{known: true, n: -1})
+		return []*path{p}
+	case op.Opcode >= bscript.Op1 && op.Opcode <= bscript.Op16:
+		p.push(item{known: true, n: int64(op.Opcode-bscript.Op1) + 1})
+		return []*path{p}
+	case op.Opcode >= bscript.OpNOP1 && op.Opcode <= bscript.OpNOP10, op.Opcode == bscript.OpNOP, op.Opcode == bscript.OpCODESEPARATOR:
+		return []*path{p}
+	}
+
+	switch op.Opcode {
+	case bscript.OpRETURN:
+		p.done = true
+		return []*path{p}
+	case bscript.OpVERIFY:
+		return effect(1, 0)
+	case bscript.OpTOALTSTACK:
+		if !need(1) {
+			return nil
+		}
+		p.alt = append(p.alt, p.pop())
+		return []*path{p}
+	case bscript.OpFROMALTSTACK:
+		if len(p.alt) < 1 {
+			return fail("needs 1 item, the alt stack is empty")
+		}
+		p.push(p.alt[len(p.alt)-1])
+		p.alt = p.alt[:len(p.alt)-1]
+		return []*path{p}
+	case bscript.Op2DROP:
+		return permute(2)
+	case bscript.Op2DUP:
+		return permute(2, 1, 0, 1, 0)
+	case bscript.Op3DUP:
+		return permute(3, 2, 1, 0, 2, 1, 0)
+	case bscript.Op2OVER:
+		return permute(4, 3, 2, 1, 0, 3, 2)
+	case bscript.Op2ROT:
+		return permute(6, 3, 2, 1, 0, 5, 4)
+	case bscript.Op2SWAP:
+		return permute(4, 1, 0, 3, 2)
+	case bscript.OpIFDUP:
+		if !need(1) {
+			return nil
+		}
+		top := p.stack[len(p.stack)-1]
+		if top.known {
+			if top.n != 0 {
+				p.push(top)
+			}
+			return []*path{p}
+		}
+		q := p.clone()
+		q.push(top)
+		return []*path{p, q}
+	case bscript.OpDEPTH:
+		p.push(item{known: true, n: int64(len(p.stack))})
+		return []*path{p}
+	case bscript.OpDROP:
+		return permute(1)
+	case bscript.OpDUP:
+		return permute(1, 0, 0)
+	case bscript.OpNIP:
+		return permute(2, 0)
+	case bscript.OpOVER:
+		return permute(2, 1, 0, 1)
+	case bscript.OpPICK, bscript.OpROLL:
+		if !need(1) {
+			return nil
+		}
+		n := p.pop()
+		if !n.known {
+			return fail("depth is not known statically")
+		}
+		if n.n < 0 || int64(len(p.stack)) <= n.n {
+			return fail("depth %d is past the %d items on the stack", n.n, len(p.stack))
+		}
+		i := len(p.stack) - 1 - int(n.n)
+		picked := p.stack[i]
+		if op.Opcode == bscript.OpROLL {
+			p.stack = append(p.stack[:i], p.stack[i+1:]...)
+		}
+		p.push(picked)
+		return []*path{p}
+	case bscript.OpROT:
+		return permute(3, 1, 0, 2)
+	case bscript.OpSWAP:
+		return permute(2, 0, 1)
+	case bscript.OpTUCK:
+		return permute(2, 0, 1, 0)
+	case bscript.OpSIZE:
+		if !need(1) {
+			return nil
+		}
+		p.push(item{})
+		return []*path{p}
+	case bscript.OpCAT, bscript.OpNUM2BIN, bscript.OpAND, bscript.OpOR, bscript.OpXOR, bscript.OpEQUAL,
+		bscript.OpADD, bscript.OpSUB, bscript.OpMUL, bscript.OpDIV, bscript.OpMOD, bscript.OpLSHIFT, bscript.OpRSHIFT,
+		bscript.OpBOOLAND, bscript.OpBOOLOR, bscript.OpNUMEQUAL, bscript.OpNUMNOTEQUAL, bscript.OpLESSTHAN,
+		bscript.OpGREATERTHAN, bscript.OpLESSTHANOREQUAL, bscript.OpGREATERTHANOREQUAL, bscript.OpMIN, bscript.OpMAX,
+		bscript.OpCHECKSIG:
+		return effect(2, 1)
+	case bscript.OpSPLIT:
+		return effect(2, 2)
+	case bscript.OpBIN2NUM, bscript.OpINVERT, bscript.Op1ADD, bscript.Op1SUB, bscript.Op2MUL, bscript.Op2DIV,
+		bscript.OpNEGATE, bscript.OpABS, bscript.OpNOT, bscript.Op0NOTEQUAL,
+		bscript.OpRIPEMD160, bscript.OpSHA1, bscript.OpSHA256, bscript.OpHASH160, bscript.OpHASH256:
+		return effect(1, 1)
+	case bscript.OpEQUALVERIFY, bscript.OpNUMEQUALVERIFY, bscript.OpCHECKSIGVERIFY:
+		return effect(2, 0)
+	case bscript.OpWITHIN:
+		return effect(3, 1)
+	case bscript.OpCHECKMULTISIG, bscript.OpCHECKMULTISIGVERIFY:
+		return checkMultisig(p, op, need, fail)
+	}
+	return fail("invalid opcode")
+}
+
+// checkMultisig pops <dummy> <sig>... m <pubKey>... n, which needs n and m to be known
+func checkMultisig(p *path, op asm.Op, need func(int) bool, fail func(string, ...interface{}) []*path) []*path {
+	if !need(1) {
+		return nil
+	}
+	n := p.stack[len(p.stack)-1]
+	if !n.known || n.n < 0 || n.n > 20 {
+		return fail("number of public keys is not known statically")
+	}
+	if !need(int(n.n) + 2) {
+		return nil
+	}
+	m := p.stack[len(p.stack)-int(n.n)-2]
+	if !m.known || m.n < 0 || m.n > n.n {
+		return fail("number of signatures is not known statically")
+	}
+	if !need(int(n.n) + int(m.n) + 3) {
+		return nil
+	}
+	p.stack = p.stack[:len(p.stack)-int(n.n)-int(m.n)-3]
+	if op.Opcode == bscript.OpCHECKMULTISIG {
+		p.push(item{})
+	}
+	return []*path{p}
+}
+
+func (p *path) push(it item) {
+	p.stack = append(p.stack, it)
+}
+
+func (p *path) pop() item {
+	it := p.stack[len(p.stack)-1]
+	p.stack = p.stack[:len(p.stack)-1]
+	return it
+}
+
+// decodeNumber returns the script number of the bytes, or false if it is too long to be a number
+func decodeNumber(b []byte) (int64, bool) {
+	if len(b) > 8 {
+		return 0, false
+	}
+	if len(b) == 0 {
+		return 0, true
+	}
+	var n uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	signBit := uint64(0x80) << (8 * uint(len(b)-1))
+	if n&signBit != 0 {
+		return -int64(n &^ signBit), true
+	}
+	return int64(n), true
+}
@@ -0,0 +1,81 @@
+package analyzer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+)
+
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name             string
+		script           string
+		inputs           int
+		expectedHeights  []int
+		expectedMaxStack int
+		expectedProblem  string
+	}{
+		{"p2pkh", "OP_DUP OP_HASH160 00112233445566778899aabbccddeeff00112233 OP_EQUALVERIFY OP_CHECKSIG", 2, []int{1}, 4, ""},
+		{"underflow", "OP_DUP OP_HASH160 00112233445566778899aabbccddeeff00112233 OP_EQUALVERIFY OP_CHECKSIG", 1, nil, 3, "OP_CHECKSIG at 24: needs 2 items, the stack has 1"},
+		{"pick", "OP_2 OP_PICK OP_3 OP_ROLL", 3, []int{4}, 5, ""},
+		{"pick past the stack", "OP_3 OP_PICK", 3, nil, 4, "depth 3 is past the 3 items"},
+		{"unknown pick", "OP_SIZE OP_PICK", 2, nil, 3, "depth is not known statically"},
+		{"branches", "OP_IF OP_DROP OP_ELSE OP_DUP OP_ENDIF", 2, []int{0, 2}, 2, ""},
+		{"branch underflow", "OP_IF OP_ELSE OP_2DROP OP_ENDIF", 2, []int{1}, 2, "OP_2DROP at 2: needs 2 items, the stack has 1"},
+		{"constant condition", "OP_0 OP_IF OP_2DROP OP_ENDIF", 0, []int{0}, 1, ""},
+		{"else without if", "OP_1 OP_ELSE OP_ENDIF", 0, nil, 1, "OP_ELSE at 1: OP_ELSE without OP_IF"},
+		{"unterminated if", "OP_IF OP_1", 1, []int{0, 1}, 1, "script ends with 1 unterminated OP_IF"},
+		{"alt stack", "OP_TOALTSTACK OP_TOALTSTACK OP_FROMALTSTACK", 2, []int{1}, 2, ""},
+		{"empty alt stack", "OP_FROMALTSTACK", 1, nil, 1, "the alt stack is empty"},
+		{"multisig", "OP_2 01 02 03 OP_3 OP_CHECKMULTISIG", 4, []int{2}, 9, ""},
+		{"unknown multisig", "OP_CHECKMULTISIG", 4, nil, 4, "number of public keys is not known"},
+		{"return", "OP_RETURN OP_DROP", 0, []int{0}, 0, ""},
+		{"depth", "OP_DEPTH OP_PICK", 3, []int{4}, 4, "depth 3 is past the 3 items"},
+		{"invalid", "OP_0 OP_IF OP_VERIF OP_ENDIF", 0, []int{0}, 1, "invalid opcode, even when not executed"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := asm.Assemble(test.script, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Analyze(s, test.inputs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Size != len(*s) {
+				t.Errorf("%s failed: expected size %d, got %d", test.name, len(*s), r.Size)
+			}
+			if r.MaxStack != test.expectedMaxStack {
+				t.Errorf("%s failed: expected max stack %d, got %d", test.name, test.expectedMaxStack, r.MaxStack)
+			}
+			if test.expectedProblem == "" {
+				if err = r.Err(); err != nil {
+					t.Errorf("%s failed: %v", test.name, err)
+				}
+				if !reflect.DeepEqual(r.FinalHeights, test.expectedHeights) {
+					t.Errorf("%s failed: expected final heights %v, got %v", test.name, test.expectedHeights, r.FinalHeights)
+				}
+				return
+			}
+			if err = r.Err(); err == nil || !strings.Contains(err.Error(), test.expectedProblem) {
+				t.Errorf("%s failed: expected problem %q, got %v", test.name, test.expectedProblem, err)
+			}
+		})
+	}
+}
+
+func TestAnalyzeInvalidScript(t *testing.T) {
+	t.Parallel()
+	s, err := asm.Assemble("OP_PUSHDATA1:0102", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := (*s)[:len(*s)-1]
+	if _, err = Analyze(&truncated, 0); err == nil {
+		t.Error("expected a truncated push to be rejected")
+	}
+}
@@ -0,0 +1,356 @@
+// Package artifact drives contracts compiled by sCrypt from their JSON artifacts.
+//
+// The hex of an artifact is the locking script with <name> where each constructor
+// argument is pushed. Public functions are called by pushing their arguments in order,
+// followed by the index of the function if the contract has more than one.
+// SigHashPreimage arguments left nil are filled with the low s preimage of the
+// spending transaction, as UnlockPushTx does, and Sig and PubKey arguments may be
+// given as a Signer to sign that preimage. Finding the low s preimage may move nLockTime
+// up, which would invalidate signatures already made on other inputs, so unlocking fails
+// instead if another input is signed and nLockTime is not already low s. Call
+// pushtx.LowSLockTime for the contract inputs before signing the others. Structs, arrays
+// and stateful contracts are not supported.
+package artifact
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+// Artifact is a contract compiled by sCrypt
+type Artifact struct {
+	Version  int      `json:"version"`
+	Contract string   `json:"contract"`
+	ABI      []Entity `json:"abi"`
+	Hex      string   `json:"hex"`
+
+	// template is the hex split into script bytes and constructor placeholders
+	template []segment
+}
+
+// Entity is a constructor or public function of the ABI
+type Entity struct {
+	Type   string  `json:"type"`
+	Name   string  `json:"name"`
+	Index  int     `json:"index"`
+	Params []Param `json:"params"`
+}
+
+// Param is a parameter of a constructor or public function
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// segment is either fixed script bytes or the placeholder of a constructor parameter
+type segment struct {
+	fixed []byte
+	param string
+}
+
+// Load reads the artifact from a JSON file
+func Load(path string) (*Artifact, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(b)
+}
+
+// Parse reads the artifact from JSON
+func Parse(b []byte) (*Artifact, error) {
+	a := &Artifact{}
+	if err := json.Unmarshal(b, a); err != nil {
+		return nil, err
+	}
+	if a.Hex == "" {
+		return nil, errors.New("artifact has no hex, it may be from a compiler too old to be supported")
+	}
+	constructor := a.Constructor()
+	params := map[string]bool{}
+	for _, p := range constructor.Params {
+		params[p.Name] = true
+	}
+
+	rest := a.Hex
+	for rest != "" {
+		i := strings.IndexByte(rest, '<')
+		if i < 0 {
+			i = len(rest)
+		}
+		if i > 0 {
+			fixed, err := hex.DecodeString(rest[:i])
+			if err != nil {
+				return nil, fmt.Errorf("artifact hex: %w", err)
+			}
+			a.template = append(a.template, segment{fixed: fixed})
+			rest = rest[i:]
+			continue
+		}
+		end := strings.IndexByte(rest, '>')
+		if end < 0 {
+			return nil, errors.New("artifact hex has an unterminated placeholder")
+		}
+		name := rest[1:end]
+		if !params[name] {
+			return nil, fmt.Errorf("artifact hex has placeholder <%s> which is not a constructor parameter", name)
+		}
+		a.template = append(a.template, segment{param: name})
+		rest = rest[end+1:]
+	}
+	return a, nil
+}
+
+// Constructor returns the constructor of the ABI, which has no params if the ABI has none
+func (a *Artifact) Constructor() Entity {
+	for _, e := range a.ABI {
+		if e.Type == "constructor" {
+			return e
+		}
+	}
+	return Entity{Type: "constructor"}
+}
+
+// Function returns the public function of the name
+func (a *Artifact) Function(name string) (Entity, error) {
+	for _, e := range a.ABI {
+		if e.Type == "function" && e.Name == name {
+			return e, nil
+		}
+	}
+	return Entity{}, fmt.Errorf("%s has no public function %s", a.Contract, name)
+}
+
+// functions returns the number of public functions
+func (a *Artifact) functions() int {
+	n := 0
+	for _, e := range a.ABI {
+		if e.Type == "function" {
+			n++
+		}
+	}
+	return n
+}
+
+// LockingScript returns the locking script with the constructor arguments, in the order of the constructor params
+func (a *Artifact) LockingScript(args ...interface{}) (*bscript.Script, error) {
+	constructor := a.Constructor()
+	if len(args) != len(constructor.Params) {
+		return nil, fmt.Errorf("%s constructor takes %d arguments, got %d", a.Contract, len(constructor.Params), len(args))
+	}
+	pushes := map[string][]byte{}
+	for i, p := range constructor.Params {
+		s, err := appendArg(&bscript.Script{}, p, args[i])
+		if err != nil {
+			return nil, err
+		}
+		pushes[p.Name] = *s
+	}
+	s := &bscript.Script{}
+	for _, seg := range a.template {
+		if seg.param == "" {
+			*s = append(*s, seg.fixed...)
+		} else {
+			*s = append(*s, pushes[seg.param]...)
+		}
+	}
+	return s, nil
+}
+
+// Match reports whether the locking script is of the artifact with any constructor arguments
+func (a *Artifact) Match(s *bscript.Script) bool {
+	b := []byte(*s)
+	i := 0
+	for _, seg := range a.template {
+		if seg.param == "" {
+			if !bytes.HasPrefix(b[i:], seg.fixed) {
+				return false
+			}
+			i += len(seg.fixed)
+			continue
+		}
+		op, next, err := asm.DecodeOp(b, i)
+		if err != nil || op.Opcode > bscript.Op16 || op.Opcode == bscript.OpRESERVED {
+			return false
+		}
+		i = next
+	}
+	return i == len(b)
+}
+
+// Unlocker returns an unlocker calling the public function with the arguments, in the order of its params
+func (a *Artifact) Unlocker(function string, args ...interface{}) *Unlocker {
+	return &Unlocker{Artifact: a, Function: function, Args: args}
+}
+
+// Unlocker unlocks an output of the artifact by calling a public function
+type Unlocker struct {
+	Artifact *Artifact
+	Function string
+	Args     []interface{}
+}
+
+// UnlockingScript implements bt.Unlocker
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	function, err := u.Artifact.Function(u.Function)
+	if err != nil {
+		return nil, err
+	}
+	if len(u.Args) != len(function.Params) {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", function.Name, len(function.Params), len(u.Args))
+	}
+
+	// the preimage is found first, as finding a low s preimage changes nLockTime
+	params.SigHashFlags = pushtx.InputSigHashFlags(tx, params)
+	var preimage []byte
+	for i, p := range function.Params {
+		if p.Type == "SigHashPreimage" && u.Args[i] == nil {
+			lockTime := tx.LockTime
+			if preimage, err = pushtx.LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags); err != nil {
+				return nil, err
+			}
+			if tx.LockTime != lockTime && otherInputSigned(tx, params.InputIdx) {
+				tx.LockTime = lockTime
+				return nil, errors.New("finding a low s preimage would change nLockTime and invalidate the other signed inputs")
+			}
+			break
+		}
+	}
+
+	s := &bscript.Script{}
+	for i, p := range function.Params {
+		arg := u.Args[i]
+		switch v := arg.(type) {
+		case nil:
+			if p.Type != "SigHashPreimage" {
+				return nil, fmt.Errorf("argument %s of %s is nil", p.Name, function.Name)
+			}
+			arg = preimage
+		case pushtx.Signer:
+			if arg, err = signerArg(ctx, tx, params, p, v, &preimage); err != nil {
+				return nil, err
+			}
+		}
+		if s, err = appendArg(s, p, arg); err != nil {
+			return nil, err
+		}
+	}
+	if u.Artifact.functions() > 1 {
+		if s, err = script.AppendNumber(s, int64(function.Index)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// otherInputSigned checks if any input other than inputIdx has an unlocking script
+func otherInputSigned(tx *bt.Tx, inputIdx uint32) bool {
+	for i, in := range tx.Inputs {
+		if uint32(i) != inputIdx && in.UnlockingScript != nil && len(*in.UnlockingScript) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// signerArg returns the signature or public key of a Signer argument
+func signerArg(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams, p Param, signer pushtx.Signer, preimage *[]byte) (interface{}, error) {
+	switch p.Type {
+	case "PubKey":
+		return signer.PublicKey(ctx)
+	case "Sig":
+		if *preimage == nil {
+			var err error
+			if *preimage, err = tx.CalcInputPreimage(params.InputIdx, params.SigHashFlags); err != nil {
+				return nil, err
+			}
+		}
+		sig, err := signer.Sign(ctx, crypto.Sha256d(*preimage))
+		if err != nil {
+			return nil, err
+		}
+		return append(sig.Serialise(), byte(params.SigHashFlags)), nil
+	}
+	return nil, fmt.Errorf("argument %s of type %s can't be a Signer", p.Name, p.Type)
+}
+
+// byteLengths are the lengths of sCrypt types pushed as fixed length bytes
+var byteLengths = map[string]int{
+	"Ripemd160":  20,
+	"PubKeyHash": 20,
+	"Sha1":       20,
+	"Sha256":     32,
+}
+
+// appendArg pushes the argument the way sCrypt does for the type of the param
+func appendArg(s *bscript.Script, p Param, arg interface{}) (*bscript.Script, error) {
+	fail := func(expected string) (*bscript.Script, error) {
+		return nil, fmt.Errorf("argument %s of type %s must be %s, got %T", p.Name, p.Type, expected, arg)
+	}
+	var err error
+	switch p.Type {
+	case "int", "PrivKey":
+		switch v := arg.(type) {
+		case int:
+			return script.AppendNumber(s, int64(v))
+		case int64:
+			return script.AppendNumber(s, v)
+		case *big.Int:
+			if v.IsInt64() {
+				return script.AppendNumber(s, v.Int64())
+			}
+			err = s.AppendPushData(script.EncodeBigNumber(v))
+		default:
+			return fail("int, int64 or *big.Int")
+		}
+	case "bool":
+		v, ok := arg.(bool)
+		if !ok {
+			return fail("bool")
+		}
+		if v {
+			err = s.AppendOpcodes(bscript.OpTRUE)
+		} else {
+			err = s.AppendOpcodes(bscript.OpFALSE)
+		}
+	case "PubKey":
+		switch v := arg.(type) {
+		case *bec.PublicKey:
+			err = s.AppendPushData(v.SerialiseCompressed())
+		case []byte:
+			err = s.AppendPushData(v)
+		default:
+			return fail("*bec.PublicKey, []byte or a Signer")
+		}
+	case "bytes", "Sig", "SigHashPreimage", "SigHashType", "OpCodeType", "Ripemd160", "PubKeyHash", "Sha1", "Sha256":
+		v, ok := arg.([]byte)
+		if !ok {
+			return fail("[]byte")
+		}
+		if n, ok := byteLengths[p.Type]; ok && len(v) != n {
+			return nil, fmt.Errorf("argument %s of type %s must be %d bytes, got %d", p.Name, p.Type, n, len(v))
+		}
+		err = s.AppendPushData(v)
+	default:
+		return nil, fmt.Errorf("argument %s has unsupported type %s", p.Name, p.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -0,0 +1,190 @@
+package artifact
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+// testdata/owned_secret.json is a synthetic fixture written by hand in the sCrypt artifact format,
+// not compiler output, so it only has the fields Load reads
+func TestArtifact(t *testing.T) {
+	t.Parallel()
+	a, err := Load("testdata/owned_secret.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, owner := testutil.NewKey(t)
+	malloryKey, _ := testutil.NewKey(t)
+	address, err := bscript.NewAddressFromString(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkh, err := hex.DecodeString(address.PublicKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := a.LockingScript(pkh, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.Match(lockingScript) {
+		t.Error("expected the artifact to match its locking script")
+	}
+
+	var tests = []struct {
+		name          string
+		unlocker      bt.Unlocker
+		expectedError bool
+	}{
+		{"unlock with owner", a.Unlocker("unlock", nil, &pushtx.PrivateKeySigner{PrivateKey: ownerKey}, &pushtx.PrivateKeySigner{PrivateKey: ownerKey}), false},
+		{"unlock with public key", a.Unlocker("unlock", nil, &pushtx.PrivateKeySigner{PrivateKey: ownerKey}, ownerKey.PubKey()), false},
+		{"unlock with someone else", a.Unlocker("unlock", nil, &pushtx.PrivateKeySigner{PrivateKey: malloryKey}, &pushtx.PrivateKeySigner{PrivateKey: malloryKey}), true},
+		{"reveal", a.Unlocker("reveal", []byte("hello")), false},
+		{"reveal wrong length", a.Unlocker("reveal", []byte("hi")), true},
+		{"unknown function", a.Unlocker("steal"), true},
+		{"wrong argument count", a.Unlocker("reveal"), true},
+		{"wrong argument type", a.Unlocker("reveal", "hello"), true},
+		{"nil argument", a.Unlocker("reveal", nil), true},
+		{"signer for bytes", a.Unlocker("reveal", &pushtx.PrivateKeySigner{PrivateKey: ownerKey}), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(&bt.UTXO{TxID: make([]byte, 32), LockingScript: lockingScript, Satoshis: 10000}); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.PayToAddress(owner, 9000); err != nil {
+				t.Fatal(err)
+			}
+			err := tx.FillInput(context.Background(), test.unlocker, bt.UnlockerParams{})
+			if err == nil {
+				err = testutil.Verify(tx)
+			}
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected error", test.name)
+			}
+		})
+	}
+}
+
+func TestUnlockKeepsSignedInputs(t *testing.T) {
+	t.Parallel()
+	a, err := Load("testdata/owned_secret.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownerKey, owner := testutil.NewKey(t)
+	lockingScript, err := a.LockingScript(make([]byte, 20), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(&bt.UTXO{TxID: make([]byte, 32), LockingScript: lockingScript, Satoshis: 10000}, testutil.NewFundingUTXO(t, owner, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.PayToAddress(owner, 19000); err != nil {
+		t.Fatal(err)
+	}
+	// find an nLocktime the contract input can't be signed at, then sign the P2PKH input
+	for {
+		preimage, err := tx.CalcInputPreimage(0, sighash.AllForkID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pushtxpreimage.IsLowS(preimage) {
+			break
+		}
+		tx.LockTime++
+	}
+	lockTime := tx.LockTime
+	if err = tx.FillInput(context.Background(), &pushtx.UnlockP2PKH{Signer: &pushtx.PrivateKeySigner{PrivateKey: ownerKey}}, bt.UnlockerParams{InputIdx: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tx.FillInput(context.Background(), a.Unlocker("unlock", nil, &pushtx.PrivateKeySigner{PrivateKey: ownerKey}, ownerKey.PubKey()), bt.UnlockerParams{}); err == nil {
+		t.Error("expected unlocking to fail rather than change nLocktime under a signed input")
+	}
+	if tx.LockTime != lockTime {
+		t.Errorf("expected nLocktime to stay %d, got %d", lockTime, tx.LockTime)
+	}
+}
+
+func TestLockingScript(t *testing.T) {
+	t.Parallel()
+	a, err := Load("testdata/owned_secret.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkh := make([]byte, 20)
+	var tests = []struct {
+		name     string
+		args     []interface{}
+		expected string
+	}{
+		{"small int", []interface{}{pkh, 5}, "55"},
+		{"negative int", []interface{}{pkh, int64(-1)}, "4f"},
+		{"zero", []interface{}{pkh, 0}, "00"},
+		{"large int", []interface{}{pkh, int64(1000)}, "02e803"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := a.LockingScript(test.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the length is pushed after OP_SIZE
+			h := hex.EncodeToString(*s)
+			if h[len(h)-len(test.expected)-6:len(h)-6] != test.expected {
+				t.Errorf("%s failed: expected length pushed as %s in %s", test.name, test.expected, h)
+			}
+			if !a.Match(s) {
+				t.Errorf("%s failed: expected the artifact to match", test.name)
+			}
+		})
+	}
+
+	for _, args := range [][]interface{}{
+		{pkh},
+		{pkh[:19], 5},
+		{pkh, "5"},
+	} {
+		if _, err = a.LockingScript(args...); err == nil {
+			t.Errorf("expected arguments %v to be rejected", args)
+		}
+	}
+
+	key, _ := testutil.NewKey(t)
+	p2pkh, err := bscript.NewP2PKHFromPubKeyEC(key.PubKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Match(p2pkh) {
+		t.Error("expected P2PKH not to match")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	t.Parallel()
+	for _, j := range []string{
+		`{`,
+		`{"contract": "Empty"}`,
+		`{"hex": "zz"}`,
+		`{"hex": "00<x"}`,
+		`{"hex": "00<x>"}`,
+	} {
+		if _, err := Parse([]byte(j)); err == nil {
+			t.Errorf("expected %s to be rejected", j)
+		}
+	}
+}
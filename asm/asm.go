@@ -0,0 +1,313 @@
+// Package asm converts scripts to and from ASM text.
+//
+// Opcodes are written by name, and data pushes as hex:
+//
+//	OP_0 OP_PICK OP_HASH256 OP_1 OP_SPLIT ... PUSHTX_R OP_SWAP OP_CAT 41 OP_CAT PUSHTX_PUBKEY OP_CHECKSIGVERIFY
+//
+// Data which a label names, like the r and public key of the optimized OP_PUSH_TX
+// signature, is written as the label. Placeholders like <ownerPKH> are pushes of
+// data given when the ASM is assembled, so contract templates can be written as text.
+// Data pushed with a longer opcode than needed is written as the opcode and the hex
+// joined by a colon, OP_PUSHDATA1:0102, so any script disassembles to ASM which
+// assembles back to the same bytes. Text from # to the end of a line is a comment.
+package asm
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libsv/go-bt/v2/bscript"
+)
+
+// Labels names data pushed in scripts. Names are upper case and don't start with OP_
+type Labels map[string][]byte
+
+// PushTxLabels names the constants of the optimized OP_PUSH_TX script
+var PushTxLabels = Labels{
+	// DER prefix of the signature up to s, with r the x coordinate of the generator point
+	"PUSHTX_R": mustDecodeHex("3044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817980220"),
+	// public key the signature is checked against
+	"PUSHTX_PUBKEY": mustDecodeHex("02b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0"),
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// opcodeNames are the names opcodes are written as, and opcodes the names and aliases parsed
+var (
+	opcodeNames [256]string
+	opcodes     = map[string]byte{
+		"OP_0":                   bscript.Op0,
+		"OP_FALSE":               bscript.OpFALSE,
+		"OP_1":                   bscript.Op1,
+		"OP_TRUE":                bscript.OpTRUE,
+		"OP_RESERVED":            bscript.OpRESERVED,
+		"OP_CHECKLOCKTIMEVERIFY": bscript.OpCHECKLOCKTIMEVERIFY,
+		"OP_CHECKSEQUENCEVERIFY": bscript.OpCHECKSEQUENCEVERIFY,
+	}
+)
+
+func init() {
+	for i := range opcodeNames {
+		b := byte(i)
+		if isPush(b) {
+			continue
+		}
+		s := bscript.Script{b}
+		name, _ := s.ToASM()
+		opcodeNames[b] = name
+		opcodes[name] = b
+	}
+	opcodeNames[bscript.Op0] = "OP_0"
+	opcodeNames[bscript.Op1] = "OP_1"
+	for i, name := range []string{"OP_PUSHDATA1", "OP_PUSHDATA2", "OP_PUSHDATA4"} {
+		op := bscript.OpPUSHDATA1 + byte(i)
+		opcodeNames[op] = name
+		opcodes[name] = op
+	}
+}
+
+// isPush reports whether the opcode pushes the data following it
+func isPush(op byte) bool {
+	return op >= bscript.OpDATA1 && op <= bscript.OpPUSHDATA4
+}
+
+// Op is an opcode, or a push and its data, in a script
+type Op struct {
+	// Offset is the position of the opcode in the script
+	Offset int
+	Opcode byte
+	Data   []byte
+}
+
+// canonical reports whether the data is pushed with the opcode AppendPushData would use
+func (o Op) canonical() bool {
+	prefix, err := bscript.PushDataPrefix(o.Data)
+	return err == nil && prefix[0] == o.Opcode
+}
+
+// Decode splits a script into its opcodes
+func Decode(s *bscript.Script) ([]Op, error) {
+	b := []byte(*s)
+	var ops []Op
+	for i := 0; i < len(b); {
+		op, next, err := DecodeOp(b, i)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+		i = next
+	}
+	return ops, nil
+}
+
+// DecodeOp returns the opcode at offset in the script bytes, and the offset of the next
+func DecodeOp(b []byte, offset int) (Op, int, error) {
+	if offset < 0 || offset >= len(b) {
+		return Op{}, 0, fmt.Errorf("no opcode at %d", offset)
+	}
+	op := Op{Offset: offset, Opcode: b[offset]}
+	i := offset + 1
+	if !isPush(op.Opcode) {
+		return op, i, nil
+	}
+	var n int
+	switch op.Opcode {
+	case bscript.OpPUSHDATA1, bscript.OpPUSHDATA2, bscript.OpPUSHDATA4:
+		size := 1 << (op.Opcode - bscript.OpPUSHDATA1)
+		if len(b) < i+size {
+			return Op{}, 0, fmt.Errorf("%s at %d is missing its length", opcodeNames[op.Opcode], offset)
+		}
+		lenBuf := make([]byte, 4)
+		copy(lenBuf, b[i:i+size])
+		n = int(binary.LittleEndian.Uint32(lenBuf))
+		i += size
+	default:
+		n = int(op.Opcode)
+	}
+	if n < 0 || len(b)-i < n {
+		return Op{}, 0, fmt.Errorf("push of %d bytes at %d runs past the end of the script", n, offset)
+	}
+	op.Data = b[i : i+n]
+	return op, i + n, nil
+}
+
+// Disassemble returns the ASM of a script, labelling the optimized OP_PUSH_TX constants
+func Disassemble(s *bscript.Script) (string, error) {
+	return PushTxLabels.Disassemble(s)
+}
+
+// Disassemble returns the ASM of a script, writing data the labels name as the label
+func (l Labels) Disassemble(s *bscript.Script) (string, error) {
+	ops, err := Decode(s)
+	if err != nil {
+		return "", err
+	}
+	names := l.names()
+	tokens := make([]string, 0, len(ops))
+	for _, op := range ops {
+		tokens = append(tokens, l.token(op, names))
+	}
+	return strings.Join(tokens, " "), nil
+}
+
+// names returns the label names in order, so data two labels name is always written the same
+func (l Labels) names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l Labels) token(op Op, names []string) string {
+	if !isPush(op.Opcode) {
+		return opcodeNames[op.Opcode]
+	}
+	if !op.canonical() {
+		return opcodeNames[op.Opcode] + ":" + hex.EncodeToString(op.Data)
+	}
+	for _, name := range names {
+		if string(l[name]) == string(op.Data) {
+			return name
+		}
+	}
+	return hex.EncodeToString(op.Data)
+}
+
+// Assemble returns the script of the ASM, with the optimized OP_PUSH_TX labels and the
+// placeholders set to params. Every placeholder must be given
+func Assemble(asm string, params map[string][]byte) (*bscript.Script, error) {
+	return PushTxLabels.Assemble(asm, params)
+}
+
+// Assemble returns the script of the ASM, with the labels and the placeholders set to params.
+// Every placeholder must be given
+func (l Labels) Assemble(asm string, params map[string][]byte) (*bscript.Script, error) {
+	s := &bscript.Script{}
+	for _, t := range tokenize(asm) {
+		if err := l.appendToken(s, t.text, params); err != nil {
+			return nil, fmt.Errorf("line %d: %q: %w", t.line, t.text, err)
+		}
+	}
+	return s, nil
+}
+
+func (l Labels) appendToken(s *bscript.Script, text string, params map[string][]byte) error {
+	if name, ok := placeholder(text); ok {
+		data, ok := params[name]
+		if !ok {
+			return errors.New("placeholder not given")
+		}
+		return s.AppendPushData(data)
+	}
+	if op, ok := opcodes[text]; ok {
+		return s.AppendOpcodes(op)
+	}
+	if data, ok := l[text]; ok {
+		return s.AppendPushData(data)
+	}
+	if i := strings.IndexByte(text, ':'); i >= 0 {
+		return appendPush(s, text[:i], text[i+1:])
+	}
+	if strings.HasPrefix(text, "OP_") {
+		return errors.New("unknown opcode")
+	}
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return errors.New("expected an opcode, label, placeholder or hex")
+	}
+	return s.AppendPushData(data)
+}
+
+// appendPush appends data pushed with the push opcode named
+func appendPush(s *bscript.Script, name, data string) error {
+	op, ok := opcodes[name]
+	if !ok || !isPush(op) {
+		return errors.New("only push opcodes can be followed by data")
+	}
+	b, err := hex.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	*s = append(*s, op)
+	switch op {
+	case bscript.OpPUSHDATA1:
+		if len(b) > 0xff {
+			return errors.New("too much data for OP_PUSHDATA1")
+		}
+		*s = append(*s, byte(len(b)))
+	case bscript.OpPUSHDATA2:
+		if len(b) > 0xffff {
+			return errors.New("too much data for OP_PUSHDATA2")
+		}
+		lenBuf := make([]byte, 2)
+		binary.LittleEndian.PutUint16(lenBuf, uint16(len(b)))
+		*s = append(*s, lenBuf...)
+	case bscript.OpPUSHDATA4:
+		lenBuf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(lenBuf, uint32(len(b)))
+		*s = append(*s, lenBuf...)
+	}
+	*s = append(*s, b...)
+	return nil
+}
+
+// placeholder returns the name of a <name> token
+func placeholder(text string) (string, bool) {
+	if len(text) < 3 || text[0] != '<' || text[len(text)-1] != '>' {
+		return "", false
+	}
+	return text[1 : len(text)-1], true
+}
+
+// Fields splits ASM into its opcodes, labels, placeholders and hex, dropping comments
+func Fields(asm string) []string {
+	var fields []string
+	for _, t := range tokenize(asm) {
+		fields = append(fields, t.text)
+	}
+	return fields
+}
+
+// Placeholders returns the names of the placeholders in the ASM, in the order they first appear
+func Placeholders(asm string) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, t := range tokenize(asm) {
+		if name, ok := placeholder(t.text); ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type token struct {
+	text string
+	line int
+}
+
+// tokenize splits ASM on whitespace, dropping comments
+func tokenize(asm string) []token {
+	var tokens []token
+	for i, line := range strings.Split(asm, "\n") {
+		if c := strings.IndexByte(line, '#'); c >= 0 {
+			line = line[:c]
+		}
+		for _, text := range strings.Fields(line) {
+			tokens = append(tokens, token{text: text, line: i + 1})
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,155 @@
+package asm_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+func TestAssembleTemplate(t *testing.T) {
+	t.Parallel()
+	_, owner := testutil.NewKey(t)
+	a, err := bscript.NewAddressFromString(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkh, err := hex.DecodeString(a.PublicKeyHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template, err := ioutil.ReadFile("testdata/pushtx_p2pkh.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placeholders := asm.Placeholders(string(template)); !reflect.DeepEqual(placeholders, []string{"ownerPKH"}) {
+		t.Errorf("expected placeholders [ownerPKH], got %v", placeholders)
+	}
+
+	s, err := asm.Assemble(string(template), map[string][]byte{"ownerPKH": pkh})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := script.AppendPushTx(&bscript.Script{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, err = script.AppendP2PKH(expected, owner); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(*s, *expected) {
+		t.Errorf("expected %x, got %x", *expected, *s)
+	}
+
+	if _, err = asm.Assemble(string(template), nil); err == nil || !strings.Contains(err.Error(), "line 15") {
+		t.Errorf("expected missing placeholder on line 15 to fail, got %v", err)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	t.Parallel()
+	pushTx, err := script.AppendPushTx(&bscript.Script{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name   string
+		script string
+		asm    string
+	}{
+		{"push tx", pushTx.String(), "OP_0 OP_PICK OP_HASH256 OP_1 OP_SPLIT OP_SWAP OP_BIN2NUM OP_1ADD OP_SWAP OP_CAT PUSHTX_R OP_SWAP OP_CAT 41 OP_CAT PUSHTX_PUBKEY OP_CHECKSIGVERIFY OP_DROP"},
+		{"empty", "", ""},
+		{"pushdata1", "4c4c" + strings.Repeat("ab", 0x4c), strings.Repeat("ab", 0x4c)},
+		{"non minimal pushdata1", "4c0201027c", "OP_PUSHDATA1:0102 OP_SWAP"},
+		{"non minimal pushdata2", "4d0000", "OP_PUSHDATA2:"},
+		{"non minimal pushdata4", "4e01000000ff", "OP_PUSHDATA4:ff"},
+		{"locktime", "b1b2ba", "OP_NOP2 OP_NOP3 OP_UNKNOWN186"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := bscript.NewFromHexString(test.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text, err := asm.Disassemble(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if text != test.asm {
+				t.Errorf("%s failed: expected %q, got %q", test.name, test.asm, text)
+			}
+			assembled, err := asm.Assemble(text, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(*assembled, *s) {
+				t.Errorf("%s failed: expected %x to round trip, got %x", test.name, *s, *assembled)
+			}
+		})
+	}
+}
+
+func TestDisassembleTruncated(t *testing.T) {
+	t.Parallel()
+	for _, h := range []string{"02ab", "4c", "4c02ab", "4d01", "4e0100"} {
+		s, err := bscript.NewFromHexString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = asm.Disassemble(s); err == nil {
+			t.Errorf("expected %s to fail", h)
+		}
+	}
+}
+
+func TestAssembleInvalid(t *testing.T) {
+	t.Parallel()
+	for _, text := range []string{
+		"OP_NOTANOPCODE",
+		"zz",
+		"abc",
+		"OP_DUP:00",
+		"OP_PUSHDATA1:" + strings.Repeat("00", 0x100),
+		"<missing>",
+		"PUSHTX_UNKNOWN",
+	} {
+		if _, err := asm.Assemble(text, nil); err == nil {
+			t.Errorf("expected %q to fail", text)
+		}
+	}
+
+	// aliases assemble to the same opcode
+	s, err := asm.Assemble("OP_FALSE OP_TRUE OP_CHECKLOCKTIMEVERIFY OP_CHECKSEQUENCEVERIFY # comment OP_DUP", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(*s) != "0051b1b2" {
+		t.Errorf("expected 0051b1b2, got %x", *s)
+	}
+}
+
+func TestCustomLabels(t *testing.T) {
+	t.Parallel()
+	labels := asm.Labels{"MAGIC": []byte("magic")}
+	s, err := labels.Assemble("MAGIC OP_EQUAL", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := labels.Disassemble(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "MAGIC OP_EQUAL" {
+		t.Errorf("expected MAGIC OP_EQUAL, got %q", text)
+	}
+	if text, _ = asm.Disassemble(s); text != hex.EncodeToString([]byte("magic"))+" OP_EQUAL" {
+		t.Errorf("expected the push tx labels not to name magic, got %q", text)
+	}
+}
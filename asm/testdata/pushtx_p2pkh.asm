@@ -0,0 +1,15 @@
+# optimized OP_PUSH_TX with the preimage on top of the stack
+
+# hash the preimage, and add 1 to the first byte to get s
+OP_0 OP_PICK OP_HASH256
+OP_1 OP_SPLIT OP_SWAP OP_BIN2NUM OP_1ADD
+OP_SWAP OP_CAT
+
+# signature of the preimage with r fixed by k = 1, and the sighash flag ALL|FORKID
+PUSHTX_R OP_SWAP OP_CAT
+41 OP_CAT
+PUSHTX_PUBKEY OP_CHECKSIGVERIFY
+OP_DROP
+
+# owned by a P2PKH address
+OP_DUP OP_HASH160 <ownerPKH> OP_EQUALVERIFY OP_CHECKSIG
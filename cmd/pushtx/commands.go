@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/bscript/interpreter"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+	"github.com/murray-distributed-technologies/go-pushtx/preimage"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+// build spends a utxo to a push tx output, printing the transaction hex
+func (c *cli) build(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("build")
+	var keys keyFlags
+	var utxos utxoFlags
+	keys.register(fs)
+	utxos.register(fs)
+	address := fs.String("address", "", "owner of the push tx output (default the address of the key)")
+	change := fs.String("change", "", "change address (default the address of the key)")
+	satoshis := fs.Uint64("satoshis", 0, "satoshis locked in the push tx output")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *satoshis == 0 {
+		return errors.New("set -satoshis")
+	}
+	privateKey, err := c.key(&keys)
+	if err != nil {
+		return err
+	}
+	utxo, err := c.utxo(ctx, &utxos)
+	if err != nil {
+		return err
+	}
+	if *address, err = defaultAddress(*address, privateKey); err != nil {
+		return err
+	}
+	if *change, err = defaultAddress(*change, privateKey); err != nil {
+		return err
+	}
+
+	input := &bt.Input{
+		PreviousTxSatoshis: utxo.Satoshis,
+		PreviousTxScript:   utxo.LockingScript,
+		PreviousTxOutIndex: utxo.Vout,
+	}
+	result, err := pushtx.BuildOpPushTransaction(ctx, input, utxo.TxIDStr(), *address, *change, privateKey, *satoshis)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "txid %s fee %d nLocktime %d low s iterations %d\n", result.TxID, result.Fee, result.LockTime, result.Iterations)
+	fmt.Fprintln(c.stdout, result.Tx.String())
+	return nil
+}
+
+// spend spends a push tx utxo to an address, less the fee, printing the transaction hex
+func (c *cli) spend(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("spend")
+	var keys keyFlags
+	var utxos utxoFlags
+	keys.register(fs)
+	utxos.register(fs)
+	address := fs.String("address", "", "address paid (default the address of the key)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	privateKey, err := c.key(&keys)
+	if err != nil {
+		return err
+	}
+	utxo, err := c.utxo(ctx, &utxos)
+	if err != nil {
+		return err
+	}
+	if *address, err = defaultAddress(*address, privateKey); err != nil {
+		return err
+	}
+
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return err
+	}
+	if err = tx.PayToAddress(*address, 0); err != nil {
+		return err
+	}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, &pushtx.Getter{PrivateKey: privateKey}, 0, bt.NewFeeQuote()); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "txid %s fee %d nLocktime %d\n", tx.TxID(), tx.TotalInputSatoshis()-tx.TotalOutputSatoshis(), tx.LockTime)
+	fmt.Fprintln(c.stdout, tx.String())
+	return nil
+}
+
+func defaultAddress(address string, privateKey *bec.PrivateKey) (string, error) {
+	if address != "" {
+		return address, nil
+	}
+	a, err := bscript.NewAddressFromPublicKey(privateKey.PubKey(), true)
+	if err != nil {
+		return "", err
+	}
+	return a.AddressString, nil
+}
+
+// decodePreimage prints each field of a preimage with its offset and meaning
+func (c *cli) decodePreimage(args []string) error {
+	fs := c.newFlagSet("preimage decode")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	b, err := hexArg(fs, "preimage")
+	if err != nil {
+		return err
+	}
+	p, err := preimage.ParseBytes(b)
+	if err != nil {
+		return err
+	}
+
+	scriptLen, size := bt.NewVarIntFromBytes(p.ScriptCode)
+	scriptCode := bscript.Script(p.ScriptCode[size:])
+	scriptASM, err := asm.Disassemble(&scriptCode)
+	if err != nil {
+		scriptASM = "invalid script: " + err.Error()
+	}
+	flag := sighash.Flag(binary.LittleEndian.Uint32(p.Sighash))
+	lowS := "no"
+	if preimage.IsLowS(b) {
+		lowS = "yes"
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	offset := 0
+	field := func(name string, value []byte, meaning string) {
+		fmt.Fprintf(w, "%s\t%d-%d\t%x\t%s\n", name, offset, offset+len(value), value, meaning)
+		offset += len(value)
+	}
+	field("nVersion", p.NVersion, fmt.Sprint(binary.LittleEndian.Uint32(p.NVersion)))
+	field("hashPrevouts", p.HashPrevouts, "")
+	field("hashSequence", p.HashSequence, "")
+	field("outpoint", p.Outpoint, fmt.Sprintf("%x:%d", bt.ReverseBytes(p.Outpoint[:32]), binary.LittleEndian.Uint32(p.Outpoint[32:])))
+	field("scriptCode", p.ScriptCode, fmt.Sprintf("%d bytes: %s", scriptLen, scriptASM))
+	field("value", p.Value, fmt.Sprintf("%d satoshis", binary.LittleEndian.Uint64(p.Value)))
+	field("nSequence", p.NSequence, fmt.Sprint(binary.LittleEndian.Uint32(p.NSequence)))
+	field("hashOutputs", p.HashOutputs, "")
+	field("nLocktime", p.NLocktime, fmt.Sprint(binary.LittleEndian.Uint32(p.NLocktime)))
+	field("sighash", p.Sighash, flag.String())
+	fmt.Fprintf(w, "sha256d\t\t%x\tlow s: %s\n", bt.ReverseBytes(crypto.Sha256d(b)), lowS)
+	return w.Flush()
+}
+
+// disasm prints the ASM of a script, labelling the push tx constants
+func (c *cli) disasm(args []string) error {
+	fs := c.newFlagSet("script disasm")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	b, err := hexArg(fs, "script")
+	if err != nil {
+		return err
+	}
+	s := bscript.Script(b)
+	scriptASM, err := asm.Disassemble(&s)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.stdout, scriptASM)
+	return nil
+}
+
+// verify runs every input of a transaction through the script interpreter
+func (c *cli) verify(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("verify")
+	var utxos fixtures
+	fs.Var(&utxos, "fixture", "JSON file of the utxos spent, may be repeated")
+	offline := fs.Bool("offline", false, "fail instead of fetching utxos missing from the fixtures")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	b, err := hexArg(fs, "transaction")
+	if err != nil {
+		return err
+	}
+	tx, err := bt.NewTxFromBytes(b)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i, in := range tx.Inputs {
+		utxo := utxos.find(in.PreviousTxIDStr(), in.PreviousTxOutIndex)
+		if utxo == nil && *offline {
+			return fmt.Errorf("input %d spends %s:%d which is not in the fixtures", i, in.PreviousTxIDStr(), in.PreviousTxOutIndex)
+		}
+		if utxo == nil {
+			if utxo, err = c.fetch(ctx, in.PreviousTxIDStr(), in.PreviousTxOutIndex); err != nil {
+				return fmt.Errorf("input %d: %w", i, err)
+			}
+		}
+		prevOutput := &bt.Output{Satoshis: utxo.Satoshis, LockingScript: utxo.LockingScript}
+		if err = interpreter.NewEngine().Execute(
+			interpreter.WithTx(tx, i, prevOutput),
+			interpreter.WithForkID(),
+			interpreter.WithAfterGenesis(),
+		); err != nil {
+			failed++
+			fmt.Fprintf(c.stdout, "input %d: %v\n", i, err)
+			continue
+		}
+		fmt.Fprintf(c.stdout, "input %d: ok\n", i)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d inputs failed verification", failed, len(tx.Inputs))
+	}
+	return nil
+}
+
+// lowS malleates the nLocktime of a preimage until it is low s, printing the new preimage hex
+func (c *cli) lowS(ctx context.Context, args []string) error {
+	fs := c.newFlagSet("lows")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	b, err := hexArg(fs, "preimage")
+	if err != nil {
+		return err
+	}
+	if _, err = preimage.ParseBytes(b); err != nil {
+		return err
+	}
+	start := binary.LittleEndian.Uint32(b[len(b)-8:])
+	lowS, lockTime, err := preimage.CheckForLowSContext(ctx, b)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stderr, "nLocktime %d low s iterations %d\n", lockTime, lockTime-start+1)
+	fmt.Fprintln(c.stdout, hex.EncodeToString(lowS))
+	return nil
+}
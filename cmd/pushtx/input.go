@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/wif"
+	"github.com/libsv/go-bt/v2"
+)
+
+// errUsage is returned when a command is run with bad arguments, after the usage has been printed
+var errUsage = errors.New("invalid arguments")
+
+// newFlagSet returns a flag set that prints its usage to stderr and returns errors instead of exiting
+func (c *cli) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
+
+// keyFlags are the flags for reading a private key
+type keyFlags struct {
+	file string
+	env  string
+}
+
+func (k *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&k.file, "key-file", "", "file holding the WIF of the private key")
+	fs.StringVar(&k.env, "key-env", "PUSHTX_WIF", "environment variable holding the WIF, if -key-file is not set")
+}
+
+// key reads the WIF from the key file, or the environment if there is no file
+func (c *cli) key(k *keyFlags) (*bec.PrivateKey, error) {
+	var s string
+	if k.file != "" {
+		b, err := ioutil.ReadFile(k.file)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	} else {
+		s = c.getenv(k.env)
+		if s == "" {
+			return nil, fmt.Errorf("no private key, set -key-file or %s", k.env)
+		}
+	}
+	w, err := wif.DecodeWIF(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decoding private key: %w", err)
+	}
+	return w.PrivKey, nil
+}
+
+// fixtures collects the utxos from every -fixture flag
+type fixtures []*bt.UTXO
+
+func (f *fixtures) String() string {
+	return fmt.Sprintf("%d utxos", len(*f))
+}
+
+// Set reads a file holding a utxo, or an array of them
+func (f *fixtures) Set(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var utxos []*bt.UTXO
+	if err = json.Unmarshal(b, &utxos); err != nil {
+		utxo := &bt.UTXO{}
+		if err = json.Unmarshal(b, utxo); err != nil {
+			return fmt.Errorf("reading fixture %s: %w", path, err)
+		}
+		utxos = []*bt.UTXO{utxo}
+	}
+	*f = append(*f, utxos...)
+	return nil
+}
+
+// find returns the fixture for the outpoint, or nil if there is none
+func (f fixtures) find(txid string, vout uint32) *bt.UTXO {
+	for _, u := range f {
+		if u.TxIDStr() == txid && u.Vout == vout {
+			return u
+		}
+	}
+	return nil
+}
+
+// parseOutpoint parses txid:vout
+func parseOutpoint(s string) (string, uint32, error) {
+	i := strings.LastIndex(s, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("outpoint %q is not txid:vout", s)
+	}
+	txid := s[:i]
+	if b, err := hex.DecodeString(txid); err != nil || len(b) != 32 {
+		return "", 0, fmt.Errorf("outpoint %q has a bad txid", s)
+	}
+	vout, err := strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("outpoint %q has a bad vout", s)
+	}
+	return txid, uint32(vout), nil
+}
+
+// utxoFlags are the flags for choosing the utxo to spend
+type utxoFlags struct {
+	outpoint string
+	fixtures fixtures
+}
+
+func (u *utxoFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&u.outpoint, "utxo", "", "txid:vout of the utxo to spend")
+	fs.Var(&u.fixtures, "fixture", "JSON file of utxos to use instead of fetching them, may be repeated")
+}
+
+// utxo returns the utxo given by -utxo, from the fixtures or else fetched.
+// With no -utxo, the fixtures must hold exactly one utxo
+func (c *cli) utxo(ctx context.Context, u *utxoFlags) (*bt.UTXO, error) {
+	if u.outpoint == "" {
+		if len(u.fixtures) != 1 {
+			return nil, errors.New("set -utxo, or -fixture with a single utxo")
+		}
+		return u.fixtures[0], nil
+	}
+	txid, vout, err := parseOutpoint(u.outpoint)
+	if err != nil {
+		return nil, err
+	}
+	if utxo := u.fixtures.find(txid, vout); utxo != nil {
+		return utxo, nil
+	}
+	return c.fetch(ctx, txid, vout)
+}
+
+// hexArg returns the single hex argument of a command
+func hexArg(fs *flag.FlagSet, what string) ([]byte, error) {
+	if fs.NArg() != 1 {
+		return nil, fmt.Errorf("expected a single %s argument", what)
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(fs.Arg(0)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", what, err)
+	}
+	return b, nil
+}
@@ -0,0 +1,91 @@
+// Command pushtx builds, spends and inspects OP_PUSH_TX transactions.
+//
+// Usage:
+//
+//	pushtx build -utxo <txid:vout> | -fixture <file> -address <address> -satoshis <n> [-change <address>]
+//	pushtx spend -utxo <txid:vout> | -fixture <file> -address <address>
+//	pushtx preimage decode <hex>
+//	pushtx script disasm <hex>
+//	pushtx verify [-fixture <file>]... [-offline] <tx hex>
+//	pushtx lows <preimage hex>
+//
+// Keys are WIFs read from the file given by -key-file, or else from the
+// environment variable named by -key-env (PUSHTX_WIF by default).
+// Fixtures are JSON utxos, or arrays of them, in the format
+// {"txid": "...", "vout": 0, "lockingScript": "...", "satoshis": 1000}
+// so transactions can be built and verified without fetching from WhatsOnChain.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/woc"
+)
+
+const usage = `usage: pushtx <command> [arguments]
+
+commands:
+  build            create a push tx output from a utxo
+  spend            spend a push tx utxo
+  preimage decode  annotated dump of a hex preimage
+  script disasm    disassemble a hex script
+  verify           run a transaction through the script interpreter
+  lows             grind the nLocktime of a preimage until it is low s
+`
+
+// cli holds everything a command reads from or writes to outside its arguments
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+	// fetch looks up a utxo that was not given as a fixture
+	fetch func(ctx context.Context, txid string, vout uint32) (*bt.UTXO, error)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	c := &cli{stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv, fetch: woc.GetUTXO}
+	if err := c.run(ctx, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "pushtx:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(c.stderr, usage)
+		return errUsage
+	}
+	switch args[0] {
+	case "build":
+		return c.build(ctx, args[1:])
+	case "spend":
+		return c.spend(ctx, args[1:])
+	case "preimage":
+		if len(args) < 2 || args[1] != "decode" {
+			return fmt.Errorf("unknown preimage command, expected decode")
+		}
+		return c.decodePreimage(args[2:])
+	case "script":
+		if len(args) < 2 || args[1] != "disasm" {
+			return fmt.Errorf("unknown script command, expected disasm")
+		}
+		return c.disasm(args[2:])
+	case "verify":
+		return c.verify(ctx, args[1:])
+	case "lows":
+		return c.lowS(ctx, args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(c.stdout, usage)
+		return nil
+	default:
+		fmt.Fprint(c.stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/libsv/go-bk/chaincfg"
+	"github.com/libsv/go-bk/wif"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+)
+
+// newCLI returns a cli that reads the WIF from PUSHTX_WIF and never fetches utxos
+func newCLI(w string) (*cli, *bytes.Buffer) {
+	stdout := &bytes.Buffer{}
+	return &cli{
+		stdout: stdout,
+		stderr: ioutil.Discard,
+		getenv: func(name string) string {
+			if name == "PUSHTX_WIF" {
+				return w
+			}
+			return ""
+		},
+		fetch: func(ctx context.Context, txid string, vout uint32) (*bt.UTXO, error) {
+			return nil, errors.New("offline")
+		},
+	}, stdout
+}
+
+func writeFixture(t *testing.T, utxo *bt.UTXO) string {
+	t.Helper()
+	b, err := json.Marshal(utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "utxo.json")
+	if err = ioutil.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildSpendVerify(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	privateKey, address := testutil.NewKey(t)
+	w, err := wif.NewWIF(privateKey, &chaincfg.MainNet, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding := testutil.NewFundingUTXO(t, address, 100000)
+	fundingFixture := writeFixture(t, funding)
+
+	c, stdout := newCLI(w.String())
+	if err = c.run(ctx, []string{"build", "-fixture", fundingFixture, "-satoshis", "90000"}); err != nil {
+		t.Fatal(err)
+	}
+	built, err := bt.NewTxFromString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if err = c.run(ctx, []string{"verify", "-offline", "-fixture", fundingFixture, built.String()}); err != nil {
+		t.Fatalf("built transaction failed verification: %v\n%s", err, stdout)
+	}
+
+	// the push tx output is spent with the key read from a file instead
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err = ioutil.WriteFile(keyFile, []byte(w.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, stdout = newCLI("")
+	pushTxFixture := writeFixture(t, testutil.OutputUTXO(built, 0))
+	if err = c.run(ctx, []string{"spend", "-key-file", keyFile, "-fixture", pushTxFixture, "-utxo", built.TxID() + ":0"}); err != nil {
+		t.Fatal(err)
+	}
+	spend, err := bt.NewTxFromString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spend.Outputs[0].Satoshis >= 90000 || spend.Outputs[0].Satoshis < 89000 {
+		t.Errorf("expected the spend to pay 90000 less a small fee, got %d", spend.Outputs[0].Satoshis)
+	}
+
+	stdout.Reset()
+	if err = c.run(ctx, []string{"verify", "-fixture", pushTxFixture, spend.String()}); err != nil {
+		t.Fatalf("spend failed verification: %v\n%s", err, stdout)
+	}
+	if stdout.String() != "input 0: ok\n" {
+		t.Errorf("unexpected verify output %q", stdout)
+	}
+
+	// a missing fixture is fetched, and fails offline
+	if err = c.run(ctx, []string{"verify", spend.String()}); err == nil {
+		t.Error("expected verify without the spent utxo to fail")
+	}
+
+	// a tampered transaction fails verification
+	spend.Outputs[0].Satoshis--
+	stdout.Reset()
+	if err = c.run(ctx, []string{"verify", "-fixture", pushTxFixture, spend.String()}); err == nil {
+		t.Error("expected tampered transaction to fail verification")
+	}
+	if !strings.HasPrefix(stdout.String(), "input 0: ") || strings.Contains(stdout.String(), "ok") {
+		t.Errorf("unexpected verify output %q", stdout)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, address := testutil.NewKey(t)
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(testutil.NewFundingUTXO(t, address, 100000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.PayToAddress(address, 90000); err != nil {
+		t.Fatal(err)
+	}
+	tx.LockTime = 1000
+	preimage, err := tx.CalcInputPreimage(0, sighash.AllForkID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, stdout := newCLI("")
+	if err = c.run(ctx, []string{"preimage", "decode", hex.EncodeToString(preimage)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"nLocktime", " 1000\n",
+		" 100000 satoshis\n",
+		" ALL|FORKID\n",
+		tx.Inputs[0].PreviousTxIDStr() + ":0",
+		"OP_DUP OP_HASH160",
+	} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("expected decoded preimage to contain %q, got\n%s", expected, stdout)
+		}
+	}
+
+	stdout.Reset()
+	if err = c.run(ctx, []string{"script", "disasm", tx.Outputs[0].LockingScript.String()}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout.String(), "OP_DUP OP_HASH160 ") {
+		t.Errorf("unexpected disassembly %q", stdout)
+	}
+
+	stdout.Reset()
+	if err = c.run(ctx, []string{"lows", hex.EncodeToString(preimage)}); err != nil {
+		t.Fatal(err)
+	}
+	lowS, err := hex.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pushtxpreimage.IsLowS(lowS) {
+		t.Error("expected lows to return a low s preimage")
+	}
+	if lockTime := binary.LittleEndian.Uint32(lowS[len(lowS)-8:]); lockTime < 1000 {
+		t.Errorf("expected nLocktime to count up from 1000, got %d", lockTime)
+	}
+
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"preimage", "encode"},
+		{"preimage", "decode", "zz"},
+		{"script"},
+		{"lows", ""},
+		{"lows", "00"},
+		{"build", "-satoshis", "1000", "-utxo", "nope"},
+		{"build", "-satoshis", "1000"},
+		{"build", "-not-a-flag"},
+	} {
+		if err = c.run(ctx, args); err == nil {
+			t.Errorf("expected %q to fail", args)
+		}
+	}
+}
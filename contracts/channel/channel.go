@@ -0,0 +1,415 @@
+package channel
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Unidirectional Payment Channel
+------------------------------
+
+Locking Script: <Optimized OP_PUSH_TX> <close or refund> OP_RETURN <funderPKH> <payeePKH> <timeout>
+
+Close: <amount> <change> <funderSig> <funderPubKey> 1 <payeeSig> <payeePubKey> <preimage>
+Refund: 0 <funderSig> <funderPubKey> <preimage>
+
+The funder pays the payee off-chain by signing close transactions paying increasing amounts.
+Closing needs both signatures, and the covenant requires output 0 to pay amount to the payee and
+output 1 to pay change to the funder, with no other outputs. What is left is the fee, paid by the funder.
+The payee should close with the latest update before timeout, after which the funder alone can take
+the whole channel back.
+
+Updates are signed with nLocktime already low s, as closing can't malleate it without invalidating
+the funder signature, and with a non-final nSequence and nLocktime before timeout so nLocktime never
+holds the close back until the funder can refund.
+*/
+
+const (
+	// TermsLength is the length of the terms at the end of the locking script
+	TermsLength = 44
+)
+
+// Terms of a payment channel
+type Terms struct {
+	FunderPKH []byte
+	PayeePKH  []byte
+	Timeout   uint32
+}
+
+// NewTerms returns the terms of a channel from the funder to the payee, refundable at timeout
+func NewTerms(funderAddress, payeeAddress string, timeout uint32) (*Terms, error) {
+	var pkhs [2][]byte
+	for i, address := range []string{funderAddress, payeeAddress} {
+		a, err := bscript.NewAddressFromString(address)
+		if err != nil {
+			return nil, err
+		}
+		if pkhs[i], err = hex.DecodeString(a.PublicKeyHash); err != nil {
+			return nil, err
+		}
+	}
+	return &Terms{FunderPKH: pkhs[0], PayeePKH: pkhs[1], Timeout: timeout}, nil
+}
+
+// NewLockingScript returns the payment channel locking script for the terms
+func NewLockingScript(terms *Terms) (*bscript.Script, error) {
+	if len(terms.FunderPKH) != 20 || len(terms.PayeePKH) != 20 {
+		return nil, errors.New("public key hashes must be 20 bytes")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+	b.Opcodes(bscript.Op3, bscript.OpROLL)
+
+	// close
+	b.Opcodes(bscript.OpIF, bscript.OpROT, bscript.OpROT)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendCheckSigPKHVerify(s, terms.PayeePKH)
+	})
+	b.Opcodes(bscript.OpROT, bscript.OpROT)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendCheckSigPKHVerify(s, terms.FunderPKH)
+	})
+
+	// stack: <amount> <change> <preimage>
+	// amount > 0, change >= 0 and amount + change <= value
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHAN, bscript.OpVERIFY)
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+	b.Opcodes(bscript.OpADD, bscript.OpOVER)
+	b.Append(script.AppendGetValueFromPreimage)
+	b.PushData([]byte{0x00})
+	b.Opcodes(bscript.OpCAT, bscript.OpBIN2NUM, bscript.OpLESSTHANOREQUAL, bscript.OpVERIFY)
+
+	// outputs are exactly <amount to payee> <change to funder>
+	b.Opcodes(bscript.OpSWAP, bscript.Op8, bscript.OpNUM2BIN)
+	b.PushData(terms.FunderPKH)
+	b.Append(script.AppendBuildP2PKHOutput)
+	b.Opcodes(bscript.OpROT, bscript.Op8, bscript.OpNUM2BIN)
+	b.PushData(terms.PayeePKH)
+	b.Append(script.AppendBuildP2PKHOutput)
+	b.Opcodes(bscript.OpSWAP, bscript.OpCAT, bscript.OpSWAP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Append(script.AppendVerifyOutputs)
+	b.Opcodes(bscript.OpTRUE)
+
+	// refund
+	b.Opcodes(bscript.OpELSE)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendCheckLockTime(s, terms.Timeout) })
+	b.Opcodes(bscript.OpDROP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendCheckSigPKHVerify(s, terms.FunderPKH)
+	})
+	b.Opcodes(bscript.OpTRUE, bscript.OpENDIF)
+
+	// terms
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeTerms(terms))
+	return b.Script()
+}
+
+// State returns the terms of a payment channel locking script
+func State(s *bscript.Script) (*Terms, error) {
+	b := []byte(*s)
+	if len(b) < TermsLength {
+		return nil, errors.New("locking script is not a payment channel")
+	}
+	t := b[len(b)-TermsLength:]
+	terms := &Terms{
+		FunderPKH: t[:20],
+		PayeePKH:  t[20:40],
+		Timeout:   binary.LittleEndian.Uint32(t[40:44]),
+	}
+	template, err := NewLockingScript(terms)
+	if err != nil || !template.Equals(s) {
+		return nil, errors.New("locking script is not a payment channel")
+	}
+	return terms, nil
+}
+
+// IsChannel checks the locking script is a payment channel
+func IsChannel(s *bscript.Script) bool {
+	_, err := State(s)
+	return err == nil
+}
+
+// Open spends a P2PKH utxo of the funder to lock satoshis in a channel under the terms.
+// The rest is sent to changeAddress
+func Open(ctx context.Context, utxo *bt.UTXO, terms *Terms, satoshis uint64, changeAddress string, funderKey *bec.PrivateKey) (*bt.Tx, error) {
+	lockingScript, err := NewLockingScript(terms)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &pushtx.Getter{PrivateKey: funderKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Update is a close transaction signed by the funder, sent to the payee off-chain
+type Update struct {
+	Tx           *bt.Tx
+	FunderSig    []byte
+	FunderPubKey []byte
+}
+
+// Amount returns the satoshis the update pays to the payee
+func (u *Update) Amount() uint64 {
+	if len(u.Tx.Outputs) == 0 {
+		return 0
+	}
+	return u.Tx.Outputs[0].Satoshis
+}
+
+// SignUpdate builds and signs the close transaction paying amount to the payee, with the change less the fee
+// going back to the funder
+func SignUpdate(ctx context.Context, channel *bt.UTXO, amount uint64, funderKey *bec.PrivateKey) (*Update, error) {
+	terms, err := State(channel.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	if amount == 0 || amount > channel.Satoshis {
+		return nil, errors.New("amount must be positive and no more than the channel")
+	}
+	payee, err := bscript.NewP2PKHFromPubKeyHash(terms.PayeePKH)
+	if err != nil {
+		return nil, err
+	}
+	funder, err := bscript.NewP2PKHFromPubKeyHash(terms.FunderPKH)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(channel); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: amount, LockingScript: payee})
+	tx.AddOutput(&bt.Output{Satoshis: channel.Satoshis - amount, LockingScript: funder})
+	tx.Inputs[0].SequenceNumber = pushtx.NonFinalSequenceNumber
+
+	fee, err := closeFee(tx)
+	if err != nil {
+		return nil, err
+	}
+	if channel.Satoshis-amount < fee {
+		return nil, bt.ErrInsufficientFunds
+	}
+	tx.Outputs[1].Satoshis = channel.Satoshis - amount - fee
+
+	preimage, err := pushtx.LowSPreimage(ctx, tx, 0, sighash.AllForkID)
+	if err != nil {
+		return nil, err
+	}
+	if tx.LockTime >= terms.Timeout {
+		return nil, errors.New("no low s nLocktime before the channel timeout")
+	}
+	sig, err := funderKey.Sign(crypto.Sha256d(preimage))
+	if err != nil {
+		return nil, err
+	}
+	return &Update{
+		Tx:           tx,
+		FunderSig:    append(sig.Serialise(), byte(sighash.AllForkID)),
+		FunderPubKey: funderKey.PubKey().SerialiseCompressed(),
+	}, nil
+}
+
+// closeFee returns the fee of the close transaction with the largest possible signatures
+func closeFee(tx *bt.Tx) (uint64, error) {
+	preimage, err := tx.CalcInputPreimage(0, sighash.AllForkID)
+	if err != nil {
+		return 0, err
+	}
+	sig := make([]byte, 72)
+	pubKey := make([]byte, 33)
+	args := [][]byte{
+		script.EncodeNumber(int64(tx.Outputs[0].Satoshis)),
+		script.EncodeNumber(int64(tx.Outputs[1].Satoshis)),
+		append(sig, byte(sighash.AllForkID)), pubKey, script.EncodeNumber(1),
+	}
+	unlockingScript, err := script.NewPushTxUnlockingScriptWithArgs(args, pubKey, preimage, sig, sighash.AllForkID)
+	if err != nil {
+		return 0, err
+	}
+	tx.Inputs[0].UnlockingScript = unlockingScript
+	defer func() { tx.Inputs[0].UnlockingScript = nil }()
+	return pushtx.Fee(tx, bt.NewFeeQuote())
+}
+
+// VerifyUpdate checks an update received by the payee closes the channel, is signed by the funder and pays
+// the payee more than minAmount, the amount of the last update they accepted
+func VerifyUpdate(channel *bt.UTXO, update *Update, minAmount uint64) error {
+	terms, err := State(channel.LockingScript)
+	if err != nil {
+		return err
+	}
+	// the previous output is filled in on a copy so the update is left as it was received
+	tx := update.Tx.Clone()
+	if len(tx.Inputs) != 1 || !bytes.Equal(tx.Inputs[0].PreviousTxID(), channel.TxID) || tx.Inputs[0].PreviousTxOutIndex != channel.Vout {
+		return errors.New("update does not spend the channel")
+	}
+	if len(tx.Outputs) != 2 {
+		return errors.New("update must have a payee and a funder output")
+	}
+	if tx.LockTime >= terms.Timeout {
+		return errors.New("update nLocktime must be before the channel timeout")
+	}
+	if tx.Inputs[0].SequenceNumber == bt.DefaultSequenceNumber {
+		return errors.New("update nSequence must be non-final")
+	}
+	payee, err := bscript.NewP2PKHFromPubKeyHash(terms.PayeePKH)
+	if err != nil {
+		return err
+	}
+	if !tx.Outputs[0].LockingScript.Equals(payee) {
+		return errors.New("update does not pay the payee")
+	}
+	if update.Amount() <= minAmount {
+		return errors.New("update does not pay more than the last update")
+	}
+	if update.Amount()+tx.Outputs[1].Satoshis > channel.Satoshis {
+		return errors.New("update pays more than the channel holds")
+	}
+
+	if !bytes.Equal(crypto.Hash160(update.FunderPubKey), terms.FunderPKH) {
+		return errors.New("update is not signed by the funder")
+	}
+	if len(update.FunderSig) == 0 || sighash.Flag(update.FunderSig[len(update.FunderSig)-1]) != sighash.AllForkID {
+		return errors.New("update signature must be SIGHASH_ALL|FORKID")
+	}
+	tx.Inputs[0].PreviousTxSatoshis = channel.Satoshis
+	tx.Inputs[0].PreviousTxScript = channel.LockingScript
+	preimage, err := tx.CalcInputPreimage(0, sighash.AllForkID)
+	if err != nil {
+		return err
+	}
+	// closing keeps nLocktime only if the preimage is already low s
+	if !pushtxpreimage.IsLowS(preimage) {
+		return errors.New("update preimage is not low s, closing would invalidate the funder signature")
+	}
+	pubKey, err := bec.ParsePubKey(update.FunderPubKey, bec.S256())
+	if err != nil {
+		return err
+	}
+	sig, err := bec.ParseDERSignature(update.FunderSig[:len(update.FunderSig)-1], bec.S256())
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(crypto.Sha256d(preimage), pubKey) {
+		return errors.New("invalid funder signature")
+	}
+	return nil
+}
+
+// Close adds the payee signature to the update, returning the transaction ready to broadcast
+func Close(ctx context.Context, update *Update, payeeKey *bec.PrivateKey) (*bt.Tx, error) {
+	getter := &Getter{PrivateKey: payeeKey, FunderSig: update.FunderSig, FunderPubKey: update.FunderPubKey}
+	if err := update.Tx.FillAllInputs(ctx, getter); err != nil {
+		return nil, err
+	}
+	return update.Tx, nil
+}
+
+// Refund spends the whole channel back to address once the timeout is reached, paying the fee out of the channel
+func Refund(ctx context.Context, channel *bt.UTXO, address string, funderKey *bec.PrivateKey) (*bt.Tx, error) {
+	terms, err := State(channel.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(channel); err != nil {
+		return nil, err
+	}
+	if err = tx.PayToAddress(address, 0); err != nil {
+		return nil, err
+	}
+	pushtx.SetLockTime(tx, terms.Timeout)
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, &Getter{PrivateKey: funderKey}, 0, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks channel inputs and falls back to the push tx Getter for everything else.
+// With the funder signature of an update the payee closes the channel, otherwise the funder refunds it
+type Getter struct {
+	PrivateKey   *bec.PrivateKey
+	FunderSig    []byte
+	FunderPubKey []byte
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), NewRegistration(g.FunderSig, g.FunderPubKey))...)
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// NewRegistration returns the registration of channel inputs, for adding channels to a pushtx.Registry.
+// The Getter must have a PrivateKey, and closes with the funder signature if it is set and refunds otherwise
+func NewRegistration(funderSig, funderPubKey []byte) pushtx.Registration {
+	return pushtx.Registration{
+		Name:     "channel",
+		Priority: pushtx.PriorityContract,
+		Match:    IsChannel,
+		Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+			if g.PrivateKey == nil {
+				return nil, errors.New("channel needs a private key")
+			}
+			return &Unlocker{PrivateKey: g.PrivateKey, FunderSig: funderSig, FunderPubKey: funderPubKey}, nil
+		},
+	}
+}
+
+// Unlocker signs a channel input with UnlockPushTx, closing with the funder signature if it is set
+// and refunding otherwise
+type Unlocker struct {
+	PrivateKey   *bec.PrivateKey
+	FunderSig    []byte
+	FunderPubKey []byte
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	args := [][]byte{script.EncodeNumber(0)}
+	if u.FunderSig != nil {
+		if len(tx.Outputs) != 2 {
+			return nil, errors.New("close needs a payee and a funder output")
+		}
+		args = [][]byte{
+			script.EncodeNumber(int64(tx.Outputs[0].Satoshis)),
+			script.EncodeNumber(int64(tx.Outputs[1].Satoshis)),
+			u.FunderSig, u.FunderPubKey, script.EncodeNumber(1),
+		}
+	}
+	unlocker := &pushtx.UnlockPushTx{PrivateKey: u.PrivateKey, Args: args}
+	return unlocker.UnlockingScript(ctx, tx, params)
+}
+
+func encodeTerms(terms *Terms) []byte {
+	b := make([]byte, TermsLength)
+	copy(b, terms.FunderPKH)
+	copy(b[20:], terms.PayeePKH)
+	binary.LittleEndian.PutUint32(b[40:], terms.Timeout)
+	return b
+}
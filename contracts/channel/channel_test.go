@@ -0,0 +1,241 @@
+package channel
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestChannel(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	payeeKey, payee := testutil.NewKey(t)
+	terms, err := NewTerms(funder, payee, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := Open(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), terms, 50000, funder, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(open); err != nil {
+		t.Fatalf("open failed verification: %v", err)
+	}
+	channel := testutil.OutputUTXO(open, 0)
+
+	var last *Update
+	var paid uint64
+	for _, amount := range []uint64{1000, 2500, 10000} {
+		update, err := SignUpdate(context.Background(), channel, amount, funderKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyUpdate(channel, update, paid); err != nil {
+			t.Fatalf("update of %d failed verification: %v", amount, err)
+		}
+		last, paid = update, amount
+	}
+
+	stale, err := SignUpdate(context.Background(), channel, 500, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyUpdate(channel, stale, paid); err == nil {
+		t.Error("expected update paying less than the last one to be rejected")
+	}
+
+	tx, err := Close(context.Background(), last, payeeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Fatalf("close failed verification: %v", err)
+	}
+	if tx.Outputs[0].Satoshis != paid {
+		t.Errorf("expected close to pay %d to the payee, got %d", paid, tx.Outputs[0].Satoshis)
+	}
+	fee, err := pushtx.Fee(tx, bt.NewFeeQuote())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paidFee := tx.TotalInputSatoshis() - tx.TotalOutputSatoshis(); paidFee < fee {
+		t.Errorf("expected close to pay a fee of at least %d, got %d", fee, paidFee)
+	}
+}
+
+func TestChannelRejected(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	payeeKey, payee := testutil.NewKey(t)
+	malloryKey, _ := testutil.NewKey(t)
+	terms, err := NewTerms(funder, payee, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newChannel := func() *bt.UTXO {
+		open, err := Open(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), terms, 50000, funder, funderKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testutil.OutputUTXO(open, 0)
+	}
+
+	var tests = []struct {
+		name          string
+		spend         func(channel *bt.UTXO) (*bt.Tx, error)
+		expectedError bool
+	}{
+		{"funder refunds", func(channel *bt.UTXO) (*bt.Tx, error) {
+			return Refund(context.Background(), channel, funder, funderKey)
+		}, false},
+		{"payee refunds", func(channel *bt.UTXO) (*bt.Tx, error) {
+			return Refund(context.Background(), channel, payee, payeeKey)
+		}, true},
+		{"update signed by someone else", func(channel *bt.UTXO) (*bt.Tx, error) {
+			update, err := SignUpdate(context.Background(), channel, 1000, malloryKey)
+			if err != nil {
+				return nil, err
+			}
+			return Close(context.Background(), update, payeeKey)
+		}, true},
+		{"payee raises the amount", func(channel *bt.UTXO) (*bt.Tx, error) {
+			update, err := SignUpdate(context.Background(), channel, 1000, funderKey)
+			if err != nil {
+				return nil, err
+			}
+			update.Tx.Outputs[0].Satoshis = 20000
+			update.Tx.Outputs[1].Satoshis -= 19000
+			return Close(context.Background(), update, payeeKey)
+		}, true},
+	}
+	for _, test := range tests {
+		tx, err := test.spend(newChannel())
+		if err != nil {
+			t.Fatalf("%s failed: %v", test.name, err)
+		}
+		err = testutil.Verify(tx)
+		if err != nil && !test.expectedError {
+			t.Errorf("%s failed: unexpected error %v", test.name, err)
+		}
+		if err == nil && test.expectedError {
+			t.Errorf("%s failed: expected channel to reject the spend", test.name)
+		}
+	}
+}
+
+func TestVerifyUpdateLeavesUpdate(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	_, payee := testutil.NewKey(t)
+	terms, err := NewTerms(funder, payee, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := Open(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), terms, 50000, funder, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := testutil.OutputUTXO(open, 0)
+	update, err := SignUpdate(context.Background(), channel, 1000, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an update received over the wire has no previous output
+	if update.Tx, err = bt.NewTxFromBytes(update.Tx.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyUpdate(channel, update, 0); err != nil {
+		t.Fatal(err)
+	}
+	if in := update.Tx.Inputs[0]; in.PreviousTxScript != nil || in.PreviousTxSatoshis != 0 {
+		t.Error("expected VerifyUpdate to leave the update transaction unchanged")
+	}
+}
+
+func TestVerifyUpdateRejected(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	_, payee := testutil.NewKey(t)
+	terms, err := NewTerms(funder, payee, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err := Open(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), terms, 50000, funder, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := testutil.OutputUTXO(open, 0)
+
+	// resign signs the changed update again so only the change is rejected
+	resign := func(update *Update) {
+		preimage, err := update.Tx.CalcInputPreimage(0, sighash.AllForkID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := funderKey.Sign(crypto.Sha256d(preimage))
+		if err != nil {
+			t.Fatal(err)
+		}
+		update.FunderSig = append(sig.Serialise(), byte(sighash.AllForkID))
+	}
+	var tests = []struct {
+		name   string
+		change func(update *Update)
+	}{
+		{"nLocktime at timeout", func(update *Update) {
+			update.Tx.LockTime = terms.Timeout
+		}},
+		{"final nSequence", func(update *Update) {
+			update.Tx.Inputs[0].SequenceNumber = bt.DefaultSequenceNumber
+		}},
+		{"high s preimage", func(update *Update) {
+			for {
+				update.Tx.LockTime++
+				preimage, err := update.Tx.CalcInputPreimage(0, sighash.AllForkID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !pushtxpreimage.IsLowS(preimage) {
+					return
+				}
+			}
+		}},
+	}
+	for _, test := range tests {
+		update, err := SignUpdate(context.Background(), channel, 1000, funderKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = VerifyUpdate(channel, update, 0); err != nil {
+			t.Fatalf("%s failed: unexpected error %v", test.name, err)
+		}
+		test.change(update)
+		resign(update)
+		if err = VerifyUpdate(channel, update, 0); err == nil {
+			t.Errorf("%s failed: expected update to be rejected", test.name)
+		}
+	}
+}
+
+// a close unlocks with 8 items and a refund with 4, which leaves 4 more when given 8
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	_, funder := testutil.NewKey(t)
+	_, payee := testutil.NewKey(t)
+	terms, err := NewTerms(funder, payee, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := NewLockingScript(terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 8, 1, 5)
+}
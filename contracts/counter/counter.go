@@ -0,0 +1,211 @@
+package counter
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Counter Contract
+----------------
+
+Unlocking Script: <outputs after output 0> <preimage>
+
+Locking Script: <Optimized OP_PUSH_TX> <increment and check outputs> OP_RETURN <count>
+
+Anyone can spend a counter output, but output 0 of the spending transaction must
+carry the same script and value with the count incremented by one. Fees are paid
+by additional inputs, and any extra outputs are passed in the unlocking script so
+the covenant can check them against hashOutputs.
+*/
+
+// StateLength is the number of bytes of the count at the end of the locking script
+const StateLength = 8
+
+// NewLockingScript returns the counter locking script holding count
+func NewLockingScript(count int64) (*bscript.Script, error) {
+	if count < 0 {
+		return nil, errors.New("count must not be negative")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+
+	// stack: <suffix> <preimage>
+	b.Opcodes(bscript.OpDUP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Opcodes(bscript.OpTOALTSTACK)
+
+	// new output keeps the value of the output being spent
+	b.Opcodes(bscript.OpDUP)
+	b.Append(script.AppendGetValueFromPreimage)
+	b.Opcodes(bscript.OpSWAP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendSplitStateFromPreimage(s, StateLength)
+	})
+
+	// stack: <suffix> <value> <scriptCode without count> <count>
+	b.Opcodes(bscript.OpBIN2NUM, bscript.Op1ADD)
+	b.Number(StateLength)
+	b.Opcodes(bscript.OpNUM2BIN)
+	b.Opcodes(bscript.OpCAT, bscript.OpCAT)
+
+	// append the rest of the outputs and check against hashOutputs
+	b.Opcodes(bscript.OpSWAP, bscript.OpCAT)
+	b.Opcodes(bscript.OpFROMALTSTACK)
+	b.Append(script.AppendVerifyOutputs)
+	b.Opcodes(bscript.OpTRUE)
+
+	// state
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeCount(count))
+	return b.Script()
+}
+
+// IsCounter checks the locking script is a counter contract
+func IsCounter(s *bscript.Script) bool {
+	template, err := NewLockingScript(0)
+	if err != nil || len(*s) != len(*template) {
+		return false
+	}
+	prefix := len(*template) - StateLength
+	return bscript.NewFromBytes((*s)[:prefix]).EqualsBytes((*template)[:prefix])
+}
+
+// Value returns the count held in a counter locking script
+func Value(s *bscript.Script) (int64, error) {
+	if !IsCounter(s) {
+		return 0, errors.New("locking script is not a counter")
+	}
+	count := binary.LittleEndian.Uint64((*s)[len(*s)-StateLength:])
+	return int64(count), nil
+}
+
+// UTXOValue returns the count held by a counter UTXO
+func UTXOValue(utxo *bt.UTXO) (int64, error) {
+	return Value(utxo.LockingScript)
+}
+
+// NewCounterTransaction spends a P2PKH utxo to deploy a counter starting at count, sending the rest to changeAddress
+func NewCounterTransaction(ctx context.Context, utxo *bt.UTXO, changeAddress string, privateKey *bec.PrivateKey, satoshis uint64, count int64) (*bt.Tx, error) {
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+
+	lockingScript, err := NewLockingScript(count)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{
+		Satoshis:      satoshis,
+		LockingScript: lockingScript,
+	})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+
+	getter := &Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// IncrementCounter spends a counter utxo, recreating it with the count incremented in output 0.
+// Fees are paid by the P2PKH funding utxo with the rest sent to changeAddress
+func IncrementCounter(ctx context.Context, counter, funding *bt.UTXO, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	count, err := UTXOValue(counter)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := bt.NewTx()
+	// counter input goes first so nLocktime is settled before the funding input is signed
+	if err = tx.FromUTXOs(counter, funding); err != nil {
+		return nil, err
+	}
+
+	lockingScript, err := NewLockingScript(count + 1)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{
+		Satoshis:      counter.Satoshis,
+		LockingScript: lockingScript,
+	})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+
+	getter := &Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks counter inputs and falls back to the push tx Getter for everything else
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// Registration matches counter inputs, for adding counters to a pushtx.Registry
+var Registration = pushtx.Registration{
+	Name:     "counter",
+	Priority: pushtx.PriorityContract,
+	Match:    IsCounter,
+	Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+		return &Unlocker{}, nil
+	},
+}
+
+// registry is the default registry with counters added
+var registry = pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+
+// Unlocker builds the unlocking script of a counter input, no signature is needed
+type Unlocker struct{}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	if params.SigHashFlags == 0 {
+		params.SigHashFlags = sighash.AllForkID
+	}
+	if params.InputIdx != 0 || len(tx.Outputs) == 0 {
+		return nil, errors.New("counter must be spent by input 0 into output 0")
+	}
+	preimage, err := pushtx.LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	var suffix []byte
+	for _, o := range tx.Outputs[1:] {
+		suffix = append(suffix, o.Bytes()...)
+	}
+
+	s := &bscript.Script{}
+	if err = s.AppendPushDataArray([][]byte{suffix, preimage}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func encodeCount(count int64) []byte {
+	b := make([]byte, StateLength)
+	binary.LittleEndian.PutUint64(b, uint64(count))
+	return b
+}
@@ -0,0 +1,173 @@
+package counter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestIncrementCounter(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+
+	tx, err := NewCounterTransaction(context.Background(), testutil.NewFundingUTXO(t, address, 100000), address, privateKey, 1000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Fatalf("deploy failed verification: %v", err)
+	}
+
+	counter, funding := testutil.OutputUTXO(tx, 0), testutil.OutputUTXO(tx, 1)
+	for i := int64(1); i <= 25; i++ {
+		tx, err = IncrementCounter(context.Background(), counter, funding, address, privateKey)
+		if err != nil {
+			t.Fatalf("increment %d failed: %v", i, err)
+		}
+		if err = testutil.Verify(tx); err != nil {
+			t.Fatalf("increment %d failed verification: %v", i, err)
+		}
+		counter, funding = testutil.OutputUTXO(tx, 0), testutil.OutputUTXO(tx, 1)
+
+		count, err := UTXOValue(counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Errorf("expected count %d, got %d", i, count)
+		}
+		if counter.Satoshis != 1000 {
+			t.Errorf("expected counter to keep 1000 satoshis, got %d", counter.Satoshis)
+		}
+	}
+}
+
+func TestIncrementCounterRejectsWrongCount(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name  string
+		count int64
+	}{
+		{"same count", 5},
+		{"skipped count", 7},
+		{"decremented count", 4},
+	}
+	privateKey, address := testutil.NewKey(t)
+	deploy, err := NewCounterTransaction(context.Background(), testutil.NewFundingUTXO(t, address, 100000), address, privateKey, 1000, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.OutputUTXO(deploy, 0), testutil.OutputUTXO(deploy, 1)); err != nil {
+				t.Fatal(err)
+			}
+			lockingScript, err := NewLockingScript(test.count)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.AddOutput(&bt.Output{Satoshis: 1000, LockingScript: lockingScript})
+			if err = tx.PayToAddress(address, 90000); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.FillAllInputs(context.Background(), &Getter{PrivateKey: privateKey}); err != nil {
+				t.Fatal(err)
+			}
+			if err = testutil.Verify(tx); err == nil {
+				t.Errorf("%s failed: expected covenant to reject count %d", test.name, test.count)
+			}
+		})
+	}
+}
+
+func TestIncrementCounterWithRegistry(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	deploy, err := NewCounterTransaction(context.Background(), testutil.NewFundingUTXO(t, address, 100000), address, privateKey, 1000, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(testutil.OutputUTXO(deploy, 0), testutil.OutputUTXO(deploy, 1)); err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := NewLockingScript(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.AddOutput(&bt.Output{Satoshis: 1000, LockingScript: lockingScript})
+	if err = tx.PayToAddress(address, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the counter registration only takes counters, other scripts match the registrations they did before
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+	plain, err := pushtx.AddOpPushTransactionOutput(bt.NewTx(), address, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name          string
+		registry      *pushtx.Registry
+		lockingScript *bscript.Script
+		expected      string
+	}{
+		{"counter", registry, lockingScript, "counter"},
+		{"plain push tx", registry, plain.Outputs[0].LockingScript, "pushtx"},
+		{"p2pkh", registry, deploy.Outputs[1].LockingScript, "p2pkh"},
+		{"counter without the registration", pushtx.NewRegistry(pushtx.DefaultRegistrations()...), lockingScript, "pushtx"},
+	}
+	for _, test := range tests {
+		reg, ok := test.registry.Match(test.lockingScript)
+		if !ok || reg.Name != test.expected {
+			t.Errorf("%s failed: expected registration %s, got %s", test.name, test.expected, reg.Name)
+		}
+	}
+
+	getter := &pushtx.Getter{PrivateKey: privateKey, Registry: registry}
+	if err = pushtx.FillAllInputsWithChange(context.Background(), tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Errorf("registered counter failed verification: %v", err)
+	}
+}
+
+func TestValue(t *testing.T) {
+	t.Parallel()
+	lockingScript, err := NewLockingScript(1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := Value(lockingScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1234 {
+		t.Errorf("expected 1234, got %d", count)
+	}
+
+	p2pkh, err := bscript.NewP2PKHFromPubKeyHashStr("8fe80c75c9560e8b56ed64ea3c26e18d2c52211b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Value(p2pkh); err == nil {
+		t.Error("expected error reading count from P2PKH script")
+	}
+}
+
+// the unlocking script is <suffix> <preimage> and a valid spend leaves true
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	lockingScript, err := NewLockingScript(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 2, 1)
+}
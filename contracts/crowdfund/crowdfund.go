@@ -0,0 +1,355 @@
+package crowdfund
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Crowdfunding Contract
+---------------------
+
+Locking Script: <Optimized OP_PUSH_TX with ALL|ANYONECANPAY> <campaign or withdraw> OP_RETURN <beneficiaryPKH> <target> <contributorPKH>
+
+Campaign: 1 <preimage>
+Withdraw: <contributorSig> <contributorPubKey> 0 <preimage>
+
+Contributors lock pledges into this script. A pledge can be spent by anyone into a transaction whose only
+output pays target to the beneficiary, and by the contributor at any time until then. The preimage is
+signed ALL|ANYONECANPAY, so pledges are added to the campaign transaction one by one without invalidating
+each other, while the outputs stay committed. SINGLE|ANYONECANPAY would only commit the pledge with the
+same index as the payout. Each pledge grinds its own nSequence for low s, as nLockTime is shared.
+*/
+
+const (
+	// TermsLength is the length of the terms at the end of the locking script
+	TermsLength = 48
+	// SigHashFlags is the sighash flag pledges are spent with
+	SigHashFlags = sighash.AllForkID | sighash.AnyOneCanPay
+)
+
+// Campaign pays Target to the beneficiary once pledges cover it and the fee
+type Campaign struct {
+	BeneficiaryPKH []byte
+	Target         uint64
+}
+
+// NewCampaign returns a campaign paying target to the beneficiary address
+func NewCampaign(beneficiaryAddress string, target uint64) (*Campaign, error) {
+	a, err := bscript.NewAddressFromString(beneficiaryAddress)
+	if err != nil {
+		return nil, err
+	}
+	pkh, err := hex.DecodeString(a.PublicKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	if target == 0 {
+		return nil, errors.New("target must be more than 0")
+	}
+	return &Campaign{BeneficiaryPKH: pkh, Target: target}, nil
+}
+
+// Output returns the output paying the target to the beneficiary
+func (c *Campaign) Output() (*bt.Output, error) {
+	lockingScript, err := bscript.NewP2PKHFromPubKeyHash(c.BeneficiaryPKH)
+	if err != nil {
+		return nil, err
+	}
+	return &bt.Output{Satoshis: c.Target, LockingScript: lockingScript}, nil
+}
+
+// Transaction returns the campaign transaction without pledges, for contributors to add theirs with Contribute
+func (c *Campaign) Transaction() (*bt.Tx, error) {
+	output, err := c.Output()
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	tx.AddOutput(output)
+	return tx, nil
+}
+
+// Terms of a pledge
+type Terms struct {
+	BeneficiaryPKH []byte
+	Target         uint64
+	ContributorPKH []byte
+}
+
+// NewLockingScript returns the pledge locking script for the terms
+func NewLockingScript(terms *Terms) (*bscript.Script, error) {
+	if len(terms.BeneficiaryPKH) != 20 || len(terms.ContributorPKH) != 20 {
+		return nil, errors.New("public key hashes must be 20 bytes")
+	}
+	campaign := &Campaign{BeneficiaryPKH: terms.BeneficiaryPKH, Target: terms.Target}
+	output, err := campaign.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendPushTxVerifyWithSigHash(s, SigHashFlags)
+	})
+	b.Opcodes(bscript.OpSWAP)
+
+	// campaign, the outputs are known so hashOutputs is compared with their hash
+	b.Opcodes(bscript.OpIF)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.PushData(crypto.Sha256d(output.Bytes()))
+	b.Opcodes(bscript.OpEQUAL)
+
+	// withdraw
+	b.Opcodes(bscript.OpELSE, bscript.OpDROP, bscript.OpDUP, bscript.OpHASH160)
+	b.PushData(terms.ContributorPKH)
+	b.Opcodes(bscript.OpEQUALVERIFY, bscript.OpCHECKSIG)
+	b.Opcodes(bscript.OpENDIF)
+
+	// terms
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeTerms(terms))
+	return b.Script()
+}
+
+// State returns the terms of a pledge locking script
+func State(s *bscript.Script) (*Terms, error) {
+	b := []byte(*s)
+	if len(b) < TermsLength {
+		return nil, errors.New("locking script is not a pledge")
+	}
+	t := b[len(b)-TermsLength:]
+	terms := &Terms{
+		BeneficiaryPKH: t[:20],
+		Target:         binary.LittleEndian.Uint64(t[20:28]),
+		ContributorPKH: t[28:48],
+	}
+	template, err := NewLockingScript(terms)
+	if err != nil || !template.Equals(s) {
+		return nil, errors.New("locking script is not a pledge")
+	}
+	return terms, nil
+}
+
+// IsPledge checks the locking script is a crowdfunding pledge
+func IsPledge(s *bscript.Script) bool {
+	_, err := State(s)
+	return err == nil
+}
+
+// NewPledgeTransaction spends a P2PKH utxo to pledge satoshis to the campaign, withdrawable by the key.
+// The rest is sent to changeAddress
+func NewPledgeTransaction(ctx context.Context, c *Campaign, utxo *bt.UTXO, satoshis uint64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	lockingScript, err := NewLockingScript(&Terms{
+		BeneficiaryPKH: c.BeneficiaryPKH,
+		Target:         c.Target,
+		ContributorPKH: crypto.Hash160(privateKey.PubKey().SerialiseCompressed()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &pushtx.Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Contribute returns a copy of the partial campaign transaction with the pledge added.
+// No key is needed, the pledge can only be spent into the campaign
+func (c *Campaign) Contribute(ctx context.Context, partial *bt.Tx, pledge *bt.UTXO) (*bt.Tx, error) {
+	if err := c.check(partial); err != nil {
+		return nil, err
+	}
+	terms, err := State(pledge.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(terms.BeneficiaryPKH, c.BeneficiaryPKH) || terms.Target != c.Target {
+		return nil, errors.New("pledge is for another campaign")
+	}
+	tx := partial.Clone()
+	if err = tx.FromUTXOs(pledge); err != nil {
+		return nil, err
+	}
+	params := bt.UnlockerParams{InputIdx: uint32(len(tx.Inputs) - 1), SigHashFlags: SigHashFlags}
+	if err = tx.FillInput(ctx, &Unlocker{}, params); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Finalize merges the pledges of the partial campaign transactions. It fails unless they cover the target
+// and the fee, anything above that is paid as fee
+func (c *Campaign) Finalize(partials ...*bt.Tx) (*bt.Tx, error) {
+	tx, err := c.Transaction()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, partial := range partials {
+		if err = c.check(partial); err != nil {
+			return nil, err
+		}
+		for _, in := range partial.Inputs {
+			if in.UnlockingScript == nil || !IsPledge(in.PreviousTxScript) {
+				return nil, errors.New("partial transaction has an input which is not a pledge")
+			}
+			outpoint := fmt.Sprintf("%s:%d", in.PreviousTxIDStr(), in.PreviousTxOutIndex)
+			if seen[outpoint] {
+				continue
+			}
+			seen[outpoint] = true
+			tx.Inputs = append(tx.Inputs, in)
+		}
+	}
+	fee, err := pushtx.Fee(tx, bt.NewFeeQuote())
+	if err != nil {
+		return nil, err
+	}
+	if tx.TotalInputSatoshis() < c.Target+fee {
+		return nil, bt.ErrInsufficientFunds
+	}
+	return tx, nil
+}
+
+// check fails unless tx is a campaign transaction, pledges are only valid in one
+func (c *Campaign) check(tx *bt.Tx) error {
+	output, err := c.Output()
+	if err != nil {
+		return err
+	}
+	if len(tx.Outputs) != 1 || !bytes.Equal(tx.Outputs[0].Bytes(), output.Bytes()) {
+		return errors.New("transaction must only pay the target to the beneficiary")
+	}
+	if tx.Version != 1 || tx.LockTime != 0 {
+		return errors.New("campaign transaction must be version 1 with nLocktime 0")
+	}
+	return nil
+}
+
+// Withdraw spends the pledge back to address, paying the fee out of the pledge
+func Withdraw(ctx context.Context, pledge *bt.UTXO, address string, contributorKey *bec.PrivateKey) (*bt.Tx, error) {
+	if !IsPledge(pledge.LockingScript) {
+		return nil, errors.New("utxo is not a pledge")
+	}
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(pledge); err != nil {
+		return nil, err
+	}
+	if err := tx.PayToAddress(address, 0); err != nil {
+		return nil, err
+	}
+	if err := pushtx.FillAllInputsWithChange(ctx, tx, &Getter{PrivateKey: contributorKey}, 0, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter withdraws pledges with the private key, or adds them to the campaign if it is nil.
+// Everything else falls back to the push tx Getter
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// Registration matches pledge inputs, for adding pledges to a pushtx.Registry.
+// A Getter with a PrivateKey withdraws the pledge, without one it is spent into the campaign
+var Registration = pushtx.Registration{
+	Name:     "pledge",
+	Priority: pushtx.PriorityContract,
+	Match:    IsPledge,
+	Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+		return &Unlocker{PrivateKey: g.PrivateKey}, nil
+	},
+}
+
+// registry is the default registry with pledges added
+var registry = pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+
+// Unlocker withdraws a pledge with the private key, or spends it into the campaign if it is nil
+type Unlocker struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	params.SigHashFlags = pushtx.InputSigHashFlags(tx, params)
+	if u.PrivateKey != nil {
+		preimage, err := pushtx.LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := u.PrivateKey.Sign(crypto.Sha256d(preimage))
+		if err != nil {
+			return nil, err
+		}
+		sigBytes := append(sig.Serialise(), byte(params.SigHashFlags))
+		return script.NewBuilder(&bscript.Script{}).
+			PushData(sigBytes).
+			PushData(u.PrivateKey.PubKey().SerialiseCompressed()).
+			Opcodes(bscript.Op0).
+			PushData(preimage).
+			Script()
+	}
+
+	preimage, err := lowSSequencePreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+	return script.NewBuilder(&bscript.Script{}).Opcodes(bscript.Op1).PushData(preimage).Script()
+}
+
+// lowSSequencePreimage returns the preimage of the input, counting its nSequence down until the preimage is low s.
+// nLocktime is 0 so nSequence is not enforced, and ANYONECANPAY keeps it out of the preimages of other inputs
+func lowSSequencePreimage(ctx context.Context, tx *bt.Tx, inputIdx uint32, sigHashFlags sighash.Flag) ([]byte, error) {
+	in := tx.Inputs[inputIdx]
+	in.SequenceNumber = bt.DefaultSequenceNumber
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		preimage, err := tx.CalcInputPreimage(inputIdx, sigHashFlags)
+		if err != nil {
+			return nil, err
+		}
+		if pushtxpreimage.IsLowS(preimage) {
+			return preimage, nil
+		}
+		in.SequenceNumber--
+	}
+}
+
+func encodeTerms(terms *Terms) []byte {
+	b := make([]byte, TermsLength)
+	copy(b, terms.BeneficiaryPKH)
+	binary.LittleEndian.PutUint64(b[20:], terms.Target)
+	copy(b[28:], terms.ContributorPKH)
+	return b
+}
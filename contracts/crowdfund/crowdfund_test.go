@@ -0,0 +1,192 @@
+package crowdfund
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestCampaign(t *testing.T) {
+	t.Parallel()
+	_, beneficiary := testutil.NewKey(t)
+	campaign, err := NewCampaign(beneficiary, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// each contributor adds their pledge to their own copy of the campaign transaction
+	var pledges []*bt.UTXO
+	var partials []*bt.Tx
+	for _, satoshis := range []uint64{40000, 40000, 30000} {
+		key, address := testutil.NewKey(t)
+		tx, err := NewPledgeTransaction(context.Background(), campaign, testutil.NewFundingUTXO(t, address, 100000), satoshis, address, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(tx); err != nil {
+			t.Fatalf("pledge failed verification: %v", err)
+		}
+		pledge := testutil.OutputUTXO(tx, 0)
+		pledges = append(pledges, pledge)
+
+		partial, err := campaign.Transaction()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if partial, err = campaign.Contribute(context.Background(), partial, pledge); err != nil {
+			t.Fatal(err)
+		}
+		partials = append(partials, partial)
+	}
+
+	if _, err = campaign.Finalize(partials[:2]...); !errors.Is(err, bt.ErrInsufficientFunds) {
+		t.Errorf("expected insufficient funds below the target, got %v", err)
+	}
+
+	tx, err := campaign.Finalize(partials...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 3 {
+		t.Errorf("expected 3 pledges, got %d", len(tx.Inputs))
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Errorf("campaign failed verification: %v", err)
+	}
+
+	// a pledge added to a partial with another pledge is merged once
+	both, err := campaign.Contribute(context.Background(), partials[0], pledges[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = campaign.Finalize(both, partials[1], partials[2]); err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 3 {
+		t.Errorf("expected 3 pledges after merging duplicates, got %d", len(tx.Inputs))
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Errorf("merged campaign failed verification: %v", err)
+	}
+
+	// the covenant rejects any other outputs
+	_, thief := testutil.NewKey(t)
+	if err = tx.PayToAddress(thief, 1000); err != nil {
+		t.Fatal(err)
+	}
+	tx.Outputs[0].Satoshis -= 1000
+	if err = testutil.Verify(tx); err == nil {
+		t.Error("expected covenant to reject outputs other than the target")
+	}
+}
+
+func TestContributeToAnotherCampaign(t *testing.T) {
+	t.Parallel()
+	key, address := testutil.NewKey(t)
+	campaign, err := NewCampaign(address, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCampaign(address, 200000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewPledgeTransaction(context.Background(), other, testutil.NewFundingUTXO(t, address, 100000), 50000, address, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := campaign.Transaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = campaign.Contribute(context.Background(), partial, testutil.OutputUTXO(tx, 0)); err == nil {
+		t.Error("expected pledge to another campaign to be rejected")
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	t.Parallel()
+	contributorKey, contributor := testutil.NewKey(t)
+	malloryKey, mallory := testutil.NewKey(t)
+	campaign, err := NewCampaign(mallory, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewPledgeTransaction(context.Background(), campaign, testutil.NewFundingUTXO(t, contributor, 100000), 50000, contributor, contributorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pledge := testutil.OutputUTXO(tx, 0)
+
+	var tests = []struct {
+		name          string
+		key           *bec.PrivateKey
+		address       string
+		expectedError bool
+	}{
+		{"contributor", contributorKey, contributor, false},
+		{"someone else", malloryKey, mallory, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx, err := Withdraw(context.Background(), pledge, test.address, test.key)
+			if err == nil {
+				err = testutil.Verify(tx)
+			}
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected withdraw to be rejected", test.name)
+			}
+		})
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	t.Parallel()
+	contributorKey, contributor := testutil.NewKey(t)
+	_, owner := testutil.NewKey(t)
+	campaign, err := NewCampaign(owner, 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewPledgeTransaction(context.Background(), campaign, testutil.NewFundingUTXO(t, contributor, 100000), 50000, contributor, contributorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+	if r, ok := registry.Match(tx.Outputs[0].LockingScript); !ok || r.Name != Registration.Name {
+		t.Fatalf("expected the registry to match %q, got %q", Registration.Name, r.Name)
+	}
+
+	withdraw := bt.NewTx()
+	if err = withdraw.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err = withdraw.PayToAddress(contributor, 0); err != nil {
+		t.Fatal(err)
+	}
+	getter := &pushtx.Getter{PrivateKey: contributorKey, Registry: registry}
+	if err = pushtx.FillAllInputsWithChange(context.Background(), withdraw, getter, 0, bt.NewFeeQuote()); err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(withdraw); err != nil {
+		t.Errorf("withdraw with the registry failed verification: %v", err)
+	}
+}
+
+// a withdrawal unlocks with 4 items and a campaign spend with 2, which leaves 2 more when given 4
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	lockingScript, err := NewLockingScript(&Terms{BeneficiaryPKH: make([]byte, 20), Target: 100000, ContributorPKH: make([]byte, 20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 4, 1, 3)
+}
@@ -0,0 +1,317 @@
+package escrow
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Escrow Contract
+---------------
+
+Locking Script: <Optimized OP_PUSH_TX> <path check> <output check> OP_RETURN <buyerPKH> <sellerPKH> <arbiterPKH> <timeout>
+
+Release by buyer: <suffix> <sellerSig> <sellerPubKey> 1 <buyerSig> <buyerPubKey> <preimage>
+Release by arbiter: <suffix> <sellerSig> <sellerPubKey> 2 <arbiterSig> <arbiterPubKey> <preimage>
+Refund: <suffix> 3 <buyerSig> <buyerPubKey> <preimage>
+
+Releasing pays the whole value of the escrow to the seller in output 0, and needs the seller to sign
+along with either the buyer or the arbiter. Once nLocktime reaches timeout the buyer alone can refund
+the whole value to themselves in output 0. Fees are paid by additional P2PKH inputs, and any outputs
+after output 0 are passed in the unlocking script as <suffix>.
+*/
+
+const (
+	// PathBuyer releases to the seller with the buyer and seller signatures
+	PathBuyer = 1
+	// PathArbiter releases to the seller with the arbiter and seller signatures
+	PathArbiter = 2
+	// PathRefund returns the escrow to the buyer after the timeout
+	PathRefund = 3
+
+	// TermsLength is the length of the terms at the end of the locking script
+	TermsLength = 64
+)
+
+// Terms of an escrow
+type Terms struct {
+	BuyerPKH   []byte
+	SellerPKH  []byte
+	ArbiterPKH []byte
+	Timeout    uint32
+}
+
+// NewTerms returns the terms of an escrow between the addresses, refundable to the buyer at timeout
+func NewTerms(buyerAddress, sellerAddress, arbiterAddress string, timeout uint32) (*Terms, error) {
+	var pkhs [3][]byte
+	for i, address := range []string{buyerAddress, sellerAddress, arbiterAddress} {
+		a, err := bscript.NewAddressFromString(address)
+		if err != nil {
+			return nil, err
+		}
+		if pkhs[i], err = hex.DecodeString(a.PublicKeyHash); err != nil {
+			return nil, err
+		}
+	}
+	return &Terms{BuyerPKH: pkhs[0], SellerPKH: pkhs[1], ArbiterPKH: pkhs[2], Timeout: timeout}, nil
+}
+
+// NewLockingScript returns the escrow locking script for the terms
+func NewLockingScript(terms *Terms) (*bscript.Script, error) {
+	if len(terms.BuyerPKH) != 20 || len(terms.SellerPKH) != 20 || len(terms.ArbiterPKH) != 20 {
+		return nil, errors.New("public key hashes must be 20 bytes")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+	b.Opcodes(bscript.Op3, bscript.OpROLL)
+
+	// refund
+	b.Opcodes(bscript.OpDUP, bscript.Op3, bscript.OpEQUAL, bscript.OpIF, bscript.OpDROP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendCheckLockTime(s, terms.Timeout) })
+	b.Opcodes(bscript.OpROT, bscript.OpROT)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendCheckSigPKHVerify(s, terms.BuyerPKH)
+	})
+	b.PushData(terms.BuyerPKH)
+
+	// release, the second signer is the buyer or the arbiter
+	b.Opcodes(bscript.OpELSE)
+	b.Opcodes(bscript.OpDUP, bscript.Op1, bscript.OpEQUAL, bscript.OpIF, bscript.OpDROP)
+	b.PushData(terms.BuyerPKH)
+	b.Opcodes(bscript.OpELSE, bscript.Op2, bscript.OpEQUALVERIFY)
+	b.PushData(terms.ArbiterPKH)
+	b.Opcodes(bscript.OpENDIF)
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.OpHASH160, bscript.OpEQUALVERIFY)
+	b.Opcodes(bscript.OpROT, bscript.OpROT, bscript.OpCHECKSIGVERIFY)
+	b.Opcodes(bscript.OpROT, bscript.OpROT)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendCheckSigPKHVerify(s, terms.SellerPKH)
+	})
+	b.PushData(terms.SellerPKH)
+	b.Opcodes(bscript.OpENDIF)
+
+	// stack: <suffix> <preimage> <recipientPKH>
+	// output 0 pays the whole value to the recipient
+	b.Opcodes(bscript.OpOVER)
+	b.Append(script.AppendGetValueFromPreimage)
+	b.Opcodes(bscript.OpSWAP)
+	b.Append(script.AppendBuildP2PKHOutput)
+	b.Opcodes(bscript.OpROT, bscript.OpCAT, bscript.OpSWAP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Append(script.AppendVerifyOutputs)
+	b.Opcodes(bscript.OpTRUE)
+
+	// terms
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeTerms(terms))
+	return b.Script()
+}
+
+// State returns the terms of an escrow locking script
+func State(s *bscript.Script) (*Terms, error) {
+	b := []byte(*s)
+	if len(b) < TermsLength {
+		return nil, errors.New("locking script is not an escrow")
+	}
+	t := b[len(b)-TermsLength:]
+	terms := &Terms{
+		BuyerPKH:   t[:20],
+		SellerPKH:  t[20:40],
+		ArbiterPKH: t[40:60],
+		Timeout:    binary.LittleEndian.Uint32(t[60:64]),
+	}
+	template, err := NewLockingScript(terms)
+	if err != nil || !template.Equals(s) {
+		return nil, errors.New("locking script is not an escrow")
+	}
+	return terms, nil
+}
+
+// IsEscrow checks the locking script is an escrow contract
+func IsEscrow(s *bscript.Script) bool {
+	_, err := State(s)
+	return err == nil
+}
+
+// NewEscrowTransaction spends a P2PKH utxo to lock satoshis in escrow under the terms.
+// The rest is sent to changeAddress
+func NewEscrowTransaction(ctx context.Context, utxo *bt.UTXO, terms *Terms, satoshis uint64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	lockingScript, err := NewLockingScript(terms)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &pushtx.Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Release pays the escrow to the seller. privateKey must be the buyer or the arbiter, and also owns the funding
+// utxo paying the fee
+func Release(ctx context.Context, escrow, funding *bt.UTXO, changeAddress string, sellerKey, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	terms, err := State(escrow.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	var path int
+	switch pkh := crypto.Hash160(privateKey.PubKey().SerialiseCompressed()); {
+	case bytes.Equal(pkh, terms.BuyerPKH):
+		path = PathBuyer
+	case bytes.Equal(pkh, terms.ArbiterPKH):
+		path = PathArbiter
+	default:
+		return nil, errors.New("release must be signed by the buyer or the arbiter")
+	}
+	tx, err := newSpend(escrow, funding, terms.SellerPKH)
+	if err != nil {
+		return nil, err
+	}
+	return fill(ctx, tx, changeAddress, &Getter{PrivateKey: privateKey, SellerKey: sellerKey, Path: path})
+}
+
+// Refund returns the escrow to the buyer once the timeout is reached. The buyer also owns the funding utxo paying the fee
+func Refund(ctx context.Context, escrow, funding *bt.UTXO, changeAddress string, buyerKey *bec.PrivateKey) (*bt.Tx, error) {
+	terms, err := State(escrow.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := newSpend(escrow, funding, terms.BuyerPKH)
+	if err != nil {
+		return nil, err
+	}
+	pushtx.SetLockTime(tx, terms.Timeout)
+	return fill(ctx, tx, changeAddress, &Getter{PrivateKey: buyerKey, Path: PathRefund})
+}
+
+// newSpend spends the escrow and funding utxos, paying the whole escrow to pkh in output 0
+func newSpend(escrow, funding *bt.UTXO, pkh []byte) (*bt.Tx, error) {
+	lockingScript, err := bscript.NewP2PKHFromPubKeyHash(pkh)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(escrow, funding); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: escrow.Satoshis, LockingScript: lockingScript})
+	return tx, nil
+}
+
+// fill adds the change output and signs every input
+func fill(ctx context.Context, tx *bt.Tx, changeAddress string, getter *Getter) (*bt.Tx, error) {
+	if err := tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	if err := pushtx.FillAllInputsWithChange(ctx, tx, getter, len(tx.Outputs)-1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks escrow inputs along the path and falls back to the push tx Getter for everything else
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+	SellerKey  *bec.PrivateKey
+	Path       int
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), NewRegistration(g.SellerKey, g.Path))...)
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// NewRegistration returns the registration of escrow inputs spent along the path, for adding escrows
+// to a pushtx.Registry. The Getter must have a PrivateKey, and releasing needs the seller key
+func NewRegistration(sellerKey *bec.PrivateKey, path int) pushtx.Registration {
+	return pushtx.Registration{
+		Name:     "escrow",
+		Priority: pushtx.PriorityContract,
+		Match:    IsEscrow,
+		Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+			if g.PrivateKey == nil {
+				return nil, errors.New("escrow needs a private key")
+			}
+			return &Unlocker{PrivateKey: g.PrivateKey, SellerKey: sellerKey, Path: path}, nil
+		},
+	}
+}
+
+// Unlocker signs an escrow input along the path with UnlockPushTx, adding the seller signature to release
+type Unlocker struct {
+	PrivateKey *bec.PrivateKey
+	SellerKey  *bec.PrivateKey
+	Path       int
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	if len(tx.Outputs) == 0 {
+		return nil, errors.New("escrow spend needs an output")
+	}
+	var b []byte
+	for _, o := range tx.Outputs[1:] {
+		b = append(b, o.Bytes()...)
+	}
+	args := [][]byte{b}
+
+	switch u.Path {
+	case PathBuyer, PathArbiter:
+		if u.SellerKey == nil {
+			return nil, errors.New("release needs the seller key")
+		}
+		if params.SigHashFlags == 0 {
+			params.SigHashFlags = sighash.AllForkID
+		}
+		// settle nLocktime first so the seller signs the same preimage as the push tx
+		preimage, err := pushtx.LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := u.SellerKey.Sign(crypto.Sha256d(preimage))
+		if err != nil {
+			return nil, err
+		}
+		args = append(args,
+			append(sig.Serialise(), byte(params.SigHashFlags)),
+			u.SellerKey.PubKey().SerialiseCompressed(),
+		)
+	case PathRefund:
+	default:
+		return nil, errors.New("unknown escrow path")
+	}
+	args = append(args, script.EncodeNumber(int64(u.Path)))
+
+	unlocker := &pushtx.UnlockPushTx{PrivateKey: u.PrivateKey, Args: args}
+	return unlocker.UnlockingScript(ctx, tx, params)
+}
+
+func encodeTerms(terms *Terms) []byte {
+	b := make([]byte, TermsLength)
+	copy(b, terms.BuyerPKH)
+	copy(b[20:], terms.SellerPKH)
+	copy(b[40:], terms.ArbiterPKH)
+	binary.LittleEndian.PutUint32(b[60:], terms.Timeout)
+	return b
+}
@@ -0,0 +1,142 @@
+package escrow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestEscrowPaths(t *testing.T) {
+	t.Parallel()
+	buyerKey, buyer := testutil.NewKey(t)
+	sellerKey, seller := testutil.NewKey(t)
+	arbiterKey, arbiter := testutil.NewKey(t)
+	malloryKey, mallory := testutil.NewKey(t)
+
+	terms, err := NewTerms(buyer, seller, arbiter, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEscrow := func() *bt.UTXO {
+		tx, err := NewEscrowTransaction(context.Background(), testutil.NewFundingUTXO(t, buyer, 100000), terms, 50000, buyer, buyerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return testutil.OutputUTXO(tx, 0)
+	}
+
+	var tests = []struct {
+		name          string
+		spend         func(escrow *bt.UTXO) (*bt.Tx, error)
+		recipient     string
+		expectedError bool
+	}{
+		{"buyer releases", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			return Release(context.Background(), escrow, testutil.NewFundingUTXO(t, buyer, 10000), buyer, sellerKey, buyerKey)
+		}, seller, false},
+		{"arbiter releases", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			return Release(context.Background(), escrow, testutil.NewFundingUTXO(t, arbiter, 10000), arbiter, sellerKey, arbiterKey)
+		}, seller, false},
+		{"buyer refunds", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			return Refund(context.Background(), escrow, testutil.NewFundingUTXO(t, buyer, 10000), buyer, buyerKey)
+		}, buyer, false},
+		{"release without the seller", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			return Release(context.Background(), escrow, testutil.NewFundingUTXO(t, buyer, 10000), buyer, malloryKey, buyerKey)
+		}, seller, true},
+		{"release by a stranger as the arbiter", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			tx, err := newSpend(escrow, testutil.NewFundingUTXO(t, mallory, 10000), terms.SellerPKH)
+			if err != nil {
+				return nil, err
+			}
+			return fill(context.Background(), tx, mallory, &Getter{PrivateKey: malloryKey, SellerKey: sellerKey, Path: PathArbiter})
+		}, seller, true},
+		{"refund by the seller", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			return Refund(context.Background(), escrow, testutil.NewFundingUTXO(t, seller, 10000), seller, sellerKey)
+		}, buyer, true},
+		{"refund before the timeout", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			tx, err := newSpend(escrow, testutil.NewFundingUTXO(t, buyer, 10000), terms.BuyerPKH)
+			if err != nil {
+				return nil, err
+			}
+			pushtx.SetLockTime(tx, 799000)
+			return fill(context.Background(), tx, buyer, &Getter{PrivateKey: buyerKey, Path: PathRefund})
+		}, buyer, true},
+		{"release to someone else", func(escrow *bt.UTXO) (*bt.Tx, error) {
+			lockingScript, err := bscript.NewP2PKHFromAddress(mallory)
+			if err != nil {
+				return nil, err
+			}
+			tx := bt.NewTx()
+			if err = tx.FromUTXOs(escrow, testutil.NewFundingUTXO(t, buyer, 10000)); err != nil {
+				return nil, err
+			}
+			tx.AddOutput(&bt.Output{Satoshis: escrow.Satoshis, LockingScript: lockingScript})
+			if err = tx.PayToAddress(buyer, 9000); err != nil {
+				return nil, err
+			}
+			err = tx.FillAllInputs(context.Background(), &Getter{PrivateKey: buyerKey, SellerKey: sellerKey, Path: PathBuyer})
+			return tx, err
+		}, mallory, true},
+	}
+	for _, test := range tests {
+		escrow := newEscrow()
+		tx, err := test.spend(escrow)
+		if err != nil {
+			t.Fatalf("%s failed: %v", test.name, err)
+		}
+		err = testutil.Verify(tx)
+		if err != nil && !test.expectedError {
+			t.Errorf("%s failed: unexpected error %v", test.name, err)
+		}
+		if err == nil && test.expectedError {
+			t.Errorf("%s failed: expected escrow to reject the spend", test.name)
+		}
+		expected, err := bscript.NewP2PKHFromAddress(test.recipient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tx.Outputs[0].LockingScript.Equals(expected) || tx.Outputs[0].Satoshis != escrow.Satoshis {
+			t.Errorf("%s failed: expected output 0 to pay the escrow to %s", test.name, test.recipient)
+		}
+	}
+}
+
+func TestReleaseRejectsUnknownKey(t *testing.T) {
+	t.Parallel()
+	buyerKey, buyer := testutil.NewKey(t)
+	sellerKey, seller := testutil.NewKey(t)
+	_, arbiter := testutil.NewKey(t)
+	malloryKey, mallory := testutil.NewKey(t)
+	terms, err := NewTerms(buyer, seller, arbiter, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewEscrowTransaction(context.Background(), testutil.NewFundingUTXO(t, buyer, 100000), terms, 50000, buyer, buyerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Release(context.Background(), testutil.OutputUTXO(tx, 0), testutil.NewFundingUTXO(t, mallory, 10000), mallory, sellerKey, malloryKey); err == nil {
+		t.Error("expected release with a key that is neither the buyer nor the arbiter to fail")
+	}
+}
+
+// a release unlocks with 7 items and a refund with 5, which leaves 2 more when given 7
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	_, buyer := testutil.NewKey(t)
+	_, seller := testutil.NewKey(t)
+	_, arbiter := testutil.NewKey(t)
+	terms, err := NewTerms(buyer, seller, arbiter, 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := NewLockingScript(terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 7, 1, 3)
+}
@@ -0,0 +1,277 @@
+package htlc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Hash Time Locked Contract
+-------------------------
+
+Locking Script: <Optimized OP_PUSH_TX> <claim or refund> OP_RETURN <senderPKH> <recipientPKH> <hash> <lockTime>
+
+Claim: <secret> 1 <recipientSig> <recipientPubKey> <preimage>
+Refund: 0 <senderSig> <senderPubKey> <preimage>
+
+The recipient can claim at any time by revealing the secret whose SHA256 is hash, which lets
+the other side of a swap claim with the same secret. Once nLocktime reaches lockTime the sender
+can take the coins back instead.
+*/
+
+const (
+	// TermsLength is the length of the terms at the end of the locking script
+	TermsLength = 76
+)
+
+// Terms of a hash time locked contract
+type Terms struct {
+	SenderPKH    []byte
+	RecipientPKH []byte
+	Hash         []byte
+	LockTime     uint32
+}
+
+// NewTerms returns the terms paying the recipient for the secret of hash, refundable to the sender at lockTime
+func NewTerms(senderAddress, recipientAddress string, hash []byte, lockTime uint32) (*Terms, error) {
+	var pkhs [2][]byte
+	for i, address := range []string{senderAddress, recipientAddress} {
+		a, err := bscript.NewAddressFromString(address)
+		if err != nil {
+			return nil, err
+		}
+		if pkhs[i], err = hex.DecodeString(a.PublicKeyHash); err != nil {
+			return nil, err
+		}
+	}
+	return &Terms{SenderPKH: pkhs[0], RecipientPKH: pkhs[1], Hash: hash, LockTime: lockTime}, nil
+}
+
+// NewLockingScript returns the HTLC locking script for the terms
+func NewLockingScript(terms *Terms) (*bscript.Script, error) {
+	if len(terms.SenderPKH) != 20 || len(terms.RecipientPKH) != 20 {
+		return nil, errors.New("public key hashes must be 20 bytes")
+	}
+	if len(terms.Hash) != sha256.Size {
+		return nil, errors.New("hash must be 32 bytes")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+	b.Opcodes(bscript.Op3, bscript.OpROLL)
+
+	// claim
+	b.Opcodes(bscript.OpIF, bscript.OpDROP, bscript.OpROT, bscript.OpSHA256)
+	b.PushData(terms.Hash)
+	b.Opcodes(bscript.OpEQUALVERIFY)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendCheckSigPKH(s, terms.RecipientPKH)
+	})
+
+	// refund
+	b.Opcodes(bscript.OpELSE)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendCheckLockTime(s, terms.LockTime) })
+	b.Opcodes(bscript.OpDROP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendCheckSigPKH(s, terms.SenderPKH) })
+	b.Opcodes(bscript.OpENDIF)
+
+	// terms
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeTerms(terms))
+	return b.Script()
+}
+
+// State returns the terms of a HTLC locking script
+func State(s *bscript.Script) (*Terms, error) {
+	b := []byte(*s)
+	if len(b) < TermsLength {
+		return nil, errors.New("locking script is not a HTLC")
+	}
+	t := b[len(b)-TermsLength:]
+	terms := &Terms{
+		SenderPKH:    t[:20],
+		RecipientPKH: t[20:40],
+		Hash:         t[40:72],
+		LockTime:     binary.LittleEndian.Uint32(t[72:76]),
+	}
+	template, err := NewLockingScript(terms)
+	if err != nil || !template.Equals(s) {
+		return nil, errors.New("locking script is not a HTLC")
+	}
+	return terms, nil
+}
+
+// IsHTLC checks the locking script is a hash time locked contract
+func IsHTLC(s *bscript.Script) bool {
+	_, err := State(s)
+	return err == nil
+}
+
+// NewHTLCTransaction spends a P2PKH utxo to lock satoshis under the terms. The rest is sent to changeAddress
+func NewHTLCTransaction(ctx context.Context, utxo *bt.UTXO, terms *Terms, satoshis uint64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	lockingScript, err := NewLockingScript(terms)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &pushtx.Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Claim spends the HTLC to address by revealing the secret, paying the fee out of the utxo
+func Claim(ctx context.Context, htlc *bt.UTXO, secret []byte, address string, recipientKey *bec.PrivateKey) (*bt.Tx, error) {
+	terms, err := State(htlc.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	if hash := sha256.Sum256(secret); !bytes.Equal(hash[:], terms.Hash) {
+		return nil, errors.New("secret does not match the hash")
+	}
+	tx, err := newSpend(htlc, address)
+	if err != nil {
+		return nil, err
+	}
+	return fill(ctx, tx, &Getter{PrivateKey: recipientKey, Secret: secret})
+}
+
+// Refund spends the HTLC back to address once the lock time is reached, paying the fee out of the utxo
+func Refund(ctx context.Context, htlc *bt.UTXO, address string, senderKey *bec.PrivateKey) (*bt.Tx, error) {
+	terms, err := State(htlc.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := newSpend(htlc, address)
+	if err != nil {
+		return nil, err
+	}
+	pushtx.SetLockTime(tx, terms.LockTime)
+	return fill(ctx, tx, &Getter{PrivateKey: senderKey})
+}
+
+// ExtractSecret returns the secret revealed by a transaction claiming a HTLC locked to hash
+func ExtractSecret(tx *bt.Tx, hash []byte) ([]byte, error) {
+	for _, in := range tx.Inputs {
+		if in.UnlockingScript == nil {
+			continue
+		}
+		parts, err := bscript.DecodeParts(*in.UnlockingScript)
+		if err != nil || len(parts) != 5 {
+			continue
+		}
+		secret := decodeMinimalPush(parts[0])
+		if h := sha256.Sum256(secret); bytes.Equal(h[:], hash) {
+			return secret, nil
+		}
+	}
+	return nil, errors.New("transaction does not reveal the secret")
+}
+
+// decodeMinimalPush returns the data pushed by a single part, undoing the small number opcodes
+// used for minimal pushes
+func decodeMinimalPush(part []byte) []byte {
+	if len(part) == 1 {
+		switch op := part[0]; {
+		case op == bscript.Op0:
+			return []byte{}
+		case op == bscript.Op1NEGATE:
+			return []byte{0x81}
+		case op >= bscript.Op1 && op <= bscript.Op16:
+			return []byte{op - bscript.Op1 + 1}
+		}
+	}
+	return part
+}
+
+// newSpend spends the HTLC to address
+func newSpend(htlc *bt.UTXO, address string) (*bt.Tx, error) {
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(htlc); err != nil {
+		return nil, err
+	}
+	if err := tx.PayToAddress(address, 0); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// fill signs the HTLC input and pays what is left after the fee to output 0
+func fill(ctx context.Context, tx *bt.Tx, getter *Getter) (*bt.Tx, error) {
+	if err := pushtx.FillAllInputsWithChange(ctx, tx, getter, 0, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks HTLC inputs, claiming with the secret if it is set and refunding otherwise.
+// Everything else falls back to the push tx Getter
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+	Secret     []byte
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), NewRegistration(g.Secret))...)
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// NewRegistration returns the registration of HTLC inputs, for adding HTLCs to a pushtx.Registry.
+// The Getter must have a PrivateKey, and claims with the secret if it is set and refunds otherwise
+func NewRegistration(secret []byte) pushtx.Registration {
+	return pushtx.Registration{
+		Name:     "htlc",
+		Priority: pushtx.PriorityContract,
+		Match:    IsHTLC,
+		Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+			if g.PrivateKey == nil {
+				return nil, errors.New("HTLC needs a private key")
+			}
+			return &Unlocker{PrivateKey: g.PrivateKey, Secret: secret}, nil
+		},
+	}
+}
+
+// Unlocker signs a HTLC input with UnlockPushTx, claiming with the secret if it is set and refunding otherwise
+type Unlocker struct {
+	PrivateKey *bec.PrivateKey
+	Secret     []byte
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	args := [][]byte{script.EncodeNumber(0)}
+	if u.Secret != nil {
+		args = [][]byte{u.Secret, script.EncodeNumber(1)}
+	}
+	unlocker := &pushtx.UnlockPushTx{PrivateKey: u.PrivateKey, Args: args}
+	return unlocker.UnlockingScript(ctx, tx, params)
+}
+
+func encodeTerms(terms *Terms) []byte {
+	b := make([]byte, TermsLength)
+	copy(b, terms.SenderPKH)
+	copy(b[20:], terms.RecipientPKH)
+	copy(b[40:], terms.Hash)
+	binary.LittleEndian.PutUint32(b[72:], terms.LockTime)
+	return b
+}
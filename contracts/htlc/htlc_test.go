@@ -0,0 +1,146 @@
+package htlc
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestHTLC(t *testing.T) {
+	t.Parallel()
+	senderKey, sender := testutil.NewKey(t)
+	recipientKey, recipient := testutil.NewKey(t)
+	secret := []byte("correct horse battery staple")
+	hash := sha256.Sum256(secret)
+
+	terms, err := NewTerms(sender, recipient, hash[:], 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newHTLC := func() *bt.UTXO {
+		tx, err := NewHTLCTransaction(context.Background(), testutil.NewFundingUTXO(t, sender, 100000), terms, 50000, sender, senderKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(tx); err != nil {
+			t.Fatal(err)
+		}
+		return testutil.OutputUTXO(tx, 0)
+	}
+
+	var tests = []struct {
+		name          string
+		spend         func(htlc *bt.UTXO) (*bt.Tx, error)
+		expectedError bool
+	}{
+		{"recipient claims", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			return Claim(context.Background(), htlc, secret, recipient, recipientKey)
+		}, false},
+		{"sender refunds", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			return Refund(context.Background(), htlc, sender, senderKey)
+		}, false},
+		{"sender claims", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			return Claim(context.Background(), htlc, secret, sender, senderKey)
+		}, true},
+		{"recipient refunds", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			return Refund(context.Background(), htlc, recipient, recipientKey)
+		}, true},
+		{"claim with the wrong secret", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			tx, err := newSpend(htlc, recipient)
+			if err != nil {
+				return nil, err
+			}
+			return fill(context.Background(), tx, &Getter{PrivateKey: recipientKey, Secret: []byte("wrong")})
+		}, true},
+		{"recipient claims with the registry", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			tx, err := newSpend(htlc, recipient)
+			if err != nil {
+				return nil, err
+			}
+			registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), NewRegistration(secret))...)
+			getter := &pushtx.Getter{PrivateKey: recipientKey, Registry: registry}
+			return tx, pushtx.FillAllInputsWithChange(context.Background(), tx, getter, 0, bt.NewFeeQuote())
+		}, false},
+		{"refund before the lock time", func(htlc *bt.UTXO) (*bt.Tx, error) {
+			tx, err := newSpend(htlc, sender)
+			if err != nil {
+				return nil, err
+			}
+			pushtx.SetLockTime(tx, 799000)
+			return fill(context.Background(), tx, &Getter{PrivateKey: senderKey})
+		}, true},
+	}
+	for _, test := range tests {
+		tx, err := test.spend(newHTLC())
+		if err != nil {
+			t.Fatalf("%s failed: %v", test.name, err)
+		}
+		err = testutil.Verify(tx)
+		if err != nil && !test.expectedError {
+			t.Errorf("%s failed: unexpected error %v", test.name, err)
+		}
+		if err == nil && test.expectedError {
+			t.Errorf("%s failed: expected HTLC to reject the spend", test.name)
+		}
+	}
+}
+
+func TestExtractSecret(t *testing.T) {
+	t.Parallel()
+	senderKey, sender := testutil.NewKey(t)
+	recipientKey, recipient := testutil.NewKey(t)
+	var tests = []struct {
+		name   string
+		secret []byte
+	}{
+		{"text", []byte("swap secret")},
+		{"32 bytes", bytes.Repeat([]byte{0xab}, 32)},
+		{"small number", []byte{0x07}},
+	}
+	for _, test := range tests {
+		hash := sha256.Sum256(test.secret)
+		terms, err := NewTerms(sender, recipient, hash[:], 800000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := NewHTLCTransaction(context.Background(), testutil.NewFundingUTXO(t, sender, 100000), terms, 50000, sender, senderKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claim, err := Claim(context.Background(), testutil.OutputUTXO(tx, 0), test.secret, recipient, recipientKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(claim); err != nil {
+			t.Fatalf("%s failed: claim failed verification: %v", test.name, err)
+		}
+		secret, err := ExtractSecret(claim, hash[:])
+		if err != nil {
+			t.Fatalf("%s failed: %v", test.name, err)
+		}
+		if !bytes.Equal(secret, test.secret) {
+			t.Errorf("%s failed: expected secret %x, got %x", test.name, test.secret, secret)
+		}
+	}
+}
+
+// a claim unlocks with 5 items and a refund with 4, which leaves 1 more when given 5
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	_, sender := testutil.NewKey(t)
+	_, recipient := testutil.NewKey(t)
+	terms, err := NewTerms(sender, recipient, make([]byte, 32), 800000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := NewLockingScript(terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 5, 1, 2)
+}
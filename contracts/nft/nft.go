@@ -0,0 +1,326 @@
+package nft
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Non-Fungible Token Contract
+---------------------------
+
+Unlocking Script: <suffix> <newOwnerPKH> <sig> <pubKey> <preimage>
+
+Locking Script: <Optimized OP_PUSH_TX> <recreate in output 0> <assetID> <metadataHash> OP_2DROP <P2PKH>
+
+The asset ID is the outpoint spent by input 0 of the mint transaction, so it is unique and
+can be checked by walking back to the mint. Every spend must recreate the same script with
+the owner P2PKH swapped for newOwnerPKH in output 0 with 1 satoshi. Outputs after output 0
+are passed in the unlocking script as <suffix> so the covenant can check them against hashOutputs.
+*/
+
+const (
+	// AssetIDLength is the length of the mint outpoint used as asset ID
+	AssetIDLength = 36
+	// Satoshis carried by the nft output
+	Satoshis = 1
+
+	// p2pkhLength is the length of the owner P2PKH at the end of the locking script
+	p2pkhLength = 25
+)
+
+// NewLockingScript returns the nft locking script for the asset owned by ownerAddress
+func NewLockingScript(assetID, metadataHash []byte, ownerAddress string) (*bscript.Script, error) {
+	if len(assetID) != AssetIDLength {
+		return nil, fmt.Errorf("asset id must be %d bytes", AssetIDLength)
+	}
+	if len(metadataHash) != sha256.Size {
+		return nil, fmt.Errorf("metadata hash must be %d bytes", sha256.Size)
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+
+	// stack: <suffix> <newOwnerPKH> <sig> <pubKey> <preimage>
+	b.Opcodes(bscript.OpDUP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Opcodes(bscript.OpTOALTSTACK)
+
+	// drop the owner P2PKH from scriptCode and append the new owner
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendSplitStateFromPreimage(s, p2pkhLength)
+	})
+	b.Opcodes(bscript.OpDROP, bscript.Op3, bscript.OpROLL, bscript.OpSIZE)
+	b.Number(20)
+	b.Opcodes(bscript.OpEQUALVERIFY)
+	b.PushData([]byte{bscript.OpDUP, bscript.OpHASH160, bscript.OpDATA20})
+	b.Opcodes(bscript.OpSWAP, bscript.OpCAT)
+	b.PushData([]byte{bscript.OpEQUALVERIFY, bscript.OpCHECKSIG})
+	b.Opcodes(bscript.OpCAT, bscript.OpCAT)
+
+	// stack: <suffix> <sig> <pubKey> <new locking script>
+	b.PushData(encodeUint64(Satoshis))
+	b.Opcodes(bscript.OpSWAP, bscript.OpCAT, bscript.Op3, bscript.OpROLL, bscript.OpCAT)
+	b.Opcodes(bscript.OpFROMALTSTACK)
+	b.Append(script.AppendVerifyOutputs)
+
+	// immutable asset
+	b.PushData(assetID).PushData(metadataHash)
+	b.Opcodes(bscript.Op2DROP)
+
+	// owner
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendP2PKH(s, ownerAddress) })
+	return b.Script()
+}
+
+// State returns the asset ID, metadata hash and owner public key hash of an nft locking script
+func State(s *bscript.Script) (assetID, metadataHash, ownerPKH []byte, err error) {
+	b := []byte(*s)
+	codeLength := len(b) - p2pkhLength - (1 + AssetIDLength) - (1 + sha256.Size) - 1
+	if codeLength < 0 {
+		return nil, nil, nil, errors.New("locking script is not an nft")
+	}
+	assetID = b[codeLength+1 : codeLength+1+AssetIDLength]
+	metadataHash = b[codeLength+2+AssetIDLength : codeLength+2+AssetIDLength+sha256.Size]
+	ownerPKH = b[len(b)-22 : len(b)-2]
+
+	owner, err := bscript.NewAddressFromPublicKeyHash(ownerPKH, true)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template, err := NewLockingScript(assetID, metadataHash, owner.AddressString)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !template.Equals(s) {
+		return nil, nil, nil, errors.New("locking script is not an nft")
+	}
+	return assetID, metadataHash, ownerPKH, nil
+}
+
+// IsNFT checks the locking script is an nft contract
+func IsNFT(s *bscript.Script) bool {
+	_, _, _, err := State(s)
+	return err == nil
+}
+
+// Mint spends a P2PKH utxo to create a new nft for the metadata owned by ownerAddress, sending the rest to changeAddress.
+// The asset ID is the outpoint of the utxo
+func Mint(ctx context.Context, utxo *bt.UTXO, metadata []byte, ownerAddress, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	metadataHash := sha256.Sum256(metadata)
+	lockingScript, err := NewLockingScript(outpoint(tx.Inputs[0]), metadataHash[:], ownerAddress)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	return fill(ctx, tx, changeAddress, privateKey)
+}
+
+// Transfer spends an nft utxo, giving it to the recipient in output 0.
+// Fees are paid by the P2PKH funding utxo with the rest sent to changeAddress
+func Transfer(ctx context.Context, nft, funding *bt.UTXO, recipientAddress, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	assetID, metadataHash, _, err := State(nft.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(nft, funding); err != nil {
+		return nil, err
+	}
+	lockingScript, err := NewLockingScript(assetID, metadataHash, recipientAddress)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	return fill(ctx, tx, changeAddress, privateKey)
+}
+
+// fill adds the change output and signs every input
+func fill(ctx context.Context, tx *bt.Tx, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	if err := tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &Getter{PrivateKey: privateKey}
+	if err := pushtx.FillAllInputsWithChange(ctx, tx, getter, len(tx.Outputs)-1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Provider fetches transactions by txid, for example from a node or block explorer
+type Provider interface {
+	GetTransaction(ctx context.Context, txid string) (*bt.Tx, error)
+}
+
+// ProviderFunc adapts a function to the Provider interface
+type ProviderFunc func(ctx context.Context, txid string) (*bt.Tx, error)
+
+// GetTransaction implements the Provider interface
+func (f ProviderFunc) GetTransaction(ctx context.Context, txid string) (*bt.Tx, error) {
+	return f(ctx, txid)
+}
+
+// VerifyProvenance walks the parents of the nft at txid:vout back to its mint transaction, checking
+// every step spends the same asset and that the mint spent the outpoint the asset ID commits to.
+// The mint must create exactly one output of the asset, and the chain must start from it.
+// Returns the chain of transactions starting with txid and ending with the mint
+func VerifyProvenance(ctx context.Context, provider Provider, txid string, vout uint32) ([]*bt.Tx, error) {
+	tx, err := getTransaction(ctx, provider, txid)
+	if err != nil {
+		return nil, err
+	}
+	if int(vout) >= len(tx.Outputs) {
+		return nil, fmt.Errorf("transaction %s has no output %d", txid, vout)
+	}
+	assetID, metadataHash, _, err := State(tx.Outputs[vout].LockingScript)
+	if err != nil {
+		return nil, err
+	}
+
+	chain := []*bt.Tx{tx}
+	for {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		// an outpoint can only be spent once, so the transaction spending the asset id is the mint
+		for _, in := range tx.Inputs {
+			if bytes.Equal(outpoint(in), assetID) {
+				if err = checkMint(tx, vout, assetID); err != nil {
+					return nil, err
+				}
+				return chain, nil
+			}
+		}
+		if tx, vout, err = findParent(ctx, provider, tx, assetID, metadataHash); err != nil {
+			return nil, err
+		}
+		chain = append(chain, tx)
+	}
+}
+
+// checkMint fails unless output vout is the only output of the mint transaction holding the asset
+func checkMint(mint *bt.Tx, vout uint32, assetID []byte) error {
+	minted := -1
+	for i, o := range mint.Outputs {
+		if outputAssetID, _, _, err := State(o.LockingScript); err == nil && bytes.Equal(outputAssetID, assetID) {
+			if minted >= 0 {
+				return fmt.Errorf("mint transaction %s creates more than one output of asset id %s", mint.TxID(), hex.EncodeToString(assetID))
+			}
+			minted = i
+		}
+	}
+	if minted != int(vout) {
+		return fmt.Errorf("chain does not start at the output minted by %s", mint.TxID())
+	}
+	return nil
+}
+
+// findParent returns the parent transaction holding the asset spent by tx, and the output holding it
+func findParent(ctx context.Context, provider Provider, tx *bt.Tx, assetID, metadataHash []byte) (*bt.Tx, uint32, error) {
+	for _, in := range tx.Inputs {
+		parent, err := getTransaction(ctx, provider, in.PreviousTxIDStr())
+		if err != nil {
+			return nil, 0, err
+		}
+		if int(in.PreviousTxOutIndex) >= len(parent.Outputs) {
+			return nil, 0, fmt.Errorf("transaction %s has no output %d", parent.TxID(), in.PreviousTxOutIndex)
+		}
+		parentAssetID, parentMetadataHash, _, err := State(parent.Outputs[in.PreviousTxOutIndex].LockingScript)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(parentAssetID, assetID) && bytes.Equal(parentMetadataHash, metadataHash) {
+			return parent, in.PreviousTxOutIndex, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("transaction %s does not spend asset id %s or its outpoint", tx.TxID(), hex.EncodeToString(assetID))
+}
+
+func getTransaction(ctx context.Context, provider Provider, txid string) (*bt.Tx, error) {
+	tx, err := provider.GetTransaction(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+	if tx.TxID() != txid {
+		return nil, fmt.Errorf("provider returned transaction %s for %s", tx.TxID(), txid)
+	}
+	return tx, nil
+}
+
+// Getter unlocks nft inputs and falls back to the push tx Getter for everything else
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// Registration matches nft inputs, for adding nfts to a pushtx.Registry.
+// The Getter must have a PrivateKey
+var Registration = pushtx.Registration{
+	Name:     "nft",
+	Priority: pushtx.PriorityContract,
+	Match:    IsNFT,
+	Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+		if g.PrivateKey == nil {
+			return nil, errors.New("nft needs a private key")
+		}
+		return &Unlocker{PrivateKey: g.PrivateKey}, nil
+	},
+}
+
+// registry is the default registry with nfts added
+var registry = pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+
+// Unlocker passes the new owner from output 0 to the covenant and signs with UnlockPushTx
+type Unlocker struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	if len(tx.Outputs) == 0 {
+		return nil, errors.New("nft must be recreated in output 0")
+	}
+	_, _, newOwner, err := State(tx.Outputs[0].LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	var suffix []byte
+	for _, o := range tx.Outputs[1:] {
+		suffix = append(suffix, o.Bytes()...)
+	}
+	unlocker := &pushtx.UnlockPushTx{PrivateKey: u.PrivateKey, Args: [][]byte{suffix, newOwner}}
+	return unlocker.UnlockingScript(ctx, tx, params)
+}
+
+// outpoint serializes the outpoint spent by the input as it appears in the transaction
+func outpoint(in *bt.Input) []byte {
+	b := bt.ReverseBytes(in.PreviousTxID())
+	index := make([]byte, 4)
+	binary.LittleEndian.PutUint32(index, in.PreviousTxOutIndex)
+	return append(append([]byte{}, b...), index...)
+}
+
+func encodeUint64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n)
+	return b
+}
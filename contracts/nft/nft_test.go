@@ -0,0 +1,258 @@
+package nft
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+type txStore map[string]*bt.Tx
+
+func (s txStore) add(tx *bt.Tx) {
+	s[tx.TxID()] = tx
+}
+
+func (s txStore) GetTransaction(ctx context.Context, txid string) (*bt.Tx, error) {
+	if tx, ok := s[txid]; ok {
+		return tx, nil
+	}
+	return nil, errors.New("transaction not found")
+}
+
+func TestTransferAndProvenance(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	bobKey, bob := testutil.NewKey(t)
+	_, carol := testutil.NewKey(t)
+	store := txStore{}
+
+	mint, err := Mint(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), []byte("artwork #1"), alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(mint); err != nil {
+		t.Fatalf("mint failed verification: %v", err)
+	}
+	store.add(mint)
+
+	toBob, err := Transfer(context.Background(), testutil.OutputUTXO(mint, 0), testutil.OutputUTXO(mint, 1), bob, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(toBob); err != nil {
+		t.Fatalf("transfer to bob failed verification: %v", err)
+	}
+	store.add(toBob)
+
+	toCarol, err := Transfer(context.Background(), testutil.OutputUTXO(toBob, 0), testutil.NewFundingUTXO(t, bob, 50000), carol, bob, bobKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(toCarol); err != nil {
+		t.Fatalf("transfer to carol failed verification: %v", err)
+	}
+	store.add(toCarol)
+
+	chain, err := VerifyProvenance(context.Background(), store, toCarol.TxID(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[2].TxID() != mint.TxID() {
+		t.Errorf("expected chain of 3 transactions ending with the mint, got %d", len(chain))
+	}
+
+	assetID, metadataHash, _, err := State(toCarol.Outputs[0].LockingScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHash := sha256.Sum256([]byte("artwork #1"))
+	if string(metadataHash) != string(expectedHash[:]) || string(assetID) != string(outpoint(mint.Inputs[0])) {
+		t.Error("expected asset id and metadata hash to be carried from the mint")
+	}
+}
+
+func TestTransferRequiresOwner(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	thiefKey, thief := testutil.NewKey(t)
+	mint, err := Mint(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), []byte("artwork #2"), alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := Transfer(context.Background(), testutil.OutputUTXO(mint, 0), testutil.NewFundingUTXO(t, thief, 50000), thief, thief, thiefKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err == nil {
+		t.Error("expected transfer signed by someone other than the owner to fail")
+	}
+}
+
+func TestTransferMustKeepAsset(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	_, bob := testutil.NewKey(t)
+	mint, err := Mint(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), []byte("artwork #3"), alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assetID, _, _, err := State(mint.Outputs[0].LockingScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherHash := sha256.Sum256([]byte("something else"))
+	lockingScript, err := NewLockingScript(assetID, otherHash[:], bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(testutil.OutputUTXO(mint, 0), testutil.OutputUTXO(mint, 1)); err != nil {
+		t.Fatal(err)
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(alice, 90000); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.FillAllInputs(context.Background(), &Getter{PrivateKey: aliceKey}); err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err == nil {
+		t.Error("expected covenant to reject changing the metadata hash")
+	}
+}
+
+func TestVerifyProvenanceRejectsCopy(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	mallory, malloryAddress := testutil.NewKey(t)
+	store := txStore{}
+
+	mint, err := Mint(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), []byte("artwork #4"), alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.add(mint)
+	assetID, metadataHash, _, err := State(mint.Outputs[0].LockingScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy of the asset created without spending its outpoint
+	copied, err := NewLockingScript(assetID, metadataHash, malloryAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	funding := testutil.NewFundingUTXO(t, malloryAddress, 100000)
+	funding.TxID = mint.TxIDBytes()
+	funding.Vout = 1
+	fake := bt.NewTx()
+	if err = fake.FromUTXOs(funding); err != nil {
+		t.Fatal(err)
+	}
+	fake.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: copied})
+	if err = fake.FillAllInputs(context.Background(), &Getter{PrivateKey: mallory}); err != nil {
+		t.Fatal(err)
+	}
+	store.add(fake)
+
+	if _, err = VerifyProvenance(context.Background(), store, fake.TxID(), 0); err == nil {
+		t.Error("expected provenance of a copied asset to fail")
+	}
+	if _, err = VerifyProvenance(context.Background(), store, mint.TxID(), 0); err != nil {
+		t.Errorf("expected provenance of the mint to pass: %v", err)
+	}
+}
+
+func TestVerifyProvenanceRejectsDoubleMint(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	store := txStore{}
+
+	// a mint creating two outputs of the same asset
+	mint := bt.NewTx()
+	if err := mint.FromUTXOs(testutil.NewFundingUTXO(t, alice, 100000)); err != nil {
+		t.Fatal(err)
+	}
+	metadataHash := sha256.Sum256([]byte("artwork #5"))
+	for i := 0; i < 2; i++ {
+		lockingScript, err := NewLockingScript(outpoint(mint.Inputs[0]), metadataHash[:], alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mint.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	}
+	if err := mint.FillAllInputs(context.Background(), &Getter{PrivateKey: aliceKey}); err != nil {
+		t.Fatal(err)
+	}
+	store.add(mint)
+
+	transfer, err := Transfer(context.Background(), testutil.OutputUTXO(mint, 1), testutil.NewFundingUTXO(t, alice, 50000), alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.add(transfer)
+
+	for _, vout := range []uint32{0, 1} {
+		if _, err = VerifyProvenance(context.Background(), store, mint.TxID(), vout); err == nil {
+			t.Errorf("expected provenance of output %d of a double mint to fail", vout)
+		}
+	}
+	if _, err = VerifyProvenance(context.Background(), store, transfer.TxID(), 0); err == nil {
+		t.Error("expected provenance of a transfer from a double mint to fail")
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	_, bob := testutil.NewKey(t)
+	mint, err := Mint(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), []byte("artwork #1"), alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+	if r, ok := registry.Match(mint.Outputs[0].LockingScript); !ok || r.Name != Registration.Name {
+		t.Fatalf("expected the registry to match %q, got %q", Registration.Name, r.Name)
+	}
+
+	assetID, metadataHash, _, err := State(mint.Outputs[0].LockingScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := NewLockingScript(assetID, metadataHash, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(testutil.OutputUTXO(mint, 0), testutil.OutputUTXO(mint, 1)); err != nil {
+		t.Fatal(err)
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(alice, 0); err != nil {
+		t.Fatal(err)
+	}
+	getter := &pushtx.Getter{PrivateKey: aliceKey, Registry: registry}
+	if err = pushtx.FillAllInputsWithChange(context.Background(), tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Errorf("transfer with the registry failed verification: %v", err)
+	}
+}
+
+// the unlocking script is <suffix> <newOwnerPKH> <sig> <pubKey> <preimage> and a valid spend leaves true
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	_, owner := testutil.NewKey(t)
+	lockingScript, err := NewLockingScript(make([]byte, 36), make([]byte, 32), owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 5, 1)
+}
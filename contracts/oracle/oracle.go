@@ -0,0 +1,258 @@
+package oracle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/rabin"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Oracle Attested Contract
+------------------------
+
+Unlocking Script: <suffix> <outcome> <padding> <sig> <preimage>
+
+Locking Script: <Optimized OP_PUSH_TX> <Rabin verify of sha256(eventID) || outcome> <payout for outcome> <output check>
+
+The oracle attests to the outcome of an event by Rabin signing sha256(eventID) || outcome, so an attestation
+cannot be replayed against another event. Hashing the event ID fixes its length, so no other event and
+outcome give the same message. Anyone holding the attestation can settle the contract, but
+output 0 must pay the whole value to the address of the attested outcome. Fees are paid by additional
+P2PKH inputs, and any outputs after output 0 are passed in the unlocking script as <suffix>.
+*/
+
+// Payout pays the contract to Address if the oracle attests to Outcome
+type Payout struct {
+	Outcome []byte
+	Address string
+}
+
+// Contract settled by an oracle attesting to the outcome of an event
+type Contract struct {
+	OracleKey *rabin.PublicKey
+	EventID   []byte
+	Payouts   []Payout
+}
+
+// Attestation of an oracle to the outcome of an event
+type Attestation struct {
+	Outcome []byte
+	Padding []byte
+	Sig     *big.Int
+}
+
+// Attest signs the outcome of the event with the oracle key
+func Attest(key *rabin.PrivateKey, eventID, outcome []byte) (*Attestation, error) {
+	sig, padding, err := key.Sign(message(eventID, outcome))
+	if err != nil {
+		return nil, err
+	}
+	return &Attestation{Outcome: outcome, Padding: padding, Sig: sig}, nil
+}
+
+// Verify checks the attestation is signed by the oracle of the contract
+func (c *Contract) Verify(a *Attestation) bool {
+	return c.OracleKey.Verify(message(c.EventID, a.Outcome), a.Padding, a.Sig)
+}
+
+// message is the attested message of the outcome, sha256(eventID) || outcome
+func message(eventID, outcome []byte) []byte {
+	h := sha256.Sum256(eventID)
+	return append(h[:], outcome...)
+}
+
+// LockingScript returns the locking script of the contract
+func (c *Contract) LockingScript() (*bscript.Script, error) {
+	if len(c.Payouts) == 0 {
+		return nil, errors.New("contract needs at least one payout")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+	b.Opcodes(bscript.OpTOALTSTACK)
+
+	// stack: <suffix> <outcome> <padding> <sig>
+	b.Opcodes(bscript.OpROT)
+	eventHash := sha256.Sum256(c.EventID)
+	b.PushData(eventHash[:])
+	b.Opcodes(bscript.OpSWAP, bscript.OpCAT, bscript.OpROT, bscript.OpROT)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendRabinVerify(s, c.OracleKey.N) })
+
+	// stack: <suffix> <message>
+	// replace the message with the public key hash paid for it
+	for i, p := range c.Payouts {
+		a, err := bscript.NewAddressFromString(p.Address)
+		if err != nil {
+			return nil, err
+		}
+		pkh, err := hex.DecodeString(a.PublicKeyHash)
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(c.Payouts)-1
+		if !last {
+			b.Opcodes(bscript.OpDUP)
+		}
+		b.PushData(message(c.EventID, p.Outcome))
+		if last {
+			b.Opcodes(bscript.OpEQUALVERIFY)
+		} else {
+			b.Opcodes(bscript.OpEQUAL, bscript.OpIF, bscript.OpDROP)
+		}
+		b.PushData(pkh)
+		if !last {
+			b.Opcodes(bscript.OpELSE)
+		}
+	}
+	for i := 1; i < len(c.Payouts); i++ {
+		b.Opcodes(bscript.OpENDIF)
+	}
+
+	// stack: <suffix> <pkh>
+	// output 0 pays the whole value to pkh
+	b.Opcodes(bscript.OpFROMALTSTACK, bscript.OpDUP)
+	b.Append(script.AppendGetValueFromPreimage)
+	b.Opcodes(bscript.OpROT)
+	b.Append(script.AppendBuildP2PKHOutput)
+	b.Opcodes(bscript.OpROT, bscript.OpCAT, bscript.OpSWAP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Append(script.AppendVerifyOutputs)
+	b.Opcodes(bscript.OpTRUE)
+	return b.Script()
+}
+
+// payout returns the address paid for the outcome
+func (c *Contract) payout(outcome []byte) (string, error) {
+	for _, p := range c.Payouts {
+		if string(p.Outcome) == string(outcome) {
+			return p.Address, nil
+		}
+	}
+	return "", errors.New("outcome has no payout")
+}
+
+// NewOracleContractTransaction spends a P2PKH utxo to lock satoshis in the contract. The rest is sent to changeAddress
+func NewOracleContractTransaction(ctx context.Context, utxo *bt.UTXO, c *Contract, satoshis uint64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	lockingScript, err := c.LockingScript()
+	if err != nil {
+		return nil, err
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &pushtx.Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Settle pays the contract utxo to the address of the attested outcome.
+// The funding utxo paying the fee belongs to privateKey
+func Settle(ctx context.Context, c *Contract, utxo, funding *bt.UTXO, a *Attestation, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	if !c.Verify(a) {
+		return nil, errors.New("attestation is not signed by the oracle")
+	}
+	address, err := c.payout(a.Outcome)
+	if err != nil {
+		return nil, err
+	}
+	return settle(ctx, c, utxo, funding, a, address, changeAddress, privateKey)
+}
+
+// settle pays the contract utxo to address with the attestation
+func settle(ctx context.Context, c *Contract, utxo, funding *bt.UTXO, a *Attestation, address, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(utxo, funding); err != nil {
+		return nil, err
+	}
+	if err := tx.PayToAddress(address, utxo.Satoshis); err != nil {
+		return nil, err
+	}
+	if err := tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &Getter{PrivateKey: privateKey, Contract: c, Attestation: a}
+	if err := pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks inputs of the contract with the attestation and falls back to the push tx Getter for everything else
+type Getter struct {
+	PrivateKey  *bec.PrivateKey
+	Contract    *Contract
+	Attestation *Attestation
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	registration, err := NewRegistration(g.Contract, g.Attestation)
+	if err != nil {
+		return nil, err
+	}
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), registration)...)
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// NewRegistration returns the registration of inputs of the contract, settled with the attestation,
+// for adding the contract to a pushtx.Registry. No key is needed
+func NewRegistration(c *Contract, a *Attestation) (pushtx.Registration, error) {
+	contractScript, err := c.LockingScript()
+	if err != nil {
+		return pushtx.Registration{}, err
+	}
+	return pushtx.Registration{
+		Name:     "oracle",
+		Priority: pushtx.PriorityContract,
+		Match:    contractScript.Equals,
+		Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+			return &Unlocker{Attestation: a}, nil
+		},
+	}, nil
+}
+
+// Unlocker settles a contract input with the attestation, no signature is needed
+type Unlocker struct {
+	Attestation *Attestation
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	if params.SigHashFlags == 0 {
+		params.SigHashFlags = sighash.AllForkID
+	}
+	if len(tx.Outputs) == 0 {
+		return nil, errors.New("settlement needs a payout output")
+	}
+	preimage, err := pushtx.LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	var suffix []byte
+	for _, o := range tx.Outputs[1:] {
+		suffix = append(suffix, o.Bytes()...)
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	for _, arg := range [][]byte{suffix, u.Attestation.Outcome, u.Attestation.Padding, script.EncodeBigNumber(u.Attestation.Sig)} {
+		b.Append(func(s *bscript.Script) (*bscript.Script, error) { return script.AppendPushDataMinimal(s, arg) })
+	}
+	return b.PushData(preimage).Script()
+}
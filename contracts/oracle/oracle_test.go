@@ -0,0 +1,182 @@
+package oracle
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	"github.com/murray-distributed-technologies/go-pushtx/rabin"
+)
+
+func TestSettle(t *testing.T) {
+	t.Parallel()
+	oracleKey, err := rabin.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rabin.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey, alice := testutil.NewKey(t)
+	_, bob := testutil.NewKey(t)
+	_, carol := testutil.NewKey(t)
+	c := &Contract{
+		OracleKey: &oracleKey.PublicKey,
+		EventID:   []byte("match 42"),
+		Payouts: []Payout{
+			{Outcome: []byte("home"), Address: alice},
+			{Outcome: []byte("away"), Address: bob},
+			{Outcome: []byte("draw"), Address: carol},
+		},
+	}
+
+	var tests = []struct {
+		name          string
+		key           *rabin.PrivateKey
+		eventID       []byte
+		outcome       []byte
+		payTo         string
+		expectedError bool
+	}{
+		{"first outcome", oracleKey, c.EventID, []byte("home"), alice, false},
+		{"middle outcome", oracleKey, c.EventID, []byte("away"), bob, false},
+		{"last outcome", oracleKey, c.EventID, []byte("draw"), carol, false},
+		{"paid to the wrong outcome", oracleKey, c.EventID, []byte("away"), alice, true},
+		{"another oracle", otherKey, c.EventID, []byte("home"), alice, true},
+		{"another event", oracleKey, []byte("match 43"), []byte("home"), alice, true},
+		{"unknown outcome", oracleKey, c.EventID, []byte("abandoned"), alice, true},
+	}
+	for _, test := range tests {
+		tx, err := NewOracleContractTransaction(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), c, 50000, alice, aliceKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(tx); err != nil {
+			t.Fatal(err)
+		}
+		a, err := Attest(test.key, test.eventID, test.outcome)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// build the settlement directly so the contract is the one rejecting it
+		settlement, err := settle(context.Background(), c, testutil.OutputUTXO(tx, 0), testutil.NewFundingUTXO(t, alice, 10000), a, test.payTo, alice, aliceKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = testutil.Verify(settlement)
+		if err != nil && !test.expectedError {
+			t.Errorf("%s failed: unexpected error %v", test.name, err)
+		}
+		if err == nil && test.expectedError {
+			t.Errorf("%s failed: expected contract to reject the settlement", test.name)
+		}
+		expected, err := bscript.NewP2PKHFromAddress(test.payTo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !settlement.Outputs[0].LockingScript.Equals(expected) {
+			t.Errorf("%s failed: expected output 0 to pay %s", test.name, test.payTo)
+		}
+	}
+}
+
+func TestSettleChecksAttestation(t *testing.T) {
+	t.Parallel()
+	oracleKey, err := rabin.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rabin.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey, alice := testutil.NewKey(t)
+	c := &Contract{
+		OracleKey: &oracleKey.PublicKey,
+		EventID:   []byte("flight 7 delayed"),
+		Payouts:   []Payout{{Outcome: []byte{1}, Address: alice}},
+	}
+	tx, err := NewOracleContractTransaction(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), c, 50000, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := Attest(otherKey, c.EventID, []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Settle(context.Background(), c, testutil.OutputUTXO(tx, 0), testutil.NewFundingUTXO(t, alice, 10000), forged, alice, aliceKey); err == nil {
+		t.Error("expected settlement with a forged attestation to fail")
+	}
+
+	a, err := Attest(oracleKey, c.EventID, []byte{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	settlement, err := Settle(context.Background(), c, testutil.OutputUTXO(tx, 0), testutil.NewFundingUTXO(t, alice, 10000), a, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(settlement); err != nil {
+		t.Errorf("settlement failed verification: %v", err)
+	}
+}
+
+// TestSettleRejectsShiftedEvent replays an attestation of event "match 4" with outcome "2home"
+// as outcome "home" of event "match 42", which would be the same message without hashing the event ID
+func TestSettleRejectsShiftedEvent(t *testing.T) {
+	t.Parallel()
+	oracleKey, err := rabin.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey, alice := testutil.NewKey(t)
+	c := &Contract{
+		OracleKey: &oracleKey.PublicKey,
+		EventID:   []byte("match 42"),
+		Payouts:   []Payout{{Outcome: []byte("home"), Address: alice}},
+	}
+	tx, err := NewOracleContractTransaction(context.Background(), testutil.NewFundingUTXO(t, alice, 100000), c, 50000, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Attest(oracleKey, []byte("match 4"), []byte("2home"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Outcome = []byte("home")
+	if c.Verify(a) {
+		t.Error("expected the attestation of another event not to verify")
+	}
+	settlement, err := settle(context.Background(), c, testutil.OutputUTXO(tx, 0), testutil.NewFundingUTXO(t, alice, 10000), a, alice, alice, aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(settlement); err == nil {
+		t.Error("expected contract to reject the attestation of another event")
+	}
+}
+
+// the unlocking script is <suffix> <outcome> <padding> <sig> <preimage> and a valid settlement leaves true
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	oracleKey, err := rabin.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, alice := testutil.NewKey(t)
+	_, bob := testutil.NewKey(t)
+	c := &Contract{
+		OracleKey: &oracleKey.PublicKey,
+		EventID:   []byte("match 42"),
+		Payouts:   []Payout{{Outcome: []byte("home"), Address: alice}, {Outcome: []byte("away"), Address: bob}},
+	}
+	lockingScript, err := c.LockingScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 5, 1)
+}
@@ -0,0 +1,321 @@
+package recurring
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Recurring Payment Contract
+--------------------------
+
+Unlocking Script: <suffix> <periods> <claim> <sig> <pubKey> <preimage>
+
+Locking Script: <Optimized OP_PUSH_TX> <beneficiary check> <claim check> OP_RETURN <beneficiaryPKH> <amountPerPeriod> <period> <lastClaim>
+
+The beneficiary may claim up to amountPerPeriod for every whole period of blocks since lastClaim.
+Claiming n periods requires nLocktime to be at least lastClaim + n * period, and moves lastClaim
+forward by exactly n * period so part periods are not lost. Whatever is not claimed must go back
+into the same contract in output 0, the claimed satoshis can go anywhere in the outputs after it.
+*/
+
+const (
+	// StateLength is the length of the terms and last claim height at the end of the locking script
+	StateLength = 36
+)
+
+// Terms of a recurring payment contract
+type Terms struct {
+	BeneficiaryPKH  []byte
+	AmountPerPeriod uint64
+	Period          uint32
+	LastClaim       uint32
+}
+
+// NewLockingScript returns the recurring payment locking script for the terms
+func NewLockingScript(terms *Terms) (*bscript.Script, error) {
+	if len(terms.BeneficiaryPKH) != 20 {
+		return nil, errors.New("beneficiary public key hash must be 20 bytes")
+	}
+	if terms.AmountPerPeriod == 0 || terms.Period == 0 {
+		return nil, errors.New("amount per period and period must be positive")
+	}
+	if terms.LastClaim >= script.LockTimeThreshold {
+		return nil, errors.New("last claim must be a block height")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+
+	// stack: <suffix> <periods> <claim> <sig> <pubKey> <preimage>
+	b.Opcodes(bscript.OpDUP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendSplitStateFromPreimage(s, StateLength)
+	})
+	b.Number(32)
+	b.Opcodes(bscript.OpSPLIT, bscript.OpBIN2NUM, bscript.OpTOALTSTACK)
+	// keep <scriptCode without state> <terms> to rebuild the contract output
+	b.Opcodes(bscript.OpTUCK, bscript.OpCAT, bscript.OpSWAP)
+	b.Number(20)
+	b.Opcodes(bscript.OpSPLIT, bscript.Op8, bscript.OpSPLIT)
+	b.PushData([]byte{0x00})
+	b.Opcodes(bscript.OpCAT, bscript.OpBIN2NUM, bscript.OpSWAP)
+	b.PushData([]byte{0x00})
+	b.Opcodes(bscript.OpCAT, bscript.OpBIN2NUM, bscript.OpSWAP)
+
+	// stack: <suffix> <periods> <claim> <sig> <pubKey> <preimage> <base> <beneficiaryPKH> <amountPerPeriod> <period>
+	// beneficiary check
+	b.Opcodes(bscript.Op5, bscript.OpPICK, bscript.OpHASH160, bscript.Op3, bscript.OpPICK, bscript.OpEQUALVERIFY)
+	b.Opcodes(bscript.Op6, bscript.OpROLL, bscript.Op6, bscript.OpROLL, bscript.OpCHECKSIGVERIFY)
+	b.Opcodes(bscript.OpROT, bscript.OpDROP)
+
+	// stack: <suffix> <periods> <claim> <preimage> <base> <amountPerPeriod> <period>
+	// nLocktime must be an enforced block height
+	b.Opcodes(bscript.Op3, bscript.OpPICK, bscript.OpDUP)
+	b.Append(script.AppendGetNSequenceFromPreimage)
+	b.Number(0xffffffff)
+	b.Opcodes(bscript.OpLESSTHAN, bscript.OpVERIFY)
+	b.Append(script.AppendGetNLocktimeFromPreimage)
+	b.Opcodes(bscript.OpDUP)
+	b.Number(script.LockTimeThreshold)
+	b.Opcodes(bscript.OpLESSTHAN, bscript.OpVERIFY)
+
+	// newLastClaim = lastClaim + periods * period <= nLocktime
+	b.Opcodes(bscript.Op6, bscript.OpPICK, bscript.OpDUP, bscript.Op1, bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+	b.Opcodes(bscript.Op2, bscript.OpROLL, bscript.OpMUL, bscript.OpFROMALTSTACK, bscript.OpADD)
+	b.Opcodes(bscript.OpTUCK, bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+
+	// 0 < claim <= periods * amountPerPeriod
+	b.Opcodes(bscript.OpSWAP, bscript.Op5, bscript.OpROLL, bscript.OpMUL)
+	b.Opcodes(bscript.Op4, bscript.OpPICK, bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHAN, bscript.OpVERIFY)
+	b.Opcodes(bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+
+	// stack: <suffix> <claim> <preimage> <base> <newLastClaim>
+	// remaining = value - claim goes back into the contract
+	b.Opcodes(bscript.Op2, bscript.OpPICK)
+	b.Append(script.AppendGetValueFromPreimage)
+	b.PushData([]byte{0x00})
+	b.Opcodes(bscript.OpCAT, bscript.OpBIN2NUM)
+	b.Opcodes(bscript.Op4, bscript.OpROLL, bscript.OpSUB)
+	b.Opcodes(bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+	b.Opcodes(bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHAN, bscript.OpIF)
+	b.Opcodes(bscript.Op8, bscript.OpNUM2BIN, bscript.OpROT, bscript.OpROT, bscript.Op4, bscript.OpNUM2BIN, bscript.OpCAT, bscript.OpCAT)
+	b.Opcodes(bscript.OpELSE)
+	// the contract is used up
+	b.Opcodes(bscript.Op2DROP, bscript.OpDROP, bscript.Op0)
+	b.Opcodes(bscript.OpENDIF)
+
+	// stack: <suffix> <preimage> <contractOutput>
+	b.Opcodes(bscript.OpROT, bscript.OpCAT, bscript.OpSWAP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Append(script.AppendVerifyOutputs)
+	b.Opcodes(bscript.OpTRUE)
+
+	// state
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeState(terms))
+	return b.Script()
+}
+
+// State returns the terms held in a recurring payment locking script
+func State(s *bscript.Script) (*Terms, error) {
+	b := []byte(*s)
+	if len(b) < StateLength {
+		return nil, errors.New("locking script is not a recurring payment")
+	}
+	state := b[len(b)-StateLength:]
+	terms := &Terms{
+		BeneficiaryPKH:  state[:20],
+		AmountPerPeriod: binary.LittleEndian.Uint64(state[20:28]),
+		Period:          binary.LittleEndian.Uint32(state[28:32]),
+		LastClaim:       binary.LittleEndian.Uint32(state[32:36]),
+	}
+	template, err := NewLockingScript(terms)
+	if err != nil || !template.Equals(s) {
+		return nil, errors.New("locking script is not a recurring payment")
+	}
+	return terms, nil
+}
+
+// IsRecurring checks the locking script is a recurring payment contract
+func IsRecurring(s *bscript.Script) bool {
+	_, err := State(s)
+	return err == nil
+}
+
+// NewRecurringPaymentTransaction spends a P2PKH utxo to lock satoshis for the beneficiary, who can claim
+// amountPerPeriod every period blocks starting from startHeight. The rest is sent to changeAddress
+func NewRecurringPaymentTransaction(ctx context.Context, utxo *bt.UTXO, beneficiaryAddress string, amountPerPeriod uint64, period, startHeight uint32, satoshis uint64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	a, err := bscript.NewAddressFromString(beneficiaryAddress)
+	if err != nil {
+		return nil, err
+	}
+	pkh, err := hex.DecodeString(a.PublicKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	lockingScript, err := NewLockingScript(&Terms{
+		BeneficiaryPKH:  pkh,
+		AmountPerPeriod: amountPerPeriod,
+		Period:          period,
+		LastClaim:       startHeight,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: satoshis, LockingScript: lockingScript})
+	if err = tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	getter := &pushtx.Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, 1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Claimable returns how many satoshis can be claimed from the utxo at height, and the number of periods it uses
+func Claimable(utxo *bt.UTXO, height uint32) (uint64, uint32, error) {
+	terms, err := State(utxo.LockingScript)
+	if err != nil {
+		return 0, 0, err
+	}
+	if height < terms.LastClaim {
+		return 0, 0, nil
+	}
+	periods := (height - terms.LastClaim) / terms.Period
+	amount := uint64(periods) * terms.AmountPerPeriod
+	if amount > utxo.Satoshis {
+		amount = utxo.Satoshis
+	}
+	return amount, periods, nil
+}
+
+// Claim builds the transaction claiming everything claimable at height to address, paying the fee out of the claim.
+// Only the beneficiary can sign it, and it cannot be mined before height
+func Claim(ctx context.Context, utxo *bt.UTXO, height uint32, address string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	terms, err := State(utxo.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	amount, periods, err := Claimable(utxo, height)
+	if err != nil {
+		return nil, err
+	}
+	if amount == 0 {
+		return nil, errors.New("nothing to claim yet")
+	}
+
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	if remaining := utxo.Satoshis - amount; remaining > 0 {
+		next := *terms
+		next.LastClaim = terms.LastClaim + periods*terms.Period
+		lockingScript, err := NewLockingScript(&next)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddOutput(&bt.Output{Satoshis: remaining, LockingScript: lockingScript})
+	}
+	if err = tx.PayToAddress(address, 0); err != nil {
+		return nil, err
+	}
+	pushtx.SetLockTime(tx, height)
+
+	getter := &Getter{PrivateKey: privateKey}
+	if err = pushtx.FillAllInputsWithChange(ctx, tx, getter, len(tx.Outputs)-1, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks recurring payment inputs and falls back to the push tx Getter for everything else
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// Registration matches recurring payment inputs, for adding them to a pushtx.Registry.
+// The Getter must have a PrivateKey
+var Registration = pushtx.Registration{
+	Name:     "recurring",
+	Priority: pushtx.PriorityContract,
+	Match:    IsRecurring,
+	Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+		if g.PrivateKey == nil {
+			return nil, errors.New("recurring payment needs a private key")
+		}
+		return &Unlocker{PrivateKey: g.PrivateKey}, nil
+	},
+}
+
+// registry is the default registry with recurring payments added
+var registry = pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+
+// Unlocker works out the claim from the outputs of the transaction and signs with UnlockPushTx
+type Unlocker struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	in := tx.Inputs[params.InputIdx]
+	terms, err := State(in.PreviousTxScript)
+	if err != nil {
+		return nil, err
+	}
+
+	var claim, periods uint64
+	suffix := tx.Outputs
+	if len(tx.Outputs) > 0 {
+		if next, err := State(tx.Outputs[0].LockingScript); err == nil {
+			if next.LastClaim <= terms.LastClaim || tx.Outputs[0].Satoshis > in.PreviousTxSatoshis {
+				return nil, errors.New("contract output does not follow the claimed contract")
+			}
+			claim = in.PreviousTxSatoshis - tx.Outputs[0].Satoshis
+			periods = uint64((next.LastClaim - terms.LastClaim) / terms.Period)
+			suffix = tx.Outputs[1:]
+		}
+	}
+	if len(suffix) == len(tx.Outputs) {
+		// the whole contract is claimed, use the fewest periods that cover it
+		claim = in.PreviousTxSatoshis
+		periods = (claim + terms.AmountPerPeriod - 1) / terms.AmountPerPeriod
+	}
+
+	var b []byte
+	for _, o := range suffix {
+		b = append(b, o.Bytes()...)
+	}
+	args := [][]byte{b, script.EncodeNumber(int64(periods)), script.EncodeNumber(int64(claim))}
+	unlocker := &pushtx.UnlockPushTx{PrivateKey: u.PrivateKey, Args: args}
+	return unlocker.UnlockingScript(ctx, tx, params)
+}
+
+func encodeState(terms *Terms) []byte {
+	b := make([]byte, StateLength)
+	copy(b, terms.BeneficiaryPKH)
+	binary.LittleEndian.PutUint64(b[20:28], terms.AmountPerPeriod)
+	binary.LittleEndian.PutUint32(b[28:32], terms.Period)
+	binary.LittleEndian.PutUint32(b[32:36], terms.LastClaim)
+	return b
+}
@@ -0,0 +1,155 @@
+package recurring
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestClaim(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	beneficiaryKey, beneficiary := testutil.NewKey(t)
+
+	tx, err := NewRecurringPaymentTransaction(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), beneficiary, 10000, 144, 800000, 25000, funder, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Fatalf("create failed verification: %v", err)
+	}
+
+	var tests = []struct {
+		name      string
+		height    uint32
+		claimable uint64
+		remaining uint64
+	}{
+		{"one period and a bit", 800200, 10000, 15000},
+		{"part period carried over", 800300, 10000, 5000},
+		{"claim more than is left", 801000, 5000, 0},
+	}
+	utxo := testutil.OutputUTXO(tx, 0)
+	for _, test := range tests {
+		amount, _, err := Claimable(utxo, test.height)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if amount != test.claimable {
+			t.Fatalf("%s failed: expected %d claimable, got %d", test.name, test.claimable, amount)
+		}
+		claim, err := Claim(context.Background(), utxo, test.height, beneficiary, beneficiaryKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(claim); err != nil {
+			t.Fatalf("%s failed verification: %v", test.name, err)
+		}
+		if test.remaining == 0 {
+			if IsRecurring(claim.Outputs[0].LockingScript) {
+				t.Errorf("%s failed: expected contract to be used up", test.name)
+			}
+			break
+		}
+		if claim.Outputs[0].Satoshis != test.remaining {
+			t.Errorf("%s failed: expected %d left in the contract, got %d", test.name, test.remaining, claim.Outputs[0].Satoshis)
+		}
+		utxo = testutil.OutputUTXO(claim, 0)
+	}
+}
+
+func TestClaimRejected(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	beneficiaryKey, beneficiary := testutil.NewKey(t)
+	tx, err := NewRecurringPaymentTransaction(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), beneficiary, 10000, 144, 800000, 50000, funder, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	terms, err := State(tx.Outputs[0].LockingScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		claim     uint64
+		lastClaim uint32
+		lockTime  uint32
+		key       bool
+	}{
+		{"too early", 10000, 800144, 800100, true},
+		{"more than a period", 15000, 800144, 800150, true},
+		{"not the beneficiary", 10000, 800144, 800150, false},
+	}
+	for _, test := range tests {
+		next := *terms
+		next.LastClaim = test.lastClaim
+		lockingScript, err := NewLockingScript(&next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claim := bt.NewTx()
+		if err = claim.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+			t.Fatal(err)
+		}
+		claim.AddOutput(&bt.Output{Satoshis: 50000 - test.claim, LockingScript: lockingScript})
+		if err = claim.PayToAddress(beneficiary, test.claim-1000); err != nil {
+			t.Fatal(err)
+		}
+		pushtx.SetLockTime(claim, test.lockTime)
+		getter := &Getter{PrivateKey: beneficiaryKey}
+		if !test.key {
+			getter.PrivateKey = funderKey
+		}
+		if err = claim.FillAllInputs(context.Background(), getter); err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(claim); err == nil {
+			t.Errorf("%s failed: expected claim to be rejected", test.name)
+		}
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	t.Parallel()
+	funderKey, funder := testutil.NewKey(t)
+	beneficiaryKey, beneficiary := testutil.NewKey(t)
+	tx, err := NewRecurringPaymentTransaction(context.Background(), testutil.NewFundingUTXO(t, funder, 100000), beneficiary, 10000, 144, 800000, 25000, funder, funderKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), Registration)...)
+	if r, ok := registry.Match(tx.Outputs[0].LockingScript); !ok || r.Name != Registration.Name {
+		t.Fatalf("expected the registry to match %q, got %q", Registration.Name, r.Name)
+	}
+
+	claim := bt.NewTx()
+	if err = claim.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err = claim.PayToAddress(beneficiary, 0); err != nil {
+		t.Fatal(err)
+	}
+	pushtx.SetLockTime(claim, 801000)
+	getter := &pushtx.Getter{PrivateKey: beneficiaryKey, Registry: registry}
+	if err = pushtx.FillAllInputsWithChange(context.Background(), claim, getter, 0, bt.NewFeeQuote()); err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(claim); err != nil {
+		t.Errorf("claim with the registry failed verification: %v", err)
+	}
+}
+
+// the unlocking script is <suffix> <periods> <claim> <sig> <pubKey> <preimage> and a valid claim leaves true
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	lockingScript, err := NewLockingScript(&Terms{BeneficiaryPKH: make([]byte, 20), AmountPerPeriod: 10000, Period: 144, LastClaim: 800000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 6, 1)
+}
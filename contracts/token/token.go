@@ -0,0 +1,445 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+/*
+Fungible Token Contract
+-----------------------
+
+Unlocking Script: <args...> <op> <sig> <pubKey> <preimage>
+
+Locking Script: <Optimized OP_PUSH_TX> <owner check> <op> OP_RETURN <ownerPKH> <balance>
+
+Token outputs always carry 1 satoshi. Fees are paid by additional P2PKH inputs, and any
+outputs after the token outputs are passed in the unlocking script as <suffix> so the
+covenant can check all outputs against hashOutputs.
+
+Transfer: <suffix> <recipientPKH> <amount> 0
+  output 0 pays amount to the recipient, output 1 returns the rest to the owner unless amount is the whole balance
+
+Burn: <suffix> 1
+  the token is destroyed and no token output is created
+
+Merge: <suffix> <prevouts> <otherParentTx> 2
+  inputs 0 and 1 are tokens of the same owner, output 0 gives the owner both balances.
+  Each input checks <prevouts> against hashPrevouts, takes the outpoint of the other token input
+  and checks <otherParentTx> hashes to its txid, then reads the other balance from that output
+  after checking its locking script is this token. A P2PKH input can't claim a balance, as the
+  output it spends is not a token. The parent may have up to MaxParentInputs inputs, and the
+  token must be output 0 or 1 of it, as it is for every transaction of this package.
+
+As with any push tx token, the contract cannot tell a token input apart from a copy deployed
+with the same code, so the provenance of token inputs must be checked back to the issuing transaction.
+*/
+
+const (
+	// OpTransfer splits the balance between a recipient and the owner
+	OpTransfer = 0
+	// OpBurn destroys the token
+	OpBurn = 1
+	// OpMerge adds the balance of the other token input
+	OpMerge = 2
+
+	// MaxParentInputs is the most inputs the transaction spent by the other token of a merge can have
+	MaxParentInputs = 4
+
+	// StateLength is the length of <ownerPKH> <balance> at the end of the locking script
+	StateLength = 28
+	// Satoshis carried by every token output
+	Satoshis = 1
+)
+
+// NewLockingScript returns the token locking script giving balance to the owner address
+func NewLockingScript(ownerAddress string, balance int64) (*bscript.Script, error) {
+	a, err := bscript.NewAddressFromString(ownerAddress)
+	if err != nil {
+		return nil, err
+	}
+	pkh, err := hex.DecodeString(a.PublicKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	return newLockingScript(pkh, balance)
+}
+
+func newLockingScript(ownerPKH []byte, balance int64) (*bscript.Script, error) {
+	if balance <= 0 {
+		return nil, errors.New("token balance must be positive")
+	}
+	if len(ownerPKH) != 20 {
+		return nil, errors.New("owner public key hash must be 20 bytes")
+	}
+	b := script.NewBuilder(&bscript.Script{})
+	b.Append(script.AppendPushTxVerify)
+
+	// stack: <args...> <op> <sig> <pubKey> <preimage>
+	b.Opcodes(bscript.OpDUP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendSplitStateFromPreimage(s, StateLength)
+	})
+	b.Number(20)
+	b.Opcodes(bscript.OpSPLIT, bscript.OpBIN2NUM)
+
+	// stack: <args...> <op> <sig> <pubKey> <preimage> <prefix> <ownerPKH> <balance>
+	// owner check, same as P2PKH against the public key hash in state
+	b.Opcodes(bscript.Op4, bscript.OpPICK, bscript.OpHASH160, bscript.Op2, bscript.OpPICK, bscript.OpEQUALVERIFY)
+	b.Opcodes(bscript.Op5, bscript.OpROLL, bscript.Op5, bscript.OpROLL, bscript.OpCHECKSIGVERIFY)
+	b.Opcodes(bscript.Op4, bscript.OpROLL)
+
+	// stack: <args...> <preimage> <prefix> <ownerPKH> <balance> <op>
+	b.Opcodes(bscript.OpDUP, bscript.Op0, bscript.OpNUMEQUAL, bscript.OpIF, bscript.OpDROP)
+	b.Append(appendTransfer)
+	b.Opcodes(bscript.OpELSE, bscript.OpDUP, bscript.Op1, bscript.OpNUMEQUAL, bscript.OpIF, bscript.OpDROP)
+	// burn leaves no token outputs, stack: <suffix> <preimage> <prefix> <ownerPKH> <balance>
+	b.Opcodes(bscript.Op2DROP, bscript.OpDROP, bscript.OpSWAP)
+	b.Opcodes(bscript.OpELSE, bscript.Op2, bscript.OpNUMEQUALVERIFY)
+	b.Append(appendMerge)
+	b.Opcodes(bscript.OpENDIF, bscript.OpENDIF)
+
+	// stack: <preimage> <outputs>
+	b.Opcodes(bscript.OpSWAP)
+	b.Append(script.AppendGetHashOutputsFromPreimage)
+	b.Append(script.AppendVerifyOutputs)
+	b.Opcodes(bscript.OpTRUE)
+
+	// state
+	b.Opcodes(bscript.OpRETURN)
+	b.PushData(encodeState(ownerPKH, balance))
+	return b.Script()
+}
+
+// appendTokenOutput assumes <prefix> <ownerPKH> <balance> on top of the stack
+// Leaves the serialized token output
+func appendTokenOutput(s *bscript.Script) (*bscript.Script, error) {
+	return script.NewBuilder(s).
+		Number(8).
+		Opcodes(bscript.OpNUM2BIN, bscript.OpCAT, bscript.OpCAT).
+		PushData(encodeUint64(Satoshis)).
+		Opcodes(bscript.OpSWAP, bscript.OpCAT).
+		Script()
+}
+
+// appendTransfer assumes <suffix> <recipientPKH> <amount> <preimage> <prefix> <ownerPKH> <balance>
+// Leaves <preimage> <outputs>
+func appendTransfer(s *bscript.Script) (*bscript.Script, error) {
+	b := script.NewBuilder(s)
+	// recipientPKH must be 20 bytes, or the state written would be cut short
+	// and the locking script length would take the top byte of the balance from the suffix
+	b.Opcodes(bscript.Op5, bscript.OpPICK, bscript.OpSIZE)
+	b.Number(20)
+	b.Opcodes(bscript.OpEQUALVERIFY, bscript.OpDROP)
+
+	// 0 < amount <= balance
+	b.Opcodes(bscript.Op4, bscript.OpPICK, bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHAN, bscript.OpVERIFY)
+	b.Opcodes(bscript.OpSUB, bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+
+	// stack: <suffix> <recipientPKH> <amount> <preimage> <prefix> <ownerPKH> <change>
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.Op6, bscript.OpPICK, bscript.Op6, bscript.OpPICK)
+	b.Append(appendTokenOutput)
+
+	// change token back to the owner if anything is left
+	b.Opcodes(bscript.OpOVER, bscript.Op0, bscript.OpGREATERTHAN, bscript.OpIF)
+	b.Opcodes(bscript.Op3, bscript.OpPICK, bscript.Op3, bscript.OpPICK, bscript.Op3, bscript.OpPICK)
+	b.Append(appendTokenOutput)
+	b.Opcodes(bscript.OpCAT, bscript.OpENDIF)
+
+	// stack: <suffix> <recipientPKH> <amount> <preimage> <prefix> <ownerPKH> <change> <tokenOutputs>
+	b.Opcodes(bscript.OpTOALTSTACK, bscript.Op2DROP, bscript.OpDROP, bscript.OpNIP, bscript.OpNIP)
+	b.Opcodes(bscript.OpSWAP, bscript.OpFROMALTSTACK, bscript.OpSWAP, bscript.OpCAT)
+	return b.Script()
+}
+
+// appendMerge assumes <suffix> <prevouts> <otherParentTx> <preimage> <prefix> <ownerPKH> <balance>
+// Leaves <preimage> <outputs>
+func appendMerge(s *bscript.Script) (*bscript.Script, error) {
+	b := script.NewBuilder(s)
+	// prevouts are the outpoints of the transaction
+	b.Opcodes(bscript.Op5, bscript.OpPICK, bscript.OpHASH256, bscript.Op4, bscript.OpPICK)
+	b.Append(script.AppendGetHashPrevoutsFromPreimage)
+	b.Opcodes(bscript.OpEQUALVERIFY)
+
+	// the other token input is whichever of inputs 0 and 1 this input is not
+	b.Opcodes(bscript.Op5, bscript.OpPICK).Number(72).Opcodes(bscript.OpSPLIT, bscript.OpDROP)
+	b.Number(36).Opcodes(bscript.OpSPLIT, bscript.Op5, bscript.OpPICK)
+	b.Append(script.AppendGetOutpointFromPreimage)
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.OpOVER, bscript.OpEQUAL)
+	b.Opcodes(bscript.OpIF, bscript.OpDROP, bscript.OpNIP, bscript.OpELSE, bscript.OpEQUALVERIFY, bscript.OpENDIF)
+
+	// stack: <suffix> <prevouts> <otherParentTx> <preimage> <prefix> <ownerPKH> <balance> <otherOutpoint>
+	b.Number(32).Opcodes(bscript.OpSPLIT)
+	b.PushData([]byte{0x00}).Opcodes(bscript.OpCAT, bscript.OpBIN2NUM)
+	b.Opcodes(bscript.Op6, bscript.OpPICK, bscript.OpHASH256, bscript.OpROT, bscript.OpEQUALVERIFY)
+	b.Opcodes(bscript.Op5, bscript.OpPICK, bscript.OpSWAP)
+	b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return script.AppendGetOutputFromTx(s, MaxParentInputs, 1)
+	})
+
+	// stack: <suffix> <prevouts> <otherParentTx> <preimage> <prefix> <ownerPKH> <balance> <otherOutput...>
+	// the other output must be this token's code, whose length varInt fixes the length of its state
+	b.Number(8).Opcodes(bscript.OpSPLIT, bscript.OpNIP)
+	b.Opcodes(bscript.Op3, bscript.OpPICK, bscript.OpSIZE, bscript.OpNIP, bscript.OpSPLIT)
+	b.Opcodes(bscript.OpSWAP, bscript.Op4, bscript.OpPICK, bscript.OpEQUALVERIFY)
+	b.Number(20).Opcodes(bscript.OpSPLIT).Number(8).Opcodes(bscript.OpSPLIT, bscript.OpDROP, bscript.OpBIN2NUM)
+	b.Opcodes(bscript.OpDUP, bscript.Op0, bscript.OpGREATERTHAN, bscript.OpVERIFY)
+	// both tokens must have the same owner
+	b.Opcodes(bscript.OpSWAP, bscript.Op3, bscript.OpPICK, bscript.OpEQUALVERIFY, bscript.OpADD)
+
+	// stack: <suffix> <prevouts> <otherParentTx> <preimage> <prefix> <ownerPKH> <balance>
+	b.Append(appendTokenOutput)
+	b.Opcodes(bscript.Op4, bscript.OpROLL, bscript.OpCAT, bscript.Op2SWAP, bscript.Op2DROP)
+	return b.Script()
+}
+
+// IsToken checks the locking script is a token contract
+func IsToken(s *bscript.Script) bool {
+	template, err := newLockingScript(make([]byte, 20), 1)
+	if err != nil || len(*s) != len(*template) {
+		return false
+	}
+	prefix := len(*template) - StateLength
+	return bscript.NewFromBytes((*s)[:prefix]).EqualsBytes((*template)[:prefix])
+}
+
+// State returns the owner public key hash and balance held in a token locking script
+func State(s *bscript.Script) ([]byte, int64, error) {
+	if !IsToken(s) {
+		return nil, 0, errors.New("locking script is not a token")
+	}
+	state := (*s)[len(*s)-StateLength:]
+	balance := int64(binary.LittleEndian.Uint64(state[20:]))
+	return state[:20], balance, nil
+}
+
+// Balance returns the balance held by a token utxo
+func Balance(utxo *bt.UTXO) (int64, error) {
+	_, balance, err := State(utxo.LockingScript)
+	return balance, err
+}
+
+// NewTokenTransaction spends a P2PKH utxo to issue balance tokens to the owner address, sending the rest to changeAddress
+func NewTokenTransaction(ctx context.Context, utxo *bt.UTXO, ownerAddress string, balance int64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	lockingScript, err := NewLockingScript(ownerAddress, balance)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	return fill(ctx, tx, changeAddress, &Getter{PrivateKey: privateKey, Op: OpTransfer})
+}
+
+// Transfer sends amount tokens to the recipient in output 0, returning the rest to the owner in output 1.
+// Fees are paid by the P2PKH funding utxo with the rest sent to changeAddress
+func Transfer(ctx context.Context, token, funding *bt.UTXO, recipientAddress string, amount int64, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	owner, balance, err := State(token.LockingScript)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 || amount > balance {
+		return nil, errors.New("transfer amount must be positive and no more than the balance")
+	}
+
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(token, funding); err != nil {
+		return nil, err
+	}
+	recipient, err := NewLockingScript(recipientAddress, amount)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: recipient})
+	if amount < balance {
+		change, err := newLockingScript(owner, balance-amount)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: change})
+	}
+	return fill(ctx, tx, changeAddress, &Getter{PrivateKey: privateKey, Op: OpTransfer})
+}
+
+// Burn destroys a token utxo.
+// Fees are paid by the P2PKH funding utxo with the rest sent to changeAddress
+func Burn(ctx context.Context, token, funding *bt.UTXO, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	if !IsToken(token.LockingScript) {
+		return nil, errors.New("locking script is not a token")
+	}
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(token, funding); err != nil {
+		return nil, err
+	}
+	return fill(ctx, tx, changeAddress, &Getter{PrivateKey: privateKey, Op: OpBurn})
+}
+
+// Merge gives the owner the balances of two of their token outputs in output 0. Each token is given by
+// the transaction that created it and the index of its output, as each token input reads the balance of
+// the other from it. Fees are paid by the P2PKH funding utxo with the rest sent to changeAddress
+func Merge(ctx context.Context, first *bt.Tx, firstVout uint32, second *bt.Tx, secondVout uint32, funding *bt.UTXO, changeAddress string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	var utxos []*bt.UTXO
+	var owners [][]byte
+	var balance int64
+	for _, parent := range []struct {
+		tx   *bt.Tx
+		vout uint32
+	}{{first, firstVout}, {second, secondVout}} {
+		if parent.vout > 1 || int(parent.vout) >= len(parent.tx.Outputs) || len(parent.tx.Inputs) > MaxParentInputs {
+			return nil, fmt.Errorf("merged tokens must be output 0 or 1 of a transaction with at most %d inputs", MaxParentInputs)
+		}
+		output := parent.tx.Outputs[parent.vout]
+		owner, b, err := State(output.LockingScript)
+		if err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, &bt.UTXO{TxID: parent.tx.TxIDBytes(), Vout: parent.vout, LockingScript: output.LockingScript, Satoshis: output.Satoshis})
+		owners = append(owners, owner)
+		balance += b
+	}
+	if !bytes.Equal(owners[0], owners[1]) {
+		return nil, errors.New("merged tokens must have the same owner")
+	}
+
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(append(utxos, funding)...); err != nil {
+		return nil, err
+	}
+	lockingScript, err := newLockingScript(owners[0], balance)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: lockingScript})
+	return fill(ctx, tx, changeAddress, &Getter{PrivateKey: privateKey, Op: OpMerge, ParentTxs: []*bt.Tx{first, second}}, 0, 1)
+}
+
+// fill adds the change output and signs every input, finding an nLocktime low s for all the push tx inputs
+func fill(ctx context.Context, tx *bt.Tx, changeAddress string, getter *Getter, pushTxInputs ...uint32) (*bt.Tx, error) {
+	if err := tx.PayToAddress(changeAddress, 0); err != nil {
+		return nil, err
+	}
+	if err := pushtx.FillAllInputsWithChange(ctx, tx, getter, len(tx.Outputs)-1, bt.NewFeeQuote(), pushTxInputs...); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Getter unlocks token inputs for the operation and falls back to the push tx Getter for everything else
+type Getter struct {
+	PrivateKey *bec.PrivateKey
+	Op         int
+	// ParentTxs are the transactions spent by token inputs 0 and 1, needed to merge
+	ParentTxs []*bt.Tx
+}
+
+// Implements the bt.UnlockerGetter interface
+func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	registry := pushtx.NewRegistry(append(pushtx.DefaultRegistrations(), NewRegistration(g.Op, g.ParentTxs...))...)
+	getter := &pushtx.Getter{PrivateKey: g.PrivateKey, Registry: registry}
+	return getter.Unlocker(ctx, lockingScript)
+}
+
+// NewRegistration returns the registration of token inputs spent by the operation, for adding tokens
+// to a pushtx.Registry. The Getter must have a PrivateKey, and merging needs the parent transactions
+// of token inputs 0 and 1
+func NewRegistration(op int, parentTxs ...*bt.Tx) pushtx.Registration {
+	return pushtx.Registration{
+		Name:     "token",
+		Priority: pushtx.PriorityContract,
+		Match:    IsToken,
+		Unlocker: func(ctx context.Context, g *pushtx.Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+			if g.PrivateKey == nil {
+				return nil, errors.New("token needs a private key")
+			}
+			return &Unlocker{PrivateKey: g.PrivateKey, Op: op, ParentTxs: parentTxs}, nil
+		},
+	}
+}
+
+// Unlocker works out the arguments of the operation from the transaction
+// and signs with UnlockPushTx
+type Unlocker struct {
+	PrivateKey *bec.PrivateKey
+	Op         int
+	// ParentTxs are the transactions spent by token inputs 0 and 1, needed to merge
+	ParentTxs []*bt.Tx
+}
+
+// Implements the bt.Unlocker interface
+func (u *Unlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	var args [][]byte
+	switch u.Op {
+	case OpTransfer:
+		if len(tx.Outputs) == 0 {
+			return nil, errors.New("transfer needs a token output")
+		}
+		recipient, amount, err := State(tx.Outputs[0].LockingScript)
+		if err != nil {
+			return nil, err
+		}
+		tokenOutputs := 1
+		if len(tx.Outputs) > 1 && IsToken(tx.Outputs[1].LockingScript) {
+			tokenOutputs = 2
+		}
+		args = [][]byte{suffix(tx, tokenOutputs), recipient, script.EncodeNumber(amount)}
+	case OpBurn:
+		args = [][]byte{suffix(tx, 0)}
+	case OpMerge:
+		if params.InputIdx > 1 || len(u.ParentTxs) != 2 {
+			return nil, errors.New("merge spends tokens in inputs 0 and 1 and needs both their parent transactions")
+		}
+		other := u.ParentTxs[1-params.InputIdx]
+		if other.TxID() != tx.Inputs[1-params.InputIdx].PreviousTxIDStr() {
+			return nil, errors.New("parent transaction is not spent by the other token input")
+		}
+		args = [][]byte{suffix(tx, 1), prevouts(tx), other.Bytes()}
+	default:
+		return nil, errors.New("unknown token operation")
+	}
+	args = append(args, script.EncodeNumber(int64(u.Op)))
+
+	unlocker := &pushtx.UnlockPushTx{PrivateKey: u.PrivateKey, Args: args}
+	return unlocker.UnlockingScript(ctx, tx, params)
+}
+
+// suffix serializes the outputs after the first n
+func suffix(tx *bt.Tx, n int) []byte {
+	var b []byte
+	for _, o := range tx.Outputs[n:] {
+		b = append(b, o.Bytes()...)
+	}
+	return b
+}
+
+// prevouts serializes the outpoints of the inputs as they are hashed into hashPrevouts
+func prevouts(tx *bt.Tx) []byte {
+	var b []byte
+	for _, in := range tx.Inputs {
+		index := make([]byte, 4)
+		binary.LittleEndian.PutUint32(index, in.PreviousTxOutIndex)
+		b = append(append(b, bt.ReverseBytes(in.PreviousTxID())...), index...)
+	}
+	return b
+}
+
+func encodeState(ownerPKH []byte, balance int64) []byte {
+	return append(append([]byte{}, ownerPKH...), encodeUint64(uint64(balance))...)
+}
+
+func encodeUint64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n)
+	return b
+}
@@ -0,0 +1,310 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+	pushtx "github.com/murray-distributed-technologies/go-pushtx/transaction"
+)
+
+func TestTokenLifecycle(t *testing.T) {
+	t.Parallel()
+	ownerKey, owner := testutil.NewKey(t)
+	_, recipient := testutil.NewKey(t)
+
+	issue, err := NewTokenTransaction(context.Background(), testutil.NewFundingUTXO(t, owner, 100000), owner, 1000, owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(issue); err != nil {
+		t.Fatalf("issue failed verification: %v", err)
+	}
+
+	// split 300 to the recipient and 700 back to the owner
+	transfer, err := Transfer(context.Background(), testutil.OutputUTXO(issue, 0), testutil.OutputUTXO(issue, 1), recipient, 300, owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(transfer); err != nil {
+		t.Fatalf("transfer failed verification: %v", err)
+	}
+	expectBalance(t, testutil.OutputUTXO(transfer, 0), 300)
+	expectBalance(t, testutil.OutputUTXO(transfer, 1), 700)
+
+	// send the whole balance, no change token is created
+	all, err := Transfer(context.Background(), testutil.OutputUTXO(transfer, 1), testutil.OutputUTXO(transfer, 2), owner, 700, owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(all); err != nil {
+		t.Fatalf("full transfer failed verification: %v", err)
+	}
+	if IsToken(all.Outputs[1].LockingScript) {
+		t.Error("expected no change token when transferring the whole balance")
+	}
+
+	burn, err := Burn(context.Background(), testutil.OutputUTXO(all, 0), testutil.OutputUTXO(all, 1), owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(burn); err != nil {
+		t.Fatalf("burn failed verification: %v", err)
+	}
+	for _, o := range burn.Outputs {
+		if IsToken(o.LockingScript) {
+			t.Error("expected no token outputs after burn")
+		}
+	}
+}
+
+func TestTransferRejectsInflation(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name          string
+		amount        int64
+		change        int64
+		expectedError bool
+	}{
+		{"conserved", 400, 600, false},
+		{"inflated change", 400, 700, true},
+		{"inflated recipient", 1100, 0, true},
+	}
+	ownerKey, owner := testutil.NewKey(t)
+	_, recipient := testutil.NewKey(t)
+	issue, err := NewTokenTransaction(context.Background(), testutil.NewFundingUTXO(t, owner, 100000), owner, 1000, owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.OutputUTXO(issue, 0), testutil.OutputUTXO(issue, 1)); err != nil {
+				t.Fatal(err)
+			}
+			to, err := NewLockingScript(recipient, test.amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: to})
+			if test.change > 0 {
+				change, err := NewLockingScript(owner, test.change)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: change})
+			}
+			if err = tx.PayToAddress(owner, 90000); err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.FillAllInputs(context.Background(), &Getter{PrivateKey: ownerKey, Op: OpTransfer}); err != nil {
+				t.Fatal(err)
+			}
+			err = testutil.Verify(tx)
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected covenant to reject transfer", test.name)
+			}
+		})
+	}
+}
+
+// TestTransferRejectsShortRecipient sends to a 19 byte public key hash, which without a size
+// check would leave the first byte of the suffix as the top byte of the recipient balance
+func TestTransferRejectsShortRecipient(t *testing.T) {
+	t.Parallel()
+	ownerKey, owner := testutil.NewKey(t)
+	issue, err := NewTokenTransaction(context.Background(), testutil.NewFundingUTXO(t, owner, 100000), owner, 1000, owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(testutil.OutputUTXO(issue, 0), testutil.OutputUTXO(issue, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	recipient := bytes.Repeat([]byte{0x11}, 19)
+	code := (*issue.Outputs[0].LockingScript)[:len(*issue.Outputs[0].LockingScript)-StateLength]
+	inflated := append(append(append(bscript.Script{}, code...), recipient...), encodeUint64(1000)...)
+	inflated = append(inflated, 0x7f)
+	tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: &inflated})
+	if err = tx.PayToAddress(owner, 90000); err != nil {
+		t.Fatal(err)
+	}
+	if _, balance, err := State(&inflated); err != nil || balance <= 1000 {
+		t.Fatalf("expected the output to read as an inflated token, got %d %v", balance, err)
+	}
+
+	// the covenant builds the outputs from <prefix> <recipientPKH> <amount> followed by the suffix
+	suffix := append([]byte{0x7f}, tx.Outputs[1].Bytes()...)
+	unlocker := &pushtx.UnlockPushTx{
+		PrivateKey: ownerKey,
+		Args:       [][]byte{suffix, recipient, script.EncodeNumber(1000), script.EncodeNumber(OpTransfer)},
+	}
+	if err = tx.FillInput(context.Background(), unlocker, bt.UnlockerParams{InputIdx: 0}); err != nil {
+		t.Fatal(err)
+	}
+	funding, err := (&pushtx.Getter{PrivateKey: ownerKey}).Unlocker(context.Background(), tx.Inputs[1].PreviousTxScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.FillInput(context.Background(), funding, bt.UnlockerParams{InputIdx: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err == nil {
+		t.Error("expected transfer to a 19 byte public key hash to fail")
+	}
+}
+
+func TestTransferRequiresOwner(t *testing.T) {
+	t.Parallel()
+	ownerKey, owner := testutil.NewKey(t)
+	thiefKey, thief := testutil.NewKey(t)
+	issue, err := NewTokenTransaction(context.Background(), testutil.NewFundingUTXO(t, owner, 100000), owner, 1000, owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := Transfer(context.Background(), testutil.OutputUTXO(issue, 0), testutil.NewFundingUTXO(t, thief, 100000), thief, 1000, thief, thiefKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err == nil {
+		t.Error("expected transfer signed by someone other than the owner to fail")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+	ownerKey, owner := testutil.NewKey(t)
+	_, other := testutil.NewKey(t)
+	issue := func(address string, balance int64) *bt.Tx {
+		tx, err := NewTokenTransaction(context.Background(), testutil.NewFundingUTXO(t, owner, 100000), address, balance, owner, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	first, second, third := issue(owner, 1000), issue(owner, 500), issue(owner, 200)
+
+	merged, err := Merge(context.Background(), first, 0, second, 0, testutil.OutputUTXO(first, 1), owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(merged); err != nil {
+		t.Fatalf("merge failed verification: %v", err)
+	}
+	expectBalance(t, testutil.OutputUTXO(merged, 0), 1500)
+
+	// a merged token is merged again, reading its balance from a parent spending two tokens
+	again, err := Merge(context.Background(), third, 0, merged, 0, testutil.OutputUTXO(second, 1), owner, ownerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(again); err != nil {
+		t.Fatalf("second merge failed verification: %v", err)
+	}
+	expectBalance(t, testutil.OutputUTXO(again, 0), 1700)
+
+	if _, err = Merge(context.Background(), first, 0, issue(other, 500), 0, testutil.OutputUTXO(third, 1), owner, ownerKey); err == nil {
+		t.Error("expected merging tokens of different owners to fail")
+	}
+}
+
+// TestMergeRejectsFakeToken merges a token with an input that is not a token, claiming the balance of a
+// token output of the transaction it spends or of another transaction
+func TestMergeRejectsFakeToken(t *testing.T) {
+	t.Parallel()
+	ownerKey, owner := testutil.NewKey(t)
+	issue := func(balance int64) *bt.Tx {
+		tx, err := NewTokenTransaction(context.Background(), testutil.NewFundingUTXO(t, owner, 100000), owner, balance, owner, ownerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	token, claimed := issue(1000), issue(500)
+
+	var tests = []struct {
+		name          string
+		input         *bt.UTXO
+		parent        *bt.Tx
+		expectedError bool
+	}{
+		{"token", testutil.OutputUTXO(claimed, 0), claimed, false},
+		{"p2pkh claiming its parent's token", testutil.OutputUTXO(claimed, 1), claimed, true},
+		{"p2pkh claiming another transaction's token", testutil.NewFundingUTXO(t, owner, 100000), claimed, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.OutputUTXO(token, 0), test.input); err != nil {
+				t.Fatal(err)
+			}
+			merged, err := NewLockingScript(owner, 1500)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.AddOutput(&bt.Output{Satoshis: Satoshis, LockingScript: merged})
+			if err = tx.PayToAddress(owner, 10000); err != nil {
+				t.Fatal(err)
+			}
+			if err = pushtx.LowSLockTime(context.Background(), tx, []uint32{0, 1}, sighash.AllForkID); err != nil {
+				t.Fatal(err)
+			}
+
+			// the token input is unlocked as a merge whatever input 1 is
+			unlocker := &pushtx.UnlockPushTx{
+				PrivateKey: ownerKey,
+				Args:       [][]byte{suffix(tx, 1), prevouts(tx), test.parent.Bytes(), script.EncodeNumber(OpMerge)},
+			}
+			if err = tx.FillInput(context.Background(), unlocker, bt.UnlockerParams{InputIdx: 0}); err != nil {
+				t.Fatal(err)
+			}
+			getter := &Getter{PrivateKey: ownerKey, Op: OpMerge, ParentTxs: []*bt.Tx{token, test.parent}}
+			other, err := getter.Unlocker(context.Background(), tx.Inputs[1].PreviousTxScript)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tx.FillInput(context.Background(), other, bt.UnlockerParams{InputIdx: 1}); err != nil {
+				t.Fatal(err)
+			}
+
+			err = testutil.Verify(tx)
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected covenant to reject merge", test.name)
+			}
+		})
+	}
+}
+
+func expectBalance(t *testing.T, utxo *bt.UTXO, expected int64) {
+	t.Helper()
+	balance, err := Balance(utxo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != expected {
+		t.Errorf("expected balance %d, got %d", expected, balance)
+	}
+}
+
+// a transfer or merge unlocks with 7 items and a burn with 5, which leaves 2 more when given 7
+func TestAnalyze(t *testing.T) {
+	t.Parallel()
+	_, owner := testutil.NewKey(t)
+	lockingScript, err := NewLockingScript(owner, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Analyze(t, lockingScript, 7, 1, 3)
+}
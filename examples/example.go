@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/libsv/go-bk/wif"
@@ -11,6 +12,7 @@ import (
 )
 
 func main() {
+	ctx := context.Background()
 	childPrivKey, _ := wif.DecodeWIF("<Key 1>")
 	parentPrivKey, _ := wif.DecodeWIF("<Key 2>")
 	pubKey := childPrivKey.PrivKey.PubKey()
@@ -22,7 +24,7 @@ func main() {
 	vOut = 0
 	amount := uint64(3000)
 
-	o, _ := woc.GetTransactionOutput(txId, int(vOut))
+	o, _ := woc.GetTransactionOutput(ctx, txId, int(vOut))
 
 	sats = uint64(o.Value * 100000000)
 	scriptPubKey, err := bscript.NewFromHexString(o.ScriptPubKey.Hex)
@@ -36,10 +38,11 @@ func main() {
 		PreviousTxOutIndex: vOut,
 	}
 
-	rawTx, err := pushtx.NewOpPushTransaction(input, txId, address.AddressString, address.AddressString, parentPrivKey.PrivKey, amount)
+	result, err := pushtx.BuildOpPushTransaction(ctx, input, txId, address.AddressString, address.AddressString, parentPrivKey.PrivKey, amount)
 	if err != nil {
 		fmt.Println(err)
+		return
 	}
-	fmt.Println(rawTx)
+	fmt.Println(result.Tx.String())
 
 }
@@ -0,0 +1,87 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"reflect"
+	"testing"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/bscript/interpreter"
+	"github.com/murray-distributed-technologies/go-pushtx/analyzer"
+)
+
+// NewKey returns a new private key and its mainnet address
+func NewKey(t *testing.T) (*bec.PrivateKey, string) {
+	t.Helper()
+	privateKey, err := bec.NewPrivateKey(bec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := bscript.NewAddressFromPublicKey(privateKey.PubKey(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privateKey, address.AddressString
+}
+
+// NewFundingUTXO returns a P2PKH utxo paying satoshis to the address from a random txid
+func NewFundingUTXO(t *testing.T, address string, satoshis uint64) *bt.UTXO {
+	t.Helper()
+	lockingScript, err := bscript.NewP2PKHFromAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txID := make([]byte, 32)
+	if _, err = rand.Read(txID); err != nil {
+		t.Fatal(err)
+	}
+	return &bt.UTXO{
+		TxID:          txID,
+		Vout:          0,
+		LockingScript: lockingScript,
+		Satoshis:      satoshis,
+	}
+}
+
+// OutputUTXO returns output vout of the transaction as a utxo
+func OutputUTXO(tx *bt.Tx, vout uint32) *bt.UTXO {
+	return &bt.UTXO{
+		TxID:          tx.TxIDBytes(),
+		Vout:          vout,
+		LockingScript: tx.Outputs[vout].LockingScript,
+		Satoshis:      tx.Outputs[vout].Satoshis,
+	}
+}
+
+// Verify runs every input of the transaction through the script interpreter
+func Verify(tx *bt.Tx) error {
+	for i, in := range tx.Inputs {
+		prevOutput := &bt.Output{Satoshis: in.PreviousTxSatoshis, LockingScript: in.PreviousTxScript}
+		if err := interpreter.NewEngine().Execute(
+			interpreter.WithTx(tx, i, prevOutput),
+			interpreter.WithForkID(),
+			interpreter.WithAfterGenesis(),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Analyze checks the locking script with the number of unlocking script items statically,
+// failing if any path has a problem or the main stack can be left at other heights than expected
+func Analyze(t *testing.T, lockingScript *bscript.Script, inputs int, expectedHeights ...int) {
+	t.Helper()
+	r, err := analyzer.Analyze(lockingScript, inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Err(); err != nil {
+		t.Errorf("analyzing the locking script failed: %v", err)
+	}
+	if !reflect.DeepEqual(r.FinalHeights, expectedHeights) {
+		t.Errorf("expected final heights %v, got %v", expectedHeights, r.FinalHeights)
+	}
+}
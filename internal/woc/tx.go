@@ -2,19 +2,46 @@ package woc
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
+	"math"
 
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
 	"github.com/mrz1836/go-whatsonchain"
 )
 
-func GetTransactionOutput(txid string, vout int) (*whatsonchain.VoutInfo, error) {
+func GetTransactionOutput(ctx context.Context, txid string, vout int) (*whatsonchain.VoutInfo, error) {
 	client := whatsonchain.NewClient(whatsonchain.NetworkMain, nil, nil)
-	txInfo, err := client.GetTxByHash(context.Background(), txid)
+	txInfo, err := client.GetTxByHash(ctx, txid)
 	if err != nil {
 		return nil, err
 	}
-	if len(txInfo.Vout) < vout {
+	if vout < 0 || len(txInfo.Vout) <= vout {
 		return nil, errors.New("transaction didn't have enough outputs")
 	}
 	return &txInfo.Vout[vout], nil
 }
+
+// GetUTXO returns output vout of the transaction as a utxo
+func GetUTXO(ctx context.Context, txid string, vout uint32) (*bt.UTXO, error) {
+	o, err := GetTransactionOutput(ctx, txid, int(vout))
+	if err != nil {
+		return nil, err
+	}
+	txID, err := hex.DecodeString(txid)
+	if err != nil {
+		return nil, err
+	}
+	lockingScript, err := bscript.NewFromHexString(o.ScriptPubKey.Hex)
+	if err != nil {
+		return nil, err
+	}
+	return &bt.UTXO{
+		TxID:          txID,
+		Vout:          vout,
+		LockingScript: lockingScript,
+		// values are given in BSV, round so float error doesn't lose a satoshi
+		Satoshis: uint64(math.Round(o.Value * 1e8)),
+	}, nil
+}
@@ -0,0 +1,303 @@
+// Package optimizer shortens scripts with peephole rewrites, replacing a few opcodes at a
+// time with fewer bytes that leave the same stacks, and checks the result by running both
+// scripts in the interpreter.
+//
+// Rewrites keep the result of every execution that doesn't run out of stack items, so
+// a script should pass the analyzer before it is optimized. A leading push tx script is
+// left as it is, so the script is still recognized by script.IsOpPushTx, and everything
+// from the first OP_RETURN on is kept as it is, as it may be data rather than opcodes.
+package optimizer
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+// maxFoldedNumber bounds the numbers folded, so results are never too long to be numbers
+const maxFoldedNumber = 1<<31 - 1
+
+// op is an opcode and the data it pushes
+type op struct {
+	code byte
+	data []byte
+}
+
+// Optimize returns the script with every rewrite applied until none apply
+func Optimize(s *bscript.Script) (*bscript.Script, error) {
+	b := []byte(*s)
+	start := 0
+	if _, n, ok := script.PushTxVerifyTemplate.MatchPrefix(s); ok {
+		start = n
+	}
+	var ops []op
+	end := start
+	for end < len(b) && b[end] != bscript.OpRETURN {
+		o, next, err := asm.DecodeOp(b, end)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, op{code: o.Opcode, data: o.Data})
+		end = next
+	}
+
+	for changed := true; changed; {
+		ops, changed = rewrite(ops)
+	}
+
+	optimized := append(bscript.Script{}, b[:start]...)
+	optimized = append(optimized, encode(ops)...)
+	optimized = append(optimized, b[end:]...)
+	return &optimized, nil
+}
+
+// rewrite applies the rules once from the start of the opcodes, reporting whether any applied
+func rewrite(ops []op) ([]op, bool) {
+	var out []op
+	changed := false
+	for i := 0; i < len(ops); {
+		if replacement, n, ok := match(ops[i:]); ok && len(encode(replacement)) < len(encode(ops[i:i+n])) {
+			out = append(out, replacement...)
+			i += n
+			changed = true
+			continue
+		}
+		out = append(out, ops[i])
+		i++
+	}
+	return out, changed
+}
+
+// sequences are rewrites of fixed opcodes
+var sequences = []struct {
+	from, to []byte
+}{
+	{[]byte{bscript.Op0, bscript.OpPICK}, []byte{bscript.OpDUP}},
+	{[]byte{bscript.Op1, bscript.OpPICK}, []byte{bscript.OpOVER}},
+	{[]byte{bscript.Op0, bscript.OpROLL}, nil},
+	{[]byte{bscript.Op1, bscript.OpROLL}, []byte{bscript.OpSWAP}},
+	{[]byte{bscript.Op2, bscript.OpROLL}, []byte{bscript.OpROT}},
+	{[]byte{bscript.OpSWAP, bscript.OpSWAP}, nil},
+	{[]byte{bscript.Op2SWAP, bscript.Op2SWAP}, nil},
+	{[]byte{bscript.OpROT, bscript.OpROT, bscript.OpROT}, nil},
+	{[]byte{bscript.OpDUP, bscript.OpDROP}, nil},
+	{[]byte{bscript.OpTOALTSTACK, bscript.OpFROMALTSTACK}, nil},
+	{[]byte{bscript.OpDROP, bscript.OpDROP}, []byte{bscript.Op2DROP}},
+	{[]byte{bscript.OpSWAP, bscript.OpDROP}, []byte{bscript.OpNIP}},
+	{[]byte{bscript.OpOVER, bscript.OpOVER}, []byte{bscript.Op2DUP}},
+	{[]byte{bscript.OpDUP, bscript.OpDUP, bscript.OpDUP}, []byte{bscript.OpDUP, bscript.Op2DUP}},
+	{[]byte{bscript.OpEQUAL, bscript.OpVERIFY}, []byte{bscript.OpEQUALVERIFY}},
+	{[]byte{bscript.OpNUMEQUAL, bscript.OpVERIFY}, []byte{bscript.OpNUMEQUALVERIFY}},
+	{[]byte{bscript.OpCHECKSIG, bscript.OpVERIFY}, []byte{bscript.OpCHECKSIGVERIFY}},
+	{[]byte{bscript.OpCHECKMULTISIG, bscript.OpVERIFY}, []byte{bscript.OpCHECKMULTISIGVERIFY}},
+	{[]byte{bscript.Op1, bscript.OpADD}, []byte{bscript.Op1ADD}},
+	{[]byte{bscript.Op1, bscript.OpSUB}, []byte{bscript.Op1SUB}},
+}
+
+// commutative are the opcodes whose two operands can be swapped
+var commutative = map[byte]bool{
+	bscript.OpADD: true, bscript.OpMUL: true, bscript.OpMIN: true, bscript.OpMAX: true,
+	bscript.OpBOOLAND: true, bscript.OpBOOLOR: true, bscript.OpNUMEQUAL: true, bscript.OpNUMEQUALVERIFY: true,
+	bscript.OpNUMNOTEQUAL: true, bscript.OpEQUAL: true, bscript.OpEQUALVERIFY: true,
+	bscript.OpAND: true, bscript.OpOR: true, bscript.OpXOR: true,
+}
+
+// match returns the replacement of the opcodes at the start of ops and how many it replaces
+func match(ops []op) ([]op, int, bool) {
+	for _, seq := range sequences {
+		if hasCodes(ops, seq.from) {
+			to := make([]op, len(seq.to))
+			for i, code := range seq.to {
+				to[i] = op{code: code}
+			}
+			return to, len(seq.from), true
+		}
+	}
+	if len(ops) >= 2 && ops[0].code == bscript.OpSWAP && commutative[ops[1].code] {
+		return ops[1:2], 2, true
+	}
+	return fold(ops)
+}
+
+// fold replaces constants and the opcode taking them with the result
+func fold(ops []op) ([]op, int, bool) {
+	if len(ops) < 2 {
+		return nil, 0, false
+	}
+	a, ok := ops[0].push()
+	if !ok {
+		return nil, 0, false
+	}
+	switch ops[1].code {
+	case bscript.OpDROP:
+		return nil, 2, true
+	case bscript.OpBIN2NUM:
+		if n, err := script.DecodeNumber(a); err == nil {
+			return pushNumber(n), 2, true
+		}
+		return nil, 0, false
+	}
+	if n, ok := number(a); ok {
+		if result, ok := unaryOp(ops[1].code, n); ok {
+			return pushNumber(result), 2, true
+		}
+	}
+
+	if len(ops) < 3 {
+		return nil, 0, false
+	}
+	b, ok := ops[1].push()
+	if !ok {
+		return nil, 0, false
+	}
+	if ops[2].code == bscript.OpCAT {
+		return pushData(append(append([]byte{}, a...), b...)), 3, true
+	}
+	n, okA := number(a)
+	m, okB := number(b)
+	if !okA || !okB {
+		return nil, 0, false
+	}
+	if result, ok := binaryOp(ops[2].code, n, m); ok {
+		return pushNumber(result), 3, true
+	}
+	return nil, 0, false
+}
+
+// unaryOp returns the result of an opcode taking one number
+func unaryOp(code byte, n int64) (int64, bool) {
+	switch code {
+	case bscript.Op1ADD:
+		return n + 1, true
+	case bscript.Op1SUB:
+		return n - 1, true
+	case bscript.OpNEGATE:
+		return -n, true
+	case bscript.OpABS:
+		if n < 0 {
+			return -n, true
+		}
+		return n, true
+	case bscript.OpNOT:
+		if n == 0 {
+			return 1, true
+		}
+		return 0, true
+	case bscript.Op0NOTEQUAL:
+		if n == 0 {
+			return 0, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+// binaryOp returns the result of an opcode taking two numbers
+func binaryOp(code byte, n, m int64) (int64, bool) {
+	switch code {
+	case bscript.OpADD:
+		return n + m, true
+	case bscript.OpSUB:
+		return n - m, true
+	case bscript.OpMUL:
+		return n * m, true
+	case bscript.OpDIV:
+		// division by zero fails the script, which is left to happen
+		if m == 0 {
+			return 0, false
+		}
+		return n / m, true
+	case bscript.OpMOD:
+		if m == 0 {
+			return 0, false
+		}
+		return n % m, true
+	case bscript.OpMIN:
+		if m < n {
+			return m, true
+		}
+		return n, true
+	case bscript.OpMAX:
+		if m > n {
+			return m, true
+		}
+		return n, true
+	}
+	return 0, false
+}
+
+// number returns the number of minimally encoded bytes small enough to fold
+func number(b []byte) (int64, bool) {
+	n, err := script.DecodeNumber(b)
+	if err != nil || n > maxFoldedNumber || n < -maxFoldedNumber || !bytes.Equal(script.EncodeNumber(n), b) {
+		return 0, false
+	}
+	return n, true
+}
+
+func pushNumber(n int64) []op {
+	return pushData(script.EncodeNumber(n))
+}
+
+// pushData returns the smallest push of the data, as AppendPushDataMinimal pushes it
+func pushData(data []byte) []op {
+	s, err := script.AppendPushDataMinimal(&bscript.Script{}, data)
+	if err != nil {
+		return nil
+	}
+	o, _, err := asm.DecodeOp(*s, 0)
+	if err != nil {
+		return nil
+	}
+	return []op{{code: o.Opcode, data: o.Data}}
+}
+
+// push returns the data pushed by a push or a small integer opcode
+func (o op) push() ([]byte, bool) {
+	switch {
+	case o.code <= bscript.OpPUSHDATA4:
+		return o.data, true
+	case o.code == bscript.Op1NEGATE:
+		return []byte{0x81}, true
+	case o.code >= bscript.Op1 && o.code <= bscript.Op16:
+		return []byte{o.code - bscript.Op1 + 1}, true
+	}
+	return nil, false
+}
+
+func hasCodes(ops []op, codes []byte) bool {
+	if len(ops) < len(codes) {
+		return false
+	}
+	for i, code := range codes {
+		if ops[i].code != code {
+			return false
+		}
+	}
+	return true
+}
+
+// encode returns the script bytes of the opcodes, keeping the push opcode of each push
+func encode(ops []op) []byte {
+	var b []byte
+	for _, o := range ops {
+		b = append(b, o.code)
+		switch o.code {
+		case bscript.OpPUSHDATA1:
+			b = append(b, byte(len(o.data)))
+		case bscript.OpPUSHDATA2:
+			var n [2]byte
+			binary.LittleEndian.PutUint16(n[:], uint16(len(o.data)))
+			b = append(b, n[:]...)
+		case bscript.OpPUSHDATA4:
+			var n [4]byte
+			binary.LittleEndian.PutUint32(n[:], uint32(len(o.data)))
+			b = append(b, n[:]...)
+		}
+		b = append(b, o.data...)
+	}
+	return b
+}
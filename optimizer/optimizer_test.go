@@ -0,0 +1,228 @@
+package optimizer
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/analyzer"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+// values are the stack items unlocking scripts are made of, numbers of each size and sign, and data
+var values = [][]byte{
+	{}, {0x01}, {0x02}, {0x81}, {0x7f}, {0xff, 0x00}, {0x00, 0x80}, {0xff, 0xff, 0xff, 0x7f},
+	{0xde, 0xad, 0xbe, 0xef, 0x01}, bytes.Repeat([]byte{0xab}, 32),
+}
+
+// unlockingScripts returns unlocking scripts pushing n items chosen at random from items
+func unlockingScripts(t *testing.T, random *rand.Rand, n int, items [][]byte) []*bscript.Script {
+	t.Helper()
+	var scripts []*bscript.Script
+	for i := 0; i < 50; i++ {
+		s := &bscript.Script{}
+		for j := 0; j < n; j++ {
+			if err := s.AppendPushData(items[random.Intn(len(items))]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		scripts = append(scripts, s)
+	}
+	return scripts
+}
+
+func TestOptimize(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name     string
+		script   string
+		expected string
+		inputs   int
+	}{
+		{"pick top", "OP_0 OP_PICK OP_HASH256", "OP_DUP OP_HASH256", 1},
+		{"pick second", "OP_1 OP_PICK OP_CAT", "OP_OVER OP_CAT", 2},
+		{"roll", "OP_0 OP_ROLL OP_1 OP_ROLL OP_2 OP_ROLL OP_CAT", "OP_SWAP OP_ROT OP_CAT", 3},
+		{"swap swap", "OP_SWAP OP_SWAP OP_CAT", "OP_CAT", 2},
+		{"rot rot rot", "OP_ROT OP_ROT OP_ROT OP_CAT", "OP_CAT", 3},
+		{"dup drop", "OP_DUP OP_DROP OP_TOALTSTACK OP_FROMALTSTACK OP_SIZE", "OP_SIZE", 1},
+		{"drop drop", "OP_DROP OP_DROP", "OP_2DROP", 3},
+		{"swap drop", "OP_SWAP OP_DROP", "OP_NIP", 2},
+		{"over over", "OP_OVER OP_OVER", "OP_2DUP", 2},
+		{"dup dup dup", "OP_DUP OP_DUP OP_DUP", "OP_DUP OP_2DUP", 1},
+		{"verify", "OP_EQUAL OP_VERIFY OP_NUMEQUAL OP_VERIFY", "OP_EQUALVERIFY OP_NUMEQUALVERIFY", 4},
+		{"increment", "OP_1 OP_ADD OP_1 OP_SUB", "OP_1ADD OP_1SUB", 1},
+		{"commutative", "OP_SWAP OP_ADD OP_SWAP OP_SUB", "OP_ADD OP_SWAP OP_SUB", 3},
+		{"fold add", "OP_16 OP_16 OP_ADD OP_ADD", "20 OP_ADD", 1},
+		{"fold nested", "03 04 OP_MUL 02 OP_SUB 0a OP_MUL OP_ADD", "64 OP_ADD", 1},
+		{"fold only if shorter", "OP_10 OP_NEGATE OP_ADD", "OP_10 OP_NEGATE OP_ADD", 1},
+		{"fold division", "0d OP_4 OP_DIV 0d OP_4 OP_MOD OP_CAT", "OP_3 OP_1 OP_CAT", 0},
+		{"division by zero", "OP_1 OP_0 OP_DIV", "OP_1 OP_0 OP_DIV", 0},
+		{"fold cat", "aabb ccdd OP_CAT OP_SWAP", "aabbccdd OP_SWAP", 1},
+		{"fold bin2num", "68 OP_BIN2NUM OP_SPLIT", "68 OP_SPLIT", 1},
+		{"non-minimal bin2num", "0100 OP_BIN2NUM OP_SPLIT", "OP_1 OP_SPLIT", 1},
+		{"push drop", "aabb OP_DROP OP_DUP", "OP_DUP", 1},
+		{"non-minimal number", "0100 OP_1ADD", "0100 OP_1ADD", 0},
+		{"no change", "OP_DUP OP_HASH160 00112233445566778899aabbccddeeff00112233 OP_EQUALVERIFY OP_CHECKSIG",
+			"OP_DUP OP_HASH160 00112233445566778899aabbccddeeff00112233 OP_EQUALVERIFY OP_CHECKSIG", 2},
+		{"branches", "OP_IF OP_DUP OP_DROP OP_ELSE OP_SWAP OP_SWAP OP_ENDIF", "OP_IF OP_ELSE OP_ENDIF", 3},
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		s, err := asm.Assemble(test.script, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := asm.Assemble(test.expected, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimized, err := Optimize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(*optimized, *expected) {
+			text, _ := asm.Disassemble(optimized)
+			t.Errorf("%s failed: expected %s, got %s", test.name, test.expected, text)
+			continue
+		}
+		if err = Verify(s, optimized, unlockingScripts(t, random, test.inputs, values)...); err != nil {
+			t.Errorf("%s failed: %v", test.name, err)
+		}
+	}
+}
+
+func TestOptimizeKeepsPushTxAndData(t *testing.T) {
+	t.Parallel()
+	_, address := testutil.NewKey(t)
+	s, err := script.AppendPushTxVerify(&bscript.Script{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTx := len(*s)
+	*s = append(*s, bscript.OpSWAP, bscript.OpSWAP, bscript.OpDROP)
+	if s, err = script.AppendP2PKH(s, address); err != nil {
+		t.Fatal(err)
+	}
+	// data after OP_RETURN which isn't a valid script
+	data := []byte{bscript.OpRETURN, bscript.OpPUSHDATA1, 0xff}
+	*s = append(*s, data...)
+
+	optimized, err := Optimize(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !script.IsOpPushTx(optimized) {
+		t.Error("expected the optimized script to start with push tx")
+	}
+	if len(*optimized) != len(*s)-2 {
+		t.Errorf("expected OP_SWAP OP_SWAP to be removed, got %x", *optimized)
+	}
+	if !bytes.Equal((*optimized)[:pushTx], (*s)[:pushTx]) || !bytes.HasSuffix(*optimized, data) {
+		t.Errorf("expected push tx and data to be unchanged, got %x", *optimized)
+	}
+}
+
+// TestOptimizeTemplates checks optimizing the script package templates never changes their results
+func TestOptimizeTemplates(t *testing.T) {
+	t.Parallel()
+	_, address := testutil.NewKey(t)
+	_, refundAddress := testutil.NewKey(t)
+
+	// preimages with a scriptCode length of each varInt size, which needn't be minimally encoded
+	preimages := [][]byte{bytes.Repeat([]byte{0x11}, 300)}
+	for _, varInt := range [][]byte{{0x05}, {0xfd, 0x05, 0x00}, {0xfe, 0x05, 0, 0, 0}, {0xff, 0x05, 0, 0, 0, 0, 0, 0, 0}} {
+		preimage := append(bytes.Repeat([]byte{0x22}, script.ScriptCodeOffset), varInt...)
+		preimage = append(preimage, bytes.Repeat([]byte{bscript.OpNOP}, 5)...)
+		preimages = append(preimages, append(preimage, bytes.Repeat([]byte{0x33}, script.PreimageSuffixLength)...))
+	}
+	items := append(append([][]byte{}, values...), preimages...)
+
+	var tests = []struct {
+		name   string
+		append func(s *bscript.Script) (*bscript.Script, error)
+		inputs int
+	}{
+		{"get locking script", script.AppendGetLockingScriptFromPreimage, 1},
+		{"get nLocktime", script.AppendGetNLocktimeFromPreimage, 1},
+		{"split state", func(s *bscript.Script) (*bscript.Script, error) { return script.AppendSplitStateFromPreimage(s, 8) }, 1},
+		{"build p2pkh output", script.AppendBuildP2PKHOutput, 2},
+		{"check lock time", func(s *bscript.Script) (*bscript.Script, error) { return script.AppendCheckLockTime(s, 800000) }, 1},
+		{"p2pkh or timeout", func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendP2PKHOrTimeout(s, address, refundAddress, 800000)
+		}, 4},
+		{"rabin verify", func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendRabinVerify(s, big.NewInt(0x7fffffff))
+		}, 3},
+	}
+	random := rand.New(rand.NewSource(2))
+	for _, test := range tests {
+		s, err := test.append(&bscript.Script{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		optimized, err := Optimize(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*optimized) > len(*s) {
+			t.Errorf("%s failed: optimized script is longer", test.name)
+		}
+		if err = analyzer.Check(optimized, test.inputs); err != nil {
+			t.Errorf("%s failed: %v", test.name, err)
+		}
+		unlocking := unlockingScripts(t, random, test.inputs, items)
+		for _, preimage := range preimages {
+			u := &bscript.Script{}
+			for i := 0; i < test.inputs; i++ {
+				if err = u.AppendPushData(preimage); err != nil {
+					t.Fatal(err)
+				}
+			}
+			unlocking = append(unlocking, u)
+		}
+		if err = Verify(s, optimized, unlocking...); err != nil {
+			t.Errorf("%s failed: %v", test.name, err)
+		}
+	}
+
+	// the varInt branches of AppendGetLockingScriptFromPreimage are shortened
+	s, err := script.AppendGetLockingScriptFromPreimage(&bscript.Script{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimized, err := Optimize(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*optimized) != len(*s)-2 {
+		t.Errorf("expected OP_BIN2NUM of the offset and an OP_DUP to be removed, got %d bytes from %d", len(*optimized), len(*s))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+	random := rand.New(rand.NewSource(3))
+	for _, pair := range [][2]string{
+		{"OP_ADD", "OP_SUB"},
+		{"OP_SWAP OP_SUB", "OP_SUB"},
+		{"OP_DROP", "OP_NIP"},
+		{"OP_CAT", "OP_SWAP OP_CAT"},
+		{"OP_VERIFY", "OP_DROP"},
+	} {
+		a, err := asm.Assemble(pair[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := asm.Assemble(pair[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = Verify(a, b, unlockingScripts(t, random, 2, values)...); err == nil {
+			t.Errorf("expected %s and %s to differ", pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package optimizer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/bscript/interpreter"
+	"github.com/libsv/go-bt/v2/bscript/interpreter/debug"
+)
+
+// verifyTxID is the txid of the output spent when verifying, as signature checks need a transaction
+const verifyTxID = "0000000000000000000000000000000000000000000000000000000000000001"
+
+// Verify runs the original and optimized locking scripts with each unlocking script, and returns
+// an error unless both succeed or both fail, and those which succeed leave the same stack.
+// Signatures are checked against a transaction spending each script, so only invalid
+// signatures give the same result for both
+func Verify(original, optimized *bscript.Script, unlocking ...*bscript.Script) error {
+	for i, u := range unlocking {
+		want, wantErr := execute(original, u)
+		got, gotErr := execute(optimized, u)
+		if (wantErr == nil) != (gotErr == nil) {
+			return fmt.Errorf("unlocking script %d: original gives %v, optimized gives %v", i, wantErr, gotErr)
+		}
+		if wantErr == nil && !reflect.DeepEqual(want, got) {
+			return fmt.Errorf("unlocking script %d: original leaves %x, optimized leaves %x", i, want, got)
+		}
+	}
+	return nil
+}
+
+// execute runs the locking script with OP_TRUE appended, so a script which doesn't fail
+// succeeds whatever it leaves, and returns the stack it leaves. The alt stack is always
+// emptied at the end of a script, so only the main stack is returned
+func execute(locking, unlocking *bscript.Script) ([][]byte, error) {
+	withTrue := append(append(bscript.Script{}, *locking...), bscript.OpTRUE)
+	tx := bt.NewTx()
+	if err := tx.From(verifyTxID, 0, hex.EncodeToString(withTrue), 1000); err != nil {
+		return nil, err
+	}
+	tx.Inputs[0].UnlockingScript = unlocking
+	tx.AddOutput(&bt.Output{Satoshis: 1000, LockingScript: &bscript.Script{bscript.OpTRUE}})
+
+	var left [][]byte
+	debugger := debug.NewDebugger()
+	debugger.AttachAfterExecute(func(state *interpreter.State) {
+		left = state.DataStack
+	})
+	err := interpreter.NewEngine().Execute(
+		interpreter.WithTx(tx, 0, &bt.Output{Satoshis: 1000, LockingScript: &withTrue}),
+		interpreter.WithForkID(),
+		interpreter.WithAfterGenesis(),
+		interpreter.WithDebugger(debugger),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return left, nil
+}
@@ -1,13 +1,16 @@
 package preimage
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"math"
 	"strconv"
 
 	"github.com/libsv/go-bk/crypto"
 	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
 )
 
 type byteArray []byte
@@ -110,14 +113,44 @@ func (b *byteArray) ToBytes() []byte {
 	return []byte(*b)
 }
 
+// ErrNoLowSLockTime is returned when no nLocktime up to MaxLockTime gives a low s preimage
+var ErrNoLowSLockTime = errors.New("no low s nLocktime before the end of the nLocktime range")
+
+// MaxLockTime returns the highest nLocktime the low s search may reach counting up from n.
+// Block heights stay below script.LockTimeThreshold and timestamps never wrap past MAX_UINT,
+// so malleating never changes what the nLocktime means
+func MaxLockTime(n uint32) uint32 {
+	if n < script.LockTimeThreshold {
+		return script.LockTimeThreshold - 1
+	}
+	return math.MaxUint32
+}
+
 // This library uses Optimized OP_PUSH_TX which requires low s value in signature
 // This check will check the s value when hashing preimage and return malleated transaction if low s
 // Malleates nLocktime until the most significant byte of Hash(preimage) is lower than 7e
-// Note: This means this library will fail for any transactions that have nSequence set under MAX_UINT
+// Counts up from the current nLocktime so a time locked transaction stays above its threshold,
+// returning ErrNoLowSLockTime if MaxLockTime is reached first
+// Note: For transactions with nSequence set under MAX_UINT this may push nLocktime a few blocks or seconds later
 func CheckForLowS(preimage []byte) ([]byte, uint32, error) {
-	n := uint32(0)
+	return CheckForLowSContext(context.Background(), preimage)
+}
+
+// CheckForLowSContext is CheckForLowS returning ctx.Err() if the context is done before a low s preimage is found
+func CheckForLowSContext(ctx context.Context, preimage []byte) ([]byte, uint32, error) {
+	if len(preimage) < 8 {
+		return nil, 0, errors.New("preimage length is bad, expected at least 8 bytes")
+	}
+	n := binary.LittleEndian.Uint32(preimage[len(preimage)-8:])
+	last := MaxLockTime(n)
 	// if low s then malleate nLocktime until we get low S
 	for !IsLowS(preimage) {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+		if n == last {
+			return nil, 0, ErrNoLowSLockTime
+		}
 
 		parsedPreimage, err := ParseBytes(preimage)
 		if err != nil {
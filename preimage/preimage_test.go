@@ -1,8 +1,14 @@
 package preimage
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
 	"reflect"
 	"testing"
+
+	"github.com/murray-distributed-technologies/go-pushtx/script"
 )
 
 func TestParseHex(t *testing.T) {
@@ -49,3 +55,93 @@ func TestParseHex(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckForLowS(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name      string
+		nLocktime uint32
+	}{
+		{"zero nLocktime", 0},
+		{"block height", 800000},
+		{"timestamp", 1700000000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParseHex("010000009a2fa936542fa3c61222edfe04cd69a4f5e152bc0248f6a48c8408e242610e8e3bb13029ce7b1f559ef5e747fcac439f1455a2ec7c5f09b72290795e7066504445b546bce8be4cd4625399b780d7cc99bace957e3b4e72928ad1b9d71993fc5800000000c20079aa517f7c818b7c7e263044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f8179802207c7e01417e2102b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0ad7514cb030491157b26a570b6ee91e5b068d99c3b72f6046d657461a72231346e64483972374e327072396d71793666536f635955483263534a707644394a71044e554c4c7176a9142989611fd22fb65e8d6bb2c2b4e3a2b10dc604dd88ad6d876a0774657374696e67d007000000000000ffffffff09488de72898e69b4be145d7f7e53bdc74069db4ba04a6be563e05e91c17c4770000000041000000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			binary.LittleEndian.PutUint32(p.NLocktime, test.nLocktime)
+
+			preimage, nLocktime, err := CheckForLowS(p.BuildPreimage())
+			if err != nil {
+				t.Fatalf("%s failed: %v", test.name, err)
+			}
+			if !IsLowS(preimage) {
+				t.Errorf("%s failed: expected low s preimage", test.name)
+			}
+			if nLocktime < test.nLocktime {
+				t.Errorf("%s failed: nLocktime %d is below starting nLocktime %d", test.name, nLocktime, test.nLocktime)
+			}
+			if nLocktime > MaxLockTime(test.nLocktime) {
+				t.Errorf("%s failed: nLocktime %d is past the range of starting nLocktime %d", test.name, nLocktime, test.nLocktime)
+			}
+			if binary.LittleEndian.Uint32(preimage[len(preimage)-8:]) != nLocktime {
+				t.Errorf("%s failed: returned nLocktime %d does not match preimage", test.name, nLocktime)
+			}
+		})
+	}
+}
+
+func TestCheckForLowSStaysInRange(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name      string
+		nLocktime uint32
+	}{
+		{"last block height", script.LockTimeThreshold - 1},
+		{"last timestamp", math.MaxUint32},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := ParseHex("010000009a2fa936542fa3c61222edfe04cd69a4f5e152bc0248f6a48c8408e242610e8e3bb13029ce7b1f559ef5e747fcac439f1455a2ec7c5f09b72290795e7066504445b546bce8be4cd4625399b780d7cc99bace957e3b4e72928ad1b9d71993fc5800000000c20079aa517f7c818b7c7e263044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f8179802207c7e01417e2102b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0ad7514cb030491157b26a570b6ee91e5b068d99c3b72f6046d657461a72231346e64483972374e327072396d71793666536f635955483263534a707644394a71044e554c4c7176a9142989611fd22fb65e8d6bb2c2b4e3a2b10dc604dd88ad6d876a0774657374696e67d007000000000000ffffffff09488de72898e69b4be145d7f7e53bdc74069db4ba04a6be563e05e91c17c4770000000041000000")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// change nVersion until the preimage needs malleating at the last nLocktime of its range
+			binary.LittleEndian.PutUint32(p.NLocktime, test.nLocktime)
+			for v := uint32(0); IsLowS(p.BuildPreimage()); v++ {
+				binary.LittleEndian.PutUint32(p.NVersion, v)
+			}
+			if _, _, err = CheckForLowS(p.BuildPreimage()); !errors.Is(err, ErrNoLowSLockTime) {
+				t.Errorf("%s failed: expected ErrNoLowSLockTime, got %v", test.name, err)
+			}
+		})
+	}
+}
+
+func TestCheckForLowSContext(t *testing.T) {
+	t.Parallel()
+	p, err := ParseHex("010000009a2fa936542fa3c61222edfe04cd69a4f5e152bc0248f6a48c8408e242610e8e3bb13029ce7b1f559ef5e747fcac439f1455a2ec7c5f09b72290795e7066504445b546bce8be4cd4625399b780d7cc99bace957e3b4e72928ad1b9d71993fc5800000000c20079aa517f7c818b7c7e263044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f8179802207c7e01417e2102b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0ad7514cb030491157b26a570b6ee91e5b068d99c3b72f6046d657461a72231346e64483972374e327072396d71793666536f635955483263534a707644394a71044e554c4c7176a9142989611fd22fb65e8d6bb2c2b4e3a2b10dc604dd88ad6d876a0774657374696e67d007000000000000ffffffff09488de72898e69b4be145d7f7e53bdc74069db4ba04a6be563e05e91c17c4770000000041000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// find an nLocktime that needs malleating
+	for n := uint32(0); IsLowS(p.BuildPreimage()); n++ {
+		binary.LittleEndian.PutUint32(p.NLocktime, n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err = CheckForLowSContext(ctx, p.BuildPreimage()); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	preimage, _, err := CheckForLowSContext(context.Background(), p.BuildPreimage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsLowS(preimage) {
+		t.Error("expected low s preimage")
+	}
+}
@@ -0,0 +1,163 @@
+package rabin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+)
+
+/*
+Rabin Signatures
+----------------
+
+The public key is n = p * q where p and q are primes with p = q = 3 mod 4.
+
+A signature of message m is a padding and a number s where
+
+	s * s mod n = H(m || padding) mod n
+
+which script can check with OP_MUL and OP_MOD, much cheaper than ECDSA.
+Signing adds zero bytes of padding until the hash is a square mod n.
+H chains SHA256 until it is longer than n:
+
+	H(x) = h1 || h2 || ... || hk, h1 = SHA256(x), hi+1 = SHA256(hi)
+
+read as an unsigned little endian number.
+*/
+
+var (
+	three = big.NewInt(3)
+	four  = big.NewInt(4)
+)
+
+// maxPadding bounds the search for a padding, about half of all hashes are squares
+const maxPadding = 256
+
+// PublicKey of a Rabin key pair
+type PublicKey struct {
+	N *big.Int
+}
+
+// PrivateKey of a Rabin key pair
+type PrivateKey struct {
+	PublicKey
+	P *big.Int
+	Q *big.Int
+}
+
+// GenerateKey returns a new key pair with an n of bits length, reading randomness from random
+func GenerateKey(random io.Reader, bits int) (*PrivateKey, error) {
+	if bits < 256 {
+		return nil, errors.New("rabin keys must be at least 256 bits")
+	}
+	p, err := prime3Mod4(random, bits/2)
+	if err != nil {
+		return nil, err
+	}
+	q, err := prime3Mod4(random, bits-bits/2)
+	if err != nil {
+		return nil, err
+	}
+	for p.Cmp(q) == 0 {
+		if q, err = prime3Mod4(random, bits-bits/2); err != nil {
+			return nil, err
+		}
+	}
+	return &PrivateKey{
+		PublicKey: PublicKey{N: new(big.Int).Mul(p, q)},
+		P:         p,
+		Q:         q,
+	}, nil
+}
+
+func prime3Mod4(random io.Reader, bits int) (*big.Int, error) {
+	for {
+		p, err := rand.Prime(random, bits)
+		if err != nil {
+			return nil, err
+		}
+		if new(big.Int).Mod(p, four).Cmp(three) == 0 {
+			return p, nil
+		}
+	}
+}
+
+// Sign returns the signature of message and the padding it was signed with
+func (k *PrivateKey) Sign(message []byte) (*big.Int, []byte, error) {
+	for i := 0; i < maxPadding; i++ {
+		padding := make([]byte, i)
+		h := HashToInt(append(append([]byte{}, message...), padding...), k.N)
+		sp, ok := sqrtMod(h, k.P)
+		if !ok {
+			continue
+		}
+		sq, ok := sqrtMod(h, k.Q)
+		if !ok {
+			continue
+		}
+		return k.crt(sp, sq), padding, nil
+	}
+	return nil, nil, errors.New("could not find a padding for the message")
+}
+
+// sqrtMod returns the square root of h mod a prime p = 3 mod 4, if there is one
+func sqrtMod(h, p *big.Int) (*big.Int, bool) {
+	e := new(big.Int).Add(p, big.NewInt(1))
+	e.Rsh(e, 2)
+	r := new(big.Int).Exp(h, e, p)
+	if new(big.Int).Exp(r, big.NewInt(2), p).Cmp(new(big.Int).Mod(h, p)) != 0 {
+		return nil, false
+	}
+	return r, true
+}
+
+// crt combines roots mod p and mod q into the root mod n
+func (k *PrivateKey) crt(sp, sq *big.Int) *big.Int {
+	// s = sp + p * ((sq - sp) * p^-1 mod q)
+	pInv := new(big.Int).ModInverse(k.P, k.Q)
+	t := new(big.Int).Sub(sq, sp)
+	t.Mul(t, pInv)
+	t.Mod(t, k.Q)
+	t.Mul(t, k.P)
+	return t.Add(t, sp)
+}
+
+// Verify checks sig is a signature of message with padding under the public key
+func (k *PublicKey) Verify(message, padding []byte, sig *big.Int) bool {
+	if sig == nil || sig.Sign() < 0 {
+		return false
+	}
+	h := HashToInt(append(append([]byte{}, message...), padding...), k.N)
+	s2 := new(big.Int).Mul(sig, sig)
+	return s2.Mod(s2, k.N).Cmp(h) == 0
+}
+
+// HashLength returns the number of SHA256 hashes chained by H for n
+func HashLength(n *big.Int) int {
+	return (n.BitLen()+7)/8/sha256.Size + 1
+}
+
+// Hash returns H(x) long enough for n
+func Hash(x []byte, n *big.Int) []byte {
+	var b []byte
+	h := sha256.Sum256(x)
+	for i := 0; i < HashLength(n); i++ {
+		b = append(b, h[:]...)
+		h = sha256.Sum256(h[:])
+	}
+	return b
+}
+
+// HashToInt returns H(x) mod n
+func HashToInt(x []byte, n *big.Int) *big.Int {
+	h := Hash(x, n)
+	// little endian to the big endian big.Int expects
+	be := make([]byte, len(h))
+	for i, b := range h {
+		be[len(h)-1-i] = b
+	}
+	v := new(big.Int).SetBytes(be)
+	return v.Mod(v, n)
+}
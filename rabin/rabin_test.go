@@ -0,0 +1,49 @@
+package rabin
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	t.Parallel()
+	key, err := GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name    string
+		message []byte
+	}{
+		{"empty", []byte{}},
+		{"outcome", []byte("home team wins")},
+		{"binary", []byte{0x00, 0xff, 0x80, 0x01}},
+	}
+	for _, test := range tests {
+		sig, padding, err := key.Sign(test.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Cmp(key.N) >= 0 {
+			t.Errorf("%s failed: expected signature below n", test.name)
+		}
+		if !key.Verify(test.message, padding, sig) {
+			t.Errorf("%s failed: expected signature to verify", test.name)
+		}
+		if key.Verify(append(test.message, 'x'), padding, sig) {
+			t.Errorf("%s failed: expected signature of another message to fail", test.name)
+		}
+		if other.Verify(test.message, padding, sig) {
+			t.Errorf("%s failed: expected signature under another key to fail", test.name)
+		}
+		if key.Verify(test.message, padding, new(big.Int).Add(sig, big.NewInt(1))) {
+			t.Errorf("%s failed: expected altered signature to fail", test.name)
+		}
+	}
+}
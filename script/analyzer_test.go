@@ -0,0 +1,73 @@
+package script
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/analyzer"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+// TestAnalyzeTemplates checks every template leaves the stack as its comment says, on every branch
+func TestAnalyzeTemplates(t *testing.T) {
+	t.Parallel()
+	_, address := testutil.NewKey(t)
+	_, refundAddress := testutil.NewKey(t)
+	pubKey := append([]byte{0x02}, bytes.Repeat([]byte{1}, 32)...)
+	modulus := new(big.Int).Lsh(big.NewInt(1), 1024)
+
+	var tests = []struct {
+		name            string
+		append          func(s *bscript.Script) (*bscript.Script, error)
+		inputs          int
+		expectedHeights []int
+	}{
+		{"p2pkh", func(s *bscript.Script) (*bscript.Script, error) { return AppendP2PKH(s, address) }, 2, []int{1}},
+		{"push tx", AppendPushTx, 1, []int{0}},
+		{"push tx verify", AppendPushTxVerify, 1, []int{1}},
+		{"get locking script", AppendGetLockingScriptFromPreimage, 1, []int{1}},
+		{"split push tx", func(s *bscript.Script) (*bscript.Script, error) { return SplitPushTxFromLockingScript(s) }, 1, []int{2}},
+		{"get hashPrevouts", AppendGetHashPrevoutsFromPreimage, 1, []int{1}},
+		{"get outpoint", AppendGetOutpointFromPreimage, 1, []int{1}},
+		{"get value", AppendGetValueFromPreimage, 1, []int{1}},
+		{"get nSequence", AppendGetNSequenceFromPreimage, 1, []int{1}},
+		{"get hashOutputs", AppendGetHashOutputsFromPreimage, 1, []int{1}},
+		{"get nLocktime", AppendGetNLocktimeFromPreimage, 1, []int{1}},
+		{"split state", func(s *bscript.Script) (*bscript.Script, error) { return AppendSplitStateFromPreimage(s, 8) }, 1, []int{2}},
+		{"verify outputs", AppendVerifyOutputs, 2, []int{0}},
+		{"build p2pkh output", AppendBuildP2PKHOutput, 2, []int{1}},
+		{"split varInt", AppendSplitVarInt, 1, []int{2}},
+		{"get output from tx", func(s *bscript.Script) (*bscript.Script, error) { return AppendGetOutputFromTx(s, 3, 2) }, 2, []int{1}},
+		{"check lock time", func(s *bscript.Script) (*bscript.Script, error) { return AppendCheckLockTime(s, 800000) }, 1, []int{1}},
+		{"multisig", func(s *bscript.Script) (*bscript.Script, error) {
+			return AppendMultisig(s, 2, [][]byte{pubKey, pubKey, pubKey})
+		}, 3, []int{1}},
+		{"hash puzzle", func(s *bscript.Script) (*bscript.Script, error) { return AppendHashPuzzle(s, make([]byte, 32)) }, 1, []int{1}},
+		{"p2pkh or timeout", func(s *bscript.Script) (*bscript.Script, error) {
+			return AppendP2PKHOrTimeout(s, address, refundAddress, 800000)
+		}, 4, []int{1}},
+		{"r puzzle", func(s *bscript.Script) (*bscript.Script, error) { return AppendRPuzzle(s, make([]byte, 20)) }, 2, []int{1}},
+		{"rabin verify", func(s *bscript.Script) (*bscript.Script, error) { return AppendRabinVerify(s, modulus) }, 3, []int{1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := test.append(&bscript.Script{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := analyzer.Analyze(s, test.inputs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = r.Err(); err != nil {
+				t.Errorf("%s failed: %v", test.name, err)
+			}
+			if !reflect.DeepEqual(r.FinalHeights, test.expectedHeights) {
+				t.Errorf("%s failed: expected final heights %v, got %v", test.name, test.expectedHeights, r.FinalHeights)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package script
+
+import (
+	"github.com/libsv/go-bt/v2/bscript"
+)
+
+// Builder appends to a script, keeping the first error so a sequence of appends is checked once.
+// Once an append fails the rest are skipped and Script returns the error
+type Builder struct {
+	s   *bscript.Script
+	err error
+}
+
+// NewBuilder returns a builder appending to the script
+func NewBuilder(s *bscript.Script) *Builder {
+	return &Builder{s: s}
+}
+
+// Opcodes appends opcodes, which must not be pushes
+func (b *Builder) Opcodes(opcodes ...byte) *Builder {
+	if b.err == nil {
+		b.err = b.s.AppendOpcodes(opcodes...)
+	}
+	return b
+}
+
+// PushData pushes the data as AppendPushData does
+func (b *Builder) PushData(data []byte) *Builder {
+	if b.err == nil {
+		b.err = b.s.AppendPushData(data)
+	}
+	return b
+}
+
+// PushHex pushes the data of the hex string
+func (b *Builder) PushHex(data string) *Builder {
+	if b.err == nil {
+		b.err = b.s.AppendPushDataHexString(data)
+	}
+	return b
+}
+
+// Number pushes n as AppendNumber does
+func (b *Builder) Number(n int64) *Builder {
+	return b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+		return AppendNumber(s, n)
+	})
+}
+
+// Append appends with an Append function of this package, such as AppendP2PKH
+func (b *Builder) Append(f func(s *bscript.Script) (*bscript.Script, error)) *Builder {
+	if b.err == nil {
+		var s *bscript.Script
+		if s, b.err = f(b.s); b.err == nil {
+			b.s = s
+		}
+	}
+	return b
+}
+
+// Err returns the first error of the appends
+func (b *Builder) Err() error {
+	return b.err
+}
+
+// Script returns the script, or the first error of the appends
+func (b *Builder) Script() (*bscript.Script, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.s, nil
+}
@@ -0,0 +1,46 @@
+package script
+
+import (
+	"testing"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+func TestBuilder(t *testing.T) {
+	t.Parallel()
+	s, err := NewBuilder(&bscript.Script{}).Opcodes(bscript.OpDUP).PushHex("aabb").Number(1000).PushData([]byte{1}).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "7602aabb02e8030101"; s.String() != expected {
+		t.Errorf("expected %s, got %s", expected, s)
+	}
+
+	// the first error is kept and the appends after it are skipped
+	for name, b := range map[string]*Builder{
+		"push opcode": NewBuilder(&bscript.Script{}).Opcodes(bscript.OpDATA1).Opcodes(bscript.OpDUP),
+		"invalid hex": NewBuilder(&bscript.Script{}).PushHex("zz").Opcodes(bscript.OpDUP),
+		"append": NewBuilder(&bscript.Script{}).Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return AppendP2PKH(s, "not an address")
+		}).Opcodes(bscript.OpDUP),
+	} {
+		if b.Err() == nil {
+			t.Errorf("%s failed: expected an error", name)
+		}
+		if s, err := b.Script(); err == nil || s != nil {
+			t.Errorf("%s failed: expected no script and an error, got %v %v", name, s, err)
+		}
+	}
+}
+
+func TestTemplatesReportErrors(t *testing.T) {
+	t.Parallel()
+	_, address := testutil.NewKey(t)
+	if _, err := AppendP2PKHOrTimeout(&bscript.Script{}, address, "not an address", 800000); err == nil {
+		t.Error("expected an invalid refund address to be reported")
+	}
+	if _, err := AppendPushTxWithSigHash(&bscript.Script{}, 0x01); err == nil {
+		t.Error("expected a sighash flag without FORKID to be reported")
+	}
+}
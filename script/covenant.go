@@ -0,0 +1,257 @@
+package script
+
+import (
+	"github.com/libsv/go-bt/v2/bscript"
+)
+
+/*
+Preimage Layout (BIP143)
+------------------------
+
+| nVersion 4 | hashPrevouts 32 | hashSequence 32 | outpoint 36 | scriptCode ? | value 8 | nSequence 4 | hashOutputs 32 | nLocktime 4 | sighash 4 |
+
+The first 104 bytes and the last 52 bytes of the preimage are fixed length,
+so every field can be split out relative to the start or the end of the preimage.
+*/
+
+const (
+	// ScriptCodeOffset is the position in the preimage where scriptCode begins
+	ScriptCodeOffset = 104
+	// PreimageSuffixLength is the length of the preimage after the scriptCode
+	PreimageSuffixLength = 52
+)
+
+// EncodeNumber returns the minimal little endian sign-magnitude encoding of n used by script numbers
+func EncodeNumber(n int64) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	negative := n < 0
+	abs := uint64(n)
+	if negative {
+		abs = uint64(-n)
+	}
+	var b []byte
+	for abs > 0 {
+		b = append(b, byte(abs&0xff))
+		abs >>= 8
+	}
+	// add an extra byte if the most significant bit is used, otherwise set the sign bit in it
+	if b[len(b)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		b = append(b, extra)
+	} else if negative {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// AppendNumber pushes n to the stack using the smallest opcode possible
+func AppendNumber(s *bscript.Script, n int64) (*bscript.Script, error) {
+	var err error
+	switch {
+	case n == 0:
+		err = s.AppendOpcodes(bscript.Op0)
+	case n == -1:
+		err = s.AppendOpcodes(bscript.Op1NEGATE)
+	case n >= 1 && n <= 16:
+		err = s.AppendOpcodes(bscript.Op1 + uint8(n-1))
+	default:
+		err = s.AppendPushData(EncodeNumber(n))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AppendPushDataMinimal pushes data using OP_0, OP_1NEGATE or OP_1 to OP_16 where possible
+// so the script passes the minimal data policy
+func AppendPushDataMinimal(s *bscript.Script, data []byte) (*bscript.Script, error) {
+	var err error
+	switch {
+	case len(data) == 0:
+		err = s.AppendOpcodes(bscript.Op0)
+	case len(data) == 1 && data[0] >= 1 && data[0] <= 16:
+		err = s.AppendOpcodes(bscript.Op1 + data[0] - 1)
+	case len(data) == 1 && data[0] == 0x81:
+		err = s.AppendOpcodes(bscript.Op1NEGATE)
+	default:
+		err = s.AppendPushData(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// appendSplitFromEnd assumes preimage on top of the stack
+// Replaces the preimage with the length bytes found offset bytes before its end
+func appendSplitFromEnd(s *bscript.Script, offset, length int64) (*bscript.Script, error) {
+	b := NewBuilder(s).Opcodes(bscript.OpSIZE).Number(offset).Opcodes(bscript.OpSUB, bscript.OpSPLIT, bscript.OpNIP)
+	if offset != length {
+		b.Number(length).Opcodes(bscript.OpSPLIT, bscript.OpDROP)
+	}
+	return b.Script()
+}
+
+// appendUnsignedBin2Num converts the little endian unsigned bytes on top of the stack to a number
+func appendUnsignedBin2Num(s *bscript.Script) (*bscript.Script, error) {
+	// pad with a zero byte so the sign bit is never set
+	return NewBuilder(s).PushData([]byte{0x00}).Opcodes(bscript.OpCAT, bscript.OpBIN2NUM).Script()
+}
+
+// appendSplitFromStart assumes preimage on top of the stack
+// Replaces the preimage with the length bytes found offset bytes after its start
+func appendSplitFromStart(s *bscript.Script, offset, length int64) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Number(offset).Opcodes(bscript.OpSPLIT, bscript.OpNIP).
+		Number(length).Opcodes(bscript.OpSPLIT, bscript.OpDROP).
+		Script()
+}
+
+// AppendGetHashPrevoutsFromPreimage assumes preimage on top of the stack
+// Leaves the 32 byte hashPrevouts of the transaction
+func AppendGetHashPrevoutsFromPreimage(s *bscript.Script) (*bscript.Script, error) {
+	return appendSplitFromStart(s, 4, 32)
+}
+
+// AppendGetOutpointFromPreimage assumes preimage on top of the stack
+// Leaves the 36 byte outpoint of the input being spent
+func AppendGetOutpointFromPreimage(s *bscript.Script) (*bscript.Script, error) {
+	return appendSplitFromStart(s, 68, 36)
+}
+
+// AppendGetValueFromPreimage assumes preimage on top of the stack
+// Leaves the 8 byte little endian value of the output being spent
+func AppendGetValueFromPreimage(s *bscript.Script) (*bscript.Script, error) {
+	return appendSplitFromEnd(s, PreimageSuffixLength, 8)
+}
+
+// AppendGetNSequenceFromPreimage assumes preimage on top of the stack
+// Leaves the nSequence of the input being spent as a number
+func AppendGetNSequenceFromPreimage(s *bscript.Script) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Append(func(s *bscript.Script) (*bscript.Script, error) { return appendSplitFromEnd(s, 44, 4) }).
+		Append(appendUnsignedBin2Num).
+		Script()
+}
+
+// AppendGetHashOutputsFromPreimage assumes preimage on top of the stack
+// Leaves the 32 byte hashOutputs of the transaction
+func AppendGetHashOutputsFromPreimage(s *bscript.Script) (*bscript.Script, error) {
+	return appendSplitFromEnd(s, 40, 32)
+}
+
+// AppendGetNLocktimeFromPreimage assumes preimage on top of the stack
+// Leaves the nLocktime of the transaction as a number
+func AppendGetNLocktimeFromPreimage(s *bscript.Script) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Append(func(s *bscript.Script) (*bscript.Script, error) { return appendSplitFromEnd(s, 8, 4) }).
+		Append(appendUnsignedBin2Num).
+		Script()
+}
+
+// AppendSplitStateFromPreimage assumes preimage on top of the stack
+// and that the locking script ends with stateLength bytes of state.
+// Leaves <scriptCode without state> <state>, where the scriptCode still
+// begins with its length varInt so a new locking script with the same
+// length state can be rebuilt with a single OP_CAT
+func AppendSplitStateFromPreimage(s *bscript.Script, stateLength int64) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Number(ScriptCodeOffset).Opcodes(bscript.OpSPLIT, bscript.OpNIP).
+		Opcodes(bscript.OpSIZE).Number(PreimageSuffixLength+stateLength).Opcodes(bscript.OpSUB, bscript.OpSPLIT).
+		Number(stateLength).Opcodes(bscript.OpSPLIT, bscript.OpDROP).
+		Script()
+}
+
+// AppendVerifyOutputs assumes <outputs> <hashOutputs> on top of the stack
+// Fails the script unless the serialized outputs hash to hashOutputs
+func AppendVerifyOutputs(s *bscript.Script) (*bscript.Script, error) {
+	return NewBuilder(s).Opcodes(bscript.OpSWAP, bscript.OpHASH256, bscript.OpEQUALVERIFY).Script()
+}
+
+// AppendBuildP2PKHOutput assumes <value> <pubKeyHash> on top of the stack
+// Leaves the serialized P2PKH output paying the 8 byte value to the 20 byte pubKeyHash
+func AppendBuildP2PKHOutput(s *bscript.Script) (*bscript.Script, error) {
+	return NewBuilder(s).
+		// varInt length of the locking script followed by OP_DUP OP_HASH160 OP_DATA_20
+		PushData([]byte{0x19, bscript.OpDUP, bscript.OpHASH160, bscript.OpDATA20}).
+		Opcodes(bscript.OpSWAP, bscript.OpCAT).
+		PushData([]byte{bscript.OpEQUALVERIFY, bscript.OpCHECKSIG}).
+		Opcodes(bscript.OpCAT, bscript.OpCAT).
+		Script()
+}
+
+// LockTimeThreshold is the nLocktime below which it is read as a block height rather than a unix timestamp
+const LockTimeThreshold = 500000000
+
+// AppendCheckLockTime assumes preimage on top of the stack and leaves it there
+// Fails the script unless nSequence is non-final, so nLocktime is enforced by consensus,
+// and nLocktime is at least lockTime in the same unit as lockTime
+func AppendCheckLockTime(s *bscript.Script, lockTime uint32) (*bscript.Script, error) {
+	b := NewBuilder(s)
+	// nSequence < 0xffffffff
+	b.Opcodes(bscript.OpDUP).Append(AppendGetNSequenceFromPreimage)
+	b.Number(0xffffffff).Opcodes(bscript.OpLESSTHAN, bscript.OpVERIFY)
+
+	b.Opcodes(bscript.OpDUP).Append(AppendGetNLocktimeFromPreimage)
+	// a timestamp would always be above a height, so a height lock must also be a height
+	if lockTime < LockTimeThreshold {
+		b.Opcodes(bscript.OpDUP).Number(LockTimeThreshold).Opcodes(bscript.OpLESSTHAN, bscript.OpVERIFY)
+	}
+	b.Number(int64(lockTime)).Opcodes(bscript.OpGREATERTHANOREQUAL, bscript.OpVERIFY)
+	return b.Script()
+}
+
+// AppendSplitVarInt assumes bytes beginning with a varInt on top of the stack
+// Leaves <bytes after the varInt> <value of the varInt>. Fails the script for
+// 9 byte varInts, which no script length or count in a transaction needs
+func AppendSplitVarInt(s *bscript.Script) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Opcodes(bscript.Op1, bscript.OpSPLIT, bscript.OpSWAP).
+		Opcodes(bscript.OpDUP).PushData([]byte{0xfd}).Opcodes(bscript.OpEQUAL, bscript.OpIF).
+		Opcodes(bscript.OpDROP, bscript.Op2, bscript.OpSPLIT, bscript.OpSWAP).Append(appendUnsignedBin2Num).
+		Opcodes(bscript.OpELSE, bscript.OpDUP).PushData([]byte{0xfe}).Opcodes(bscript.OpEQUAL, bscript.OpIF).
+		Opcodes(bscript.OpDROP, bscript.Op4, bscript.OpSPLIT, bscript.OpSWAP).Append(appendUnsignedBin2Num).
+		Opcodes(bscript.OpELSE).Append(appendUnsignedBin2Num).
+		Opcodes(bscript.OpDUP).Number(0xfd).Opcodes(bscript.OpLESSTHAN, bscript.OpVERIFY).
+		Opcodes(bscript.OpENDIF, bscript.OpENDIF).
+		Script()
+}
+
+// AppendGetOutputFromTx assumes <tx> <vout> on top of the stack, with tx serialized and vout a number
+// Leaves the bytes of tx from output vout to its end. Script can't loop, so the inputs and
+// the outputs before vout are skipped by unrolled steps, and the script fails if tx has more
+// than maxInputs inputs, vout is more than maxVout or vout is not an output of tx
+func AppendGetOutputFromTx(s *bscript.Script, maxInputs, maxVout int64) (*bscript.Script, error) {
+	b := NewBuilder(s)
+	// skip nVersion, stack: <vout> <inputCount> <inputs...>
+	b.Opcodes(bscript.OpSWAP, bscript.Op4, bscript.OpSPLIT, bscript.OpNIP)
+	b.Append(AppendSplitVarInt).Opcodes(bscript.OpSWAP)
+	for i := int64(0); i < maxInputs; i++ {
+		// outpoint, unlocking script and nSequence
+		b.Opcodes(bscript.OpOVER).Number(i).Opcodes(bscript.OpGREATERTHAN, bscript.OpIF)
+		b.Number(36).Opcodes(bscript.OpSPLIT, bscript.OpNIP)
+		b.Append(AppendSplitVarInt).Opcodes(bscript.OpSPLIT, bscript.OpNIP)
+		b.Opcodes(bscript.Op4, bscript.OpSPLIT, bscript.OpNIP)
+		b.Opcodes(bscript.OpENDIF)
+	}
+	b.Opcodes(bscript.OpSWAP).Number(maxInputs).Opcodes(bscript.OpLESSTHANOREQUAL, bscript.OpVERIFY)
+
+	// stack: <vout> <outputCount> <outputs...>
+	b.Append(AppendSplitVarInt)
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.OpGREATERTHAN, bscript.OpVERIFY)
+	for i := int64(0); i < maxVout; i++ {
+		// value and locking script
+		b.Opcodes(bscript.OpOVER).Number(i).Opcodes(bscript.OpGREATERTHAN, bscript.OpIF)
+		b.Number(8).Opcodes(bscript.OpSPLIT, bscript.OpNIP)
+		b.Append(AppendSplitVarInt).Opcodes(bscript.OpSPLIT, bscript.OpNIP)
+		b.Opcodes(bscript.OpENDIF)
+	}
+	b.Opcodes(bscript.OpSWAP).Number(maxVout).Opcodes(bscript.OpLESSTHANOREQUAL, bscript.OpVERIFY)
+	return b.Script()
+}
@@ -0,0 +1,76 @@
+package script
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/bscript/interpreter"
+)
+
+func TestGetOutputFromTx(t *testing.T) {
+	t.Parallel()
+	newTx := func(inputs int, unlockingScriptLength int) *bt.Tx {
+		tx := bt.NewTx()
+		for i := 0; i < inputs; i++ {
+			unlockingScript := bscript.Script(bytes.Repeat([]byte{bscript.OpTRUE}, unlockingScriptLength))
+			tx.Inputs = append(tx.Inputs, &bt.Input{
+				PreviousTxOutIndex: uint32(i),
+				UnlockingScript:    &unlockingScript,
+				SequenceNumber:     bt.DefaultSequenceNumber,
+			})
+			if err := tx.Inputs[i].PreviousTxIDAdd(bytes.Repeat([]byte{byte(i)}, 32)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			tx.AddOutput(&bt.Output{Satoshis: uint64(i + 1), LockingScript: bscript.NewFromBytes(bytes.Repeat([]byte{bscript.OpTRUE}, 300*i))})
+		}
+		return tx
+	}
+
+	var tests = []struct {
+		name          string
+		tx            *bt.Tx
+		vout          int64
+		expectedError bool
+	}{
+		{"first output", newTx(1, 10), 0, false},
+		{"second output", newTx(3, 10), 1, false},
+		{"long unlocking scripts", newTx(2, 300), 1, false},
+		{"too many inputs", newTx(4, 10), 0, true},
+		{"past max vout", newTx(1, 10), 2, true},
+		{"past the outputs", newTx(1, 10), 3, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var expected []byte
+			for i, o := range test.tx.Outputs {
+				if int64(i) >= test.vout {
+					expected = append(expected, o.Bytes()...)
+				}
+			}
+			expected = append(expected, test.tx.Bytes()[len(test.tx.Bytes())-4:]...)
+
+			s, err := NewBuilder(&bscript.Script{}).
+				PushData(test.tx.Bytes()).Number(test.vout).
+				Append(func(s *bscript.Script) (*bscript.Script, error) { return AppendGetOutputFromTx(s, 3, 1) }).
+				PushData(expected).Opcodes(bscript.OpEQUAL).
+				Script()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = interpreter.NewEngine().Execute(
+				interpreter.WithScripts(s, &bscript.Script{}),
+				interpreter.WithAfterGenesis(),
+			)
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected error", test.name)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package script
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/libsv/go-bt/v2/bscript"
+)
+
+// AppendMultisig appends a bare m of n OP_CHECKMULTISIG of the public keys
+// Unlocked by OP_0 <sig>... with m signatures in the order of their public keys
+func AppendMultisig(s *bscript.Script, m int, pubKeys [][]byte) (*bscript.Script, error) {
+	if m < 1 || m > len(pubKeys) || len(pubKeys) > 16 {
+		return nil, errors.New("multisig needs 1 <= m <= n <= 16")
+	}
+	b := NewBuilder(s).Number(int64(m))
+	for _, pubKey := range pubKeys {
+		b.PushData(pubKey)
+	}
+	return b.Number(int64(len(pubKeys))).Opcodes(bscript.OpCHECKMULTISIG).Script()
+}
+
+// AppendHashPuzzle appends a check that the secret on top of the stack has the SHA256 hash
+// Anyone who learns the secret can spend, so it should only guard secrets revealed at spend time
+func AppendHashPuzzle(s *bscript.Script, hash []byte) (*bscript.Script, error) {
+	if len(hash) != sha256.Size {
+		return nil, errors.New("hash must be 32 bytes")
+	}
+	return NewBuilder(s).Opcodes(bscript.OpSHA256).PushData(hash).Opcodes(bscript.OpEQUAL).Script()
+}
+
+// AppendP2PKHOrTimeout assumes <sig> <pubKey> <branch> <preimage> on top of the stack
+// Branch 1 spends with a signature of address at any time, branch 0 spends with a signature
+// of refundAddress once nLocktime reaches lockTime
+func AppendP2PKHOrTimeout(s *bscript.Script, address, refundAddress string, lockTime uint32) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Opcodes(bscript.OpSWAP, bscript.OpIF, bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) { return AppendP2PKH(s, address) }).
+		Opcodes(bscript.OpELSE).
+		Append(func(s *bscript.Script) (*bscript.Script, error) { return AppendCheckLockTime(s, lockTime) }).
+		Opcodes(bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) { return AppendP2PKH(s, refundAddress) }).
+		Opcodes(bscript.OpENDIF).
+		Script()
+}
+
+// AppendRPuzzle assumes <sig> <pubKey> on top of the stack
+// Spends with a signature by any key whose r value has the HASH160 rHash, so whoever knows
+// the k behind r can sign. r is hashed as it appears in the DER signature
+func AppendRPuzzle(s *bscript.Script, rHash []byte) (*bscript.Script, error) {
+	if len(rHash) != 20 {
+		return nil, errors.New("r hash must be 20 bytes")
+	}
+	return NewBuilder(s).
+		// skip the DER sequence header and integer tag, then split out r by its length
+		Opcodes(bscript.OpOVER, bscript.Op3, bscript.OpSPLIT, bscript.OpNIP).
+		Opcodes(bscript.Op1, bscript.OpSPLIT, bscript.OpSWAP, bscript.OpSPLIT, bscript.OpDROP).
+		Opcodes(bscript.OpHASH160).
+		PushData(rHash).
+		Opcodes(bscript.OpEQUALVERIFY, bscript.OpCHECKSIG).
+		Script()
+}
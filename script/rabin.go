@@ -0,0 +1,49 @@
+package script
+
+import (
+	"math/big"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/rabin"
+)
+
+// EncodeBigNumber returns the minimal little endian sign-magnitude encoding of n used by script numbers
+func EncodeBigNumber(n *big.Int) []byte {
+	if n.Sign() == 0 {
+		return []byte{}
+	}
+	be := new(big.Int).Abs(n).Bytes()
+	b := make([]byte, len(be))
+	for i, v := range be {
+		b[len(be)-1-i] = v
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		b = append(b, 0x00)
+	}
+	if n.Sign() < 0 {
+		b[len(b)-1] |= 0x80
+	}
+	return b
+}
+
+// AppendRabinVerify assumes <message> <padding> <sig> on top of the stack
+// Fails the script unless sig is a Rabin signature of message with padding under the public key n,
+// and leaves <message>
+func AppendRabinVerify(s *bscript.Script, n *big.Int) (*bscript.Script, error) {
+	modulus := EncodeBigNumber(n)
+	b := NewBuilder(s)
+
+	// sig * sig mod n
+	b.Opcodes(bscript.OpDUP, bscript.OpMUL).PushData(modulus).Opcodes(bscript.OpMOD)
+
+	// H(message || padding) mod n
+	b.Opcodes(bscript.Op2, bscript.OpPICK, bscript.OpROT, bscript.OpCAT, bscript.OpSHA256)
+	for i := 1; i < rabin.HashLength(n); i++ {
+		b.Opcodes(bscript.OpDUP, bscript.OpSHA256)
+	}
+	for i := 1; i < rabin.HashLength(n); i++ {
+		b.Opcodes(bscript.OpCAT)
+	}
+	b.Append(appendUnsignedBin2Num).PushData(modulus).Opcodes(bscript.OpMOD, bscript.OpEQUALVERIFY)
+	return b.Script()
+}
@@ -2,12 +2,26 @@ package script
 
 import (
 	"encoding/hex"
-	"fmt"
+	"errors"
 
 	"github.com/libsv/go-bt/v2/bscript"
 	"github.com/libsv/go-bt/v2/sighash"
 )
 
+// P2PKHTemplate is a P2PKH script
+var P2PKHTemplate = MustNewTemplate(
+	Opcodes{bscript.OpDUP, bscript.OpHASH160},
+	Param{Name: "pubKeyHash", Type: ParamPubKeyHash},
+	Opcodes{bscript.OpEQUALVERIFY, bscript.OpCHECKSIG},
+)
+
+// checkSigPKHVerifyTemplate is P2PKH ending in OP_CHECKSIGVERIFY
+var checkSigPKHVerifyTemplate = MustNewTemplate(
+	Opcodes{bscript.OpDUP, bscript.OpHASH160},
+	Param{Name: "pubKeyHash", Type: ParamPubKeyHash},
+	Opcodes{bscript.OpEQUALVERIFY, bscript.OpCHECKSIGVERIFY},
+)
+
 func AppendP2PKH(s *bscript.Script, address string) (*bscript.Script, error) {
 	a, err := bscript.NewAddressFromString(address)
 	if err != nil {
@@ -18,128 +32,124 @@ func AppendP2PKH(s *bscript.Script, address string) (*bscript.Script, error) {
 	if publicKeyHashBytes, err = hex.DecodeString(a.PublicKeyHash); err != nil {
 		return nil, err
 	}
+	return AppendCheckSigPKH(s, publicKeyHashBytes)
+}
 
-	if err = s.AppendOpcodes(bscript.OpDUP, bscript.OpHASH160); err != nil {
-		return nil, err
-	}
-	if err = s.AppendPushData(publicKeyHashBytes); err != nil {
-		return nil, err
-	}
-	if err = s.AppendOpcodes(bscript.OpEQUALVERIFY, bscript.OpCHECKSIG); err != nil {
-		return nil, err
-	}
-	return s, nil
+// AppendCheckSigPKH assumes <sig> <pubKey> on top of the stack
+// Leaves the result of checking pubKey hashes to pkh and sig is valid, as P2PKH does
+func AppendCheckSigPKH(s *bscript.Script, pkh []byte) (*bscript.Script, error) {
+	return P2PKHTemplate.Append(s, Values{"pubKeyHash": pkh})
 }
 
-// TODO: Not good checking because we haven't standardized Script Template
-// Assumes OpPUSHTX is first thing on the stack
-func IsOpPushTx(s *bscript.Script) bool {
-	//var err error
-	//b := []byte(*s)
-	//rValueBytes, err := hex.DecodeString("3044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817980220")
-	//if err != nil {
-	//	return false
-	//}
-	//data65, err := hex.DecodeString("41")
-	//if err != nil {
-	//	return false
-	//}
-	//pubKeyHashBytes, err := hex.DecodeString("02b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0")
-	//if err != nil {
-	//	return false
-	//}
-	return true
-	//return b[1] == bscript.OpPICK &&
-	//	b[2] == bscript.OpHASH256 &&
-	//	b[3] == bscript.OpSPLIT &&
-	//	b[4] == bscript.OpSWAP &&
-	//	b[5] == bscript.OpBIN2NUM &&
-	//	b[6] == bscript.Op1ADD &&
-	//	b[7] == bscript.OpSWAP &&
-	//	b[8] == bscript.OpCAT //&&
-	//b[9] == rValueBytes &&
-	//b[10] == bscript.OpSWAP &&
-	//b[11] == bscript.OpCAT &&
-	//b[12] == data65 &&
-	//b[13] == bscript.OpCAT &&
-	//b[14] == pubKeyHashBytes &&
-	//(b[15] == bscript.OpCHECKSIG || b[15] == bscript.OpCHECKSIGVERIFY)
+// AppendCheckSigPKHVerify is AppendCheckSigPKH failing the script instead of leaving false,
+// for checking a signature part way through a script
+func AppendCheckSigPKHVerify(s *bscript.Script, pkh []byte) (*bscript.Script, error) {
+	return checkSigPKHVerifyTemplate.Append(s, Values{"pubKeyHash": pkh})
+}
 
+// PushTxVerifyTemplate is the optimized OP_PUSH_TX script of AppendPushTxVerifyWithSigHash
+var PushTxVerifyTemplate = MustParseTemplate(`
+	# copy the preimage to the top of the stack and double SHA256 hash it
+	OP_0 OP_PICK OP_HASH256
+	# split the first byte of the hash, convert it to a number and add 1, then put it back to get s
+	OP_1 OP_SPLIT OP_SWAP OP_BIN2NUM OP_1ADD OP_SWAP OP_CAT
+	# prefix the DER encoding up to s, with r derived from the OP_PUSH_TX private key, and add the sighash flag
+	PUSHTX_R OP_SWAP OP_CAT <sigHashFlag:sighash> OP_CAT
+	# the signature is valid for the public key only if the preimage is of the current transaction
+	PUSHTX_PUBKEY OP_CHECKSIGVERIFY
+`)
+
+// pushTxLength is the length of the push tx script of any sighash flag
+var pushTxLength, _ = PushTxVerifyTemplate.Length()
+
+// IsOpPushTx reports whether the script starts with the optimized OP_PUSH_TX script of any sighash flag
+func IsOpPushTx(s *bscript.Script) bool {
+	_, ok := PushTxSigHash(s)
+	return ok
 }
 
 // AppendPushTx assumes preimage in the unlocking script
-// Leaves a copy of the preimage on the stack
+// Verifies the preimage and drops it from the stack
 
 func AppendPushTx(s *bscript.Script) (*bscript.Script, error) {
-	var err error
-	// Add number of items back in the stack preimage is
-	_ = s.AppendOpcodes(bscript.Op0)
-	// Copy preimage to top of the stack
-	_ = s.AppendOpcodes(bscript.OpPICK)
-	// Double SHA256 hash preimage
-	_ = s.AppendOpcodes(bscript.OpHASH256)
-	// Split first byte of preimage hash, move to top of the stack, convert to num, and add 1
-	err = s.AppendOpcodes(bscript.Op1)
-	if err != nil {
-		return nil, err
-	}
-	s.AppendOpcodes(bscript.OpSPLIT)
-	s.AppendOpcodes(bscript.OpSWAP)
-	s.AppendOpcodes(bscript.OpBIN2NUM)
-	s.AppendOpcodes(bscript.Op1ADD)
+	return AppendPushTxWithSigHash(s, sighash.AllForkID)
+}
 
-	// Concatenate new first byte with preimage hash
-	s.AppendOpcodes(bscript.OpSWAP)
-	s.AppendOpcodes(bscript.OpCAT)
+// AppendPushTxWithSigHash is AppendPushTx for a preimage of the sighash flag
+func AppendPushTxWithSigHash(s *bscript.Script, sigHashFlag sighash.Flag) (*bscript.Script, error) {
+	return NewBuilder(s).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return AppendPushTxVerifyWithSigHash(s, sigHashFlag)
+		}).
+		// drop preimage from the stack
+		Opcodes(bscript.OpDROP).
+		Script()
+}
 
-	// Push r derived from Optimized OP_PUSH_TX private key
-	if err = s.AppendPushDataHexString("3044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f817980220"); err != nil {
-		return nil, err
-	}
+// AppendPushTxVerify assumes preimage on top of the stack
+// Leaves the verified preimage on the stack for covenant scripts to inspect
 
-	s.AppendOpcodes(bscript.OpSWAP)
-	s.AppendOpcodes(bscript.OpCAT)
-	// Push OP_DATA_65 to stack
-	opData65Bytes, err := hex.DecodeString("41")
-	if err != nil {
+func AppendPushTxVerify(s *bscript.Script) (*bscript.Script, error) {
+	return AppendPushTxVerifyWithSigHash(s, sighash.AllForkID)
+}
+
+// AppendPushTxVerifyWithSigHash is AppendPushTxVerify for a preimage of the sighash flag.
+// The flag is part of the signature checked, so the output can only be spent with that flag
+func AppendPushTxVerifyWithSigHash(s *bscript.Script, sigHashFlag sighash.Flag) (*bscript.Script, error) {
+	if err := CheckSigHashFlag(sigHashFlag); err != nil {
 		return nil, err
 	}
-	if err = s.AppendPushData(opData65Bytes); err != nil {
-		fmt.Println(err)
-	}
+	return PushTxVerifyTemplate.Append(s, Values{"sigHashFlag": sigHashFlag})
+}
 
-	s.AppendOpcodes(bscript.OpCAT)
+// PushTxSigHash returns the sighash flag of a locking script starting with the push tx script
+func PushTxSigHash(s *bscript.Script) (sighash.Flag, bool) {
+	values, _, ok := PushTxVerifyTemplate.MatchPrefix(s)
+	if !ok {
+		return 0, false
+	}
+	return values["sigHashFlag"].(sighash.Flag), true
+}
 
-	// Push Public Key derived from Optimized OP_PUSH_TX private key
-	pubKeyHashBytes, err := hex.DecodeString("02b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0")
-	if err != nil {
-		return nil, err
+// CheckSigHashFlag checks the flag is ALL, NONE or SINGLE with FORKID, optionally with ANYONECANPAY
+func CheckSigHashFlag(sigHashFlag sighash.Flag) error {
+	if sigHashFlag&sighash.ForkID == 0 {
+		return errors.New("sighash flag must include FORKID")
 	}
-	if err = s.AppendPushData(pubKeyHashBytes); err != nil {
-		return nil, err
+	if sigHashFlag&^(sighash.Mask|sighash.ForkID|sighash.AnyOneCanPay) != 0 {
+		return errors.New("sighash flag has unknown bits set")
 	}
-
-	// CHECKSIGVERY is performed against signature to validate we have pushed the current transaction
-
-	s.AppendOpcodes(bscript.OpCHECKSIGVERIFY)
-
-	// drop preimage from the stack
-	s.AppendOpcodes(bscript.OpDROP)
-
-	return s, nil
+	switch sigHashFlag & sighash.Mask {
+	case sighash.All, sighash.None, sighash.Single:
+		return nil
+	}
+	return errors.New("sighash flag must be ALL, NONE or SINGLE")
 }
 
 // NewP2PKHUnlockingScript creates an unlocking script <sig> <pubkey> <preimage>
 
 func NewPushTxUnlockingScript(pubKey, preimage, sig []byte, sigHashFlag sighash.Flag) (*bscript.Script, error) {
+	return NewPushTxUnlockingScriptWithArgs(nil, pubKey, preimage, sig, sigHashFlag)
+}
+
+// NewPushTxUnlockingScriptWithArgs creates an unlocking script <args...> <sig> <pubkey> <preimage>
+// for contracts which take arguments before the signature
+
+func NewPushTxUnlockingScriptWithArgs(args [][]byte, pubKey, preimage, sig []byte, sigHashFlag sighash.Flag) (*bscript.Script, error) {
 	sigBuf := []byte{}
 	sigBuf = append(sigBuf, sig...)
 	sigBuf = append(sigBuf, uint8(sigHashFlag))
 
-	scriptBuf := [][]byte{sigBuf, pubKey}
+	var err error
 	s := &bscript.Script{}
-	err := s.AppendPushDataArray(scriptBuf)
-	if err != nil {
+	for _, arg := range args {
+		if s, err = AppendPushDataMinimal(s, arg); err != nil {
+			return nil, err
+		}
+	}
+
+	scriptBuf := [][]byte{sigBuf, pubKey}
+	if err = s.AppendPushDataArray(scriptBuf); err != nil {
 		return nil, err
 	}
 	if err = s.AppendPushData(preimage); err != nil {
@@ -152,103 +162,74 @@ func NewPushTxUnlockingScript(pubKey, preimage, sig []byte, sigHashFlag sighash.
 
 func AppendGetLockingScriptFromPreimage(s *bscript.Script) (*bscript.Script, error) {
 	//Assume Preimage is on top of the stack
-	var err error
+	b := NewBuilder(s)
 
 	// scriptCode begins at byte position 104
 	// push 104 to stack (hex 0x68)
-	if err = s.AppendPushDataHexString("68"); err != nil {
-		return nil, err
-	}
+	b.PushHex("68")
 
-	s.AppendOpcodes(bscript.OpBIN2NUM)
+	b.Opcodes(bscript.OpBIN2NUM)
 	// split preimage at position 104
-	s.AppendOpcodes(bscript.OpSPLIT)
+	b.Opcodes(bscript.OpSPLIT)
 
 	// duplicate to check script length
 	//s.AppendOpCode(bscript.OpDUP)
 	// script length is first byte of scriptCode
 	// split script length and push it to top of stack
 
-	s.AppendOpcodes(bscript.Op1)
-	s.AppendOpcodes(bscript.OpSPLIT)
-	s.AppendOpcodes(bscript.OpSWAP)
-	s.AppendOpcodes(bscript.OpDUP)
-	s.AppendOpcodes(bscript.OpDUP)
-	s.AppendOpcodes(bscript.OpDUP)
+	b.Opcodes(bscript.Op1, bscript.OpSPLIT, bscript.OpSWAP)
+	b.Opcodes(bscript.OpDUP, bscript.OpDUP, bscript.OpDUP)
 
 	//check for varInt size
-	if err = s.AppendPushDataHexString("ff"); err != nil {
-		return nil, err
-	}
+	b.PushHex("ff")
 	// if size is 5-8 bytes
-	s.AppendOpcodes(bscript.OpNUMEQUAL)
-	s.AppendOpcodes(bscript.OpIF)
-	s.AppendOpcodes(bscript.Op2DROP)
-	s.AppendOpcodes(bscript.OpDROP)
-	s.AppendOpcodes(bscript.Op8)
-	s.AppendOpcodes(bscript.OpSPLIT)
-	s.AppendOpcodes(bscript.OpSWAP)
+	b.Opcodes(bscript.OpNUMEQUAL, bscript.OpIF)
+	b.Opcodes(bscript.Op2DROP, bscript.OpDROP)
+	b.Opcodes(bscript.Op8, bscript.OpSPLIT, bscript.OpSWAP)
 
 	//if size is fe (3-4 bytes)
-	s.AppendOpcodes(bscript.OpELSE)
-	if err = s.AppendPushDataHexString("fe"); err != nil {
-		return nil, err
-	}
-	s.AppendOpcodes(bscript.OpNUMEQUAL)
-	s.AppendOpcodes(bscript.OpIF)
-	s.AppendOpcodes(bscript.Op2DROP)
-	s.AppendOpcodes(bscript.Op4)
-	s.AppendOpcodes(bscript.OpSPLIT)
-	s.AppendOpcodes(bscript.OpSWAP)
+	b.Opcodes(bscript.OpELSE)
+	b.PushHex("fe")
+	b.Opcodes(bscript.OpNUMEQUAL, bscript.OpIF)
+	b.Opcodes(bscript.Op2DROP)
+	b.Opcodes(bscript.Op4, bscript.OpSPLIT, bscript.OpSWAP)
 
 	//if size is fd
-	s.AppendOpcodes(bscript.OpELSE)
-	if err = s.AppendPushDataHexString("fd"); err != nil {
-		return nil, err
-	}
-	s.AppendOpcodes(bscript.OpNUMEQUAL)
-	s.AppendOpcodes(bscript.OpIF)
+	b.Opcodes(bscript.OpELSE)
+	b.PushHex("fd")
+	b.Opcodes(bscript.OpNUMEQUAL, bscript.OpIF)
 	// split next two bytes for varInt
-	s.AppendOpcodes(bscript.OpDROP)
-	s.AppendOpcodes(bscript.Op2)
-	s.AppendOpcodes(bscript.OpSPLIT)
-	s.AppendOpcodes(bscript.OpSWAP)
+	b.Opcodes(bscript.OpDROP)
+	b.Opcodes(bscript.Op2, bscript.OpSPLIT, bscript.OpSWAP)
 
 	// else size is one byte
-	s.AppendOpcodes(bscript.OpELSE)
-	if err = s.AppendPushDataHexString("00"); err != nil {
-		return nil, err
-	}
-	s.AppendOpcodes(bscript.OpCAT)
-	s.AppendOpcodes(bscript.OpENDIF)
-	s.AppendOpcodes(bscript.OpENDIF)
-	s.AppendOpcodes(bscript.OpENDIF)
+	b.Opcodes(bscript.OpELSE)
+	b.PushHex("00")
+	b.Opcodes(bscript.OpCAT)
+	b.Opcodes(bscript.OpENDIF, bscript.OpENDIF, bscript.OpENDIF)
 
-	s.AppendOpcodes(bscript.OpBIN2NUM)
-	s.AppendOpcodes(bscript.OpSPLIT)
+	b.Opcodes(bscript.OpBIN2NUM, bscript.OpSPLIT)
 
 	// drop the rest of preimage off the stack
-	s.AppendOpcodes(bscript.OpROT)
-	s.AppendOpcodes(bscript.Op2DROP)
+	b.Opcodes(bscript.OpROT, bscript.Op2DROP)
 
 	// leaves the locking script on the stack
 
-	return s, nil
+	return b.Script()
 }
 
 // AppendSplitPushTxFromLockingScript
 // Will split out the optimized push tx template from locking script, assuming it is the beginning of the script
 
-func SplitPushTxFromLockingScript(s *bscript.Script) *bscript.Script {
+func SplitPushTxFromLockingScript(s *bscript.Script) (*bscript.Script, error) {
 	// assuming locking script is on the top of the stack
 	// hex of pushtx = '0079aa517f7c818b7c7e263044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f8179802207c7e01417e2102b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0ad'
 
 	// SHA1 Hash = '4116009c9023cba646499e37b66874c7c1b1db1e'
 
-	// PushTx script is 89 bytes
-	//Split Locking script at 89 bytes (0x59)
-	s.AppendPushDataHexString("59")
-	s.AppendOpcodes(bscript.OpSWAP)
-
-	return s
+	// Split Locking script after the push tx script
+	return NewBuilder(s).
+		PushData(EncodeNumber(int64(pushTxLength))).
+		Opcodes(bscript.OpSWAP).
+		Script()
 }
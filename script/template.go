@@ -0,0 +1,392 @@
+package script
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/asm"
+)
+
+/*
+Script Templates
+----------------
+
+A template is a script with named parameters, each a single push. Templates are
+written in Go,
+
+	NewTemplate(Opcodes{bscript.OpDUP, bscript.OpHASH160}, Param{"pubKeyHash", ParamPubKeyHash}, Opcodes{...})
+
+or in ASM with typed placeholders, see the asm package,
+
+	OP_DUP OP_HASH160 <pubKeyHash:pubkeyhash> OP_EQUALVERIFY OP_CHECKSIG
+
+A template appends scripts given values for its parameters, and matches scripts
+to extract them. Parameters are pushed one way only, so a script matches only if
+appending the values it matched gives back the same bytes.
+*/
+
+// ParamType is the type of a template parameter, which decides its Go type in Values
+type ParamType int
+
+const (
+	// ParamBytes is data of any length, a []byte
+	ParamBytes ParamType = iota
+	// ParamPubKeyHash is a 20 byte hash, a []byte
+	ParamPubKeyHash
+	// ParamInt is a script number, an int64, pushed with AppendNumber
+	ParamInt
+	// ParamSigHash is a sighash flag checked by CheckSigHashFlag, a sighash.Flag
+	ParamSigHash
+)
+
+// String returns the name of the type in ASM placeholders. It is a switch rather than a map
+// so templates can be parsed while package variables are initialized
+func (t ParamType) String() string {
+	switch t {
+	case ParamBytes:
+		return "bytes"
+	case ParamPubKeyHash:
+		return "pubkeyhash"
+	case ParamInt:
+		return "int"
+	case ParamSigHash:
+		return "sighash"
+	}
+	return fmt.Sprintf("ParamType(%d)", int(t))
+}
+
+func (t ParamType) valid() bool {
+	return t >= ParamBytes && t <= ParamSigHash
+}
+
+// size returns the length of the push of a parameter of the type, or false if it varies
+func (t ParamType) size() (int, bool) {
+	switch t {
+	case ParamPubKeyHash:
+		return 21, true
+	case ParamSigHash:
+		return 2, true
+	}
+	return 0, false
+}
+
+// Values are the values of template parameters by name
+type Values map[string]interface{}
+
+// TemplateElement is an element of a template, Opcodes, Data or Param
+type TemplateElement interface {
+	appendTo(t *Template) error
+}
+
+// Opcodes are opcodes in a template
+type Opcodes []byte
+
+// Data is a fixed push in a template
+type Data []byte
+
+// Param is a named parameter of a template
+type Param struct {
+	Name string
+	Type ParamType
+}
+
+func (o Opcodes) appendTo(t *Template) error {
+	for _, op := range o {
+		if op >= bscript.OpDATA1 && op <= bscript.OpPUSHDATA4 {
+			return fmt.Errorf("push opcode %x in template opcodes, use Data", op)
+		}
+	}
+	t.appendFixed(o)
+	return nil
+}
+
+func (d Data) appendTo(t *Template) error {
+	s := &bscript.Script{}
+	if err := s.AppendPushData(d); err != nil {
+		return err
+	}
+	t.appendFixed(*s)
+	return nil
+}
+
+func (p Param) appendTo(t *Template) error {
+	if p.Name == "" {
+		return errors.New("template parameter has no name")
+	}
+	if !p.Type.valid() {
+		return fmt.Errorf("template parameter %s has unknown type %v", p.Name, p.Type)
+	}
+	for _, q := range t.Params() {
+		if q.Name == p.Name {
+			return fmt.Errorf("template parameter %s is repeated", p.Name)
+		}
+	}
+	param := p
+	t.parts = append(t.parts, templatePart{param: &param})
+	return nil
+}
+
+// Template is a script with named parameters
+type Template struct {
+	parts []templatePart
+}
+
+// templatePart is either fixed script bytes or a parameter
+type templatePart struct {
+	fixed []byte
+	param *Param
+}
+
+func (t *Template) appendFixed(b []byte) {
+	if n := len(t.parts); n > 0 && t.parts[n-1].param == nil {
+		t.parts[n-1].fixed = append(t.parts[n-1].fixed, b...)
+		return
+	}
+	t.parts = append(t.parts, templatePart{fixed: append([]byte{}, b...)})
+}
+
+// NewTemplate returns the template of the elements
+func NewTemplate(elements ...TemplateElement) (*Template, error) {
+	t := &Template{}
+	for _, e := range elements {
+		if err := e.appendTo(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// MustNewTemplate is NewTemplate panicking on error, for templates defined at init
+func MustNewTemplate(elements ...TemplateElement) *Template {
+	t, err := NewTemplate(elements...)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseTemplate returns the template of ASM with placeholders <name:type>, where type is
+// bytes, pubkeyhash, int or sighash. The push tx labels of the asm package may be used
+func ParseTemplate(text string) (*Template, error) {
+	types := map[string]ParamType{}
+	for t := ParamBytes; t.valid(); t++ {
+		types[t.String()] = t
+	}
+	var elements []TemplateElement
+	for _, field := range asm.Fields(text) {
+		if strings.HasPrefix(field, "<") && strings.HasSuffix(field, ">") {
+			i := strings.LastIndexByte(field, ':')
+			if i < 0 {
+				return nil, fmt.Errorf("placeholder %s has no type", field)
+			}
+			paramType, ok := types[field[i+1:len(field)-1]]
+			if !ok {
+				return nil, fmt.Errorf("placeholder %s has unknown type", field)
+			}
+			elements = append(elements, Param{Name: field[1:i], Type: paramType})
+			continue
+		}
+		s, err := asm.Assemble(field, nil)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, rawScript(*s))
+	}
+	return NewTemplate(elements...)
+}
+
+// rawScript is assembled script bytes in a template
+type rawScript []byte
+
+func (r rawScript) appendTo(t *Template) error {
+	t.appendFixed(r)
+	return nil
+}
+
+// MustParseTemplate is ParseTemplate panicking on error, for templates defined at init
+func MustParseTemplate(text string) *Template {
+	t, err := ParseTemplate(text)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Params returns the parameters of the template in order
+func (t *Template) Params() []Param {
+	var params []Param
+	for _, part := range t.parts {
+		if part.param != nil {
+			params = append(params, *part.param)
+		}
+	}
+	return params
+}
+
+// Length returns the length of every script of the template, or false if it depends on the values
+func (t *Template) Length() (int, bool) {
+	n := 0
+	for _, part := range t.parts {
+		if part.param == nil {
+			n += len(part.fixed)
+			continue
+		}
+		size, ok := part.param.Type.size()
+		if !ok {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+// Append appends the template with the values of its parameters
+func (t *Template) Append(s *bscript.Script, values Values) (*bscript.Script, error) {
+	for _, part := range t.parts {
+		if part.param == nil {
+			*s = append(*s, part.fixed...)
+			continue
+		}
+		v, ok := values[part.param.Name]
+		if !ok {
+			return nil, fmt.Errorf("no value for template parameter %s", part.param.Name)
+		}
+		if err := appendParam(s, *part.param, v); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Match returns the values of the parameters if the script is the template
+func (t *Template) Match(s *bscript.Script) (Values, bool) {
+	values, n, ok := t.MatchPrefix(s)
+	if !ok || n != len(*s) {
+		return nil, false
+	}
+	return values, true
+}
+
+// MatchPrefix returns the values of the parameters if the script starts with the template,
+// and the length of the script matched
+func (t *Template) MatchPrefix(s *bscript.Script) (Values, int, bool) {
+	b := []byte(*s)
+	values := Values{}
+	i := 0
+	for _, part := range t.parts {
+		if part.param == nil {
+			if !bytes.HasPrefix(b[i:], part.fixed) {
+				return nil, 0, false
+			}
+			i += len(part.fixed)
+			continue
+		}
+		if i >= len(b) {
+			return nil, 0, false
+		}
+		op, next, err := asm.DecodeOp(b, i)
+		if err != nil {
+			return nil, 0, false
+		}
+		v, err := decodeParam(op, *part.param)
+		if err != nil {
+			return nil, 0, false
+		}
+		// the value must be pushed the way Append pushes it
+		pushed := &bscript.Script{}
+		if err = appendParam(pushed, *part.param, v); err != nil || !bytes.Equal(*pushed, b[i:next]) {
+			return nil, 0, false
+		}
+		values[part.param.Name] = v
+		i = next
+	}
+	return values, i, true
+}
+
+func appendParam(s *bscript.Script, p Param, v interface{}) error {
+	switch p.Type {
+	case ParamBytes, ParamPubKeyHash:
+		b, ok := v.([]byte)
+		if !ok {
+			return fmt.Errorf("template parameter %s must be []byte", p.Name)
+		}
+		if p.Type == ParamPubKeyHash && len(b) != 20 {
+			return fmt.Errorf("template parameter %s must be 20 bytes", p.Name)
+		}
+		return s.AppendPushData(b)
+	case ParamInt:
+		var n int64
+		switch v := v.(type) {
+		case int64:
+			n = v
+		case int:
+			n = int64(v)
+		default:
+			return fmt.Errorf("template parameter %s must be int64", p.Name)
+		}
+		_, err := AppendNumber(s, n)
+		return err
+	case ParamSigHash:
+		flag, ok := v.(sighash.Flag)
+		if !ok {
+			return fmt.Errorf("template parameter %s must be sighash.Flag", p.Name)
+		}
+		if err := CheckSigHashFlag(flag); err != nil {
+			return err
+		}
+		return s.AppendPushData([]byte{byte(flag)})
+	}
+	return fmt.Errorf("template parameter %s has unknown type %v", p.Name, p.Type)
+}
+
+// decodeParam returns the value of a parameter pushed by op, which appendParam may not push the same way
+func decodeParam(op asm.Op, p Param) (interface{}, error) {
+	isPush := op.Opcode <= bscript.OpPUSHDATA4
+	switch p.Type {
+	case ParamBytes, ParamPubKeyHash:
+		if !isPush {
+			return nil, errors.New("not a push")
+		}
+		// copy so the values don't alias the script
+		return append([]byte{}, op.Data...), nil
+	case ParamInt:
+		switch {
+		case op.Opcode == bscript.Op1NEGATE:
+			return int64(-1), nil
+		case op.Opcode >= bscript.Op1 && op.Opcode <= bscript.Op16:
+			return int64(op.Opcode-bscript.Op1) + 1, nil
+		case isPush:
+			return DecodeNumber(op.Data)
+		}
+		return nil, errors.New("not a number")
+	case ParamSigHash:
+		if !isPush || len(op.Data) != 1 {
+			return nil, errors.New("not a sighash flag")
+		}
+		return sighash.Flag(op.Data[0]), nil
+	}
+	return nil, fmt.Errorf("unknown type %v", p.Type)
+}
+
+// DecodeNumber returns the script number of little endian sign-magnitude bytes, of at most 8 bytes
+func DecodeNumber(b []byte) (int64, error) {
+	if len(b) > 8 {
+		return 0, errors.New("script number is longer than 8 bytes")
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	var n uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	signBit := uint64(0x80) << (8 * uint(len(b)-1))
+	if n&signBit != 0 {
+		return -int64(n &^ signBit), nil
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,191 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+func TestTemplate(t *testing.T) {
+	t.Parallel()
+	template, err := ParseTemplate(`
+		# pay to a hash after a time, or back to the owner
+		OP_IF <lockTime:int> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_SHA256 <hash:bytes> OP_EQUAL
+		OP_ELSE OP_DUP OP_HASH160 <owner:pubkeyhash> OP_EQUALVERIFY OP_CHECKSIG OP_ENDIF
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedParams := []Param{{"lockTime", ParamInt}, {"hash", ParamBytes}, {"owner", ParamPubKeyHash}}
+	if !reflect.DeepEqual(template.Params(), expectedParams) {
+		t.Errorf("expected params %v, got %v", expectedParams, template.Params())
+	}
+	if _, ok := template.Length(); ok {
+		t.Error("expected a template with int and bytes parameters to have no fixed length")
+	}
+
+	var tests = []struct {
+		name   string
+		values Values
+	}{
+		{"small int", Values{"lockTime": int64(16), "hash": bytes.Repeat([]byte{1}, 32), "owner": bytes.Repeat([]byte{2}, 20)}},
+		{"negative int", Values{"lockTime": int64(-1), "hash": []byte{}, "owner": bytes.Repeat([]byte{2}, 20)}},
+		{"large int", Values{"lockTime": int64(500000000), "hash": bytes.Repeat([]byte{3}, 300), "owner": bytes.Repeat([]byte{4}, 20)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := template.Append(&bscript.Script{}, test.values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values, ok := template.Match(s)
+			if !ok {
+				t.Fatalf("%s failed: expected %x to match", test.name, *s)
+			}
+			if !reflect.DeepEqual(values, test.values) {
+				t.Errorf("%s failed: expected values %v, got %v", test.name, test.values, values)
+			}
+
+			// a script with more after the template matches as a prefix only
+			longer := append(append(bscript.Script{}, *s...), bscript.OpDROP)
+			if _, ok = template.Match(&longer); ok {
+				t.Errorf("%s failed: expected a longer script not to match", test.name)
+			}
+			if _, n, ok := template.MatchPrefix(&longer); !ok || n != len(*s) {
+				t.Errorf("%s failed: expected prefix of %d bytes to match, got %d %v", test.name, len(*s), n, ok)
+			}
+		})
+	}
+
+	// values must be the parameter types
+	for _, values := range []Values{
+		{"lockTime": 1, "hash": []byte{}},
+		{"lockTime": "1", "hash": []byte{}, "owner": bytes.Repeat([]byte{2}, 20)},
+		{"lockTime": 1, "hash": []byte{}, "owner": []byte{2}},
+	} {
+		if _, err = template.Append(&bscript.Script{}, values); err == nil {
+			t.Errorf("expected values %v to be rejected", values)
+		}
+	}
+}
+
+func TestTemplateMatchIsCanonical(t *testing.T) {
+	t.Parallel()
+	template := MustNewTemplate(Param{Name: "n", Type: ParamInt}, Opcodes{bscript.OpADD})
+	for _, h := range []string{
+		"0193",       // 1 pushed as data instead of OP_1
+		"020100",     // 1 with a redundant zero byte
+		"4c02e80393", // 1000 pushed with OP_PUSHDATA1
+		"93",         // missing parameter
+		"01",         // truncated push
+	} {
+		s, err := bscript.NewFromHexString(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := template.Match(s); ok {
+			t.Errorf("expected %s not to match", h)
+		}
+	}
+	s, err := bscript.NewFromHexString("02e80393")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values, ok := template.Match(s); !ok || values["n"] != int64(1000) {
+		t.Errorf("expected 1000 to match, got %v", values)
+	}
+}
+
+func TestNewTemplateInvalid(t *testing.T) {
+	t.Parallel()
+	for name, elements := range map[string][]TemplateElement{
+		"push opcode":    {Opcodes{bscript.OpDATA1}},
+		"unnamed":        {Param{Type: ParamBytes}},
+		"unknown type":   {Param{Name: "x", Type: ParamType(99)}},
+		"repeated param": {Param{Name: "x", Type: ParamBytes}, Param{Name: "x", Type: ParamInt}},
+	} {
+		if _, err := NewTemplate(elements...); err == nil {
+			t.Errorf("expected %s to be rejected", name)
+		}
+	}
+	for _, text := range []string{"<x>", "<x:float>", "OP_NOTANOPCODE", "<x:int> <x:int>"} {
+		if _, err := ParseTemplate(text); err == nil {
+			t.Errorf("expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestPushTxTemplate(t *testing.T) {
+	t.Parallel()
+	if length, ok := PushTxVerifyTemplate.Length(); !ok || length != 0x59 {
+		t.Errorf("expected push tx to be 89 bytes, got %d", length)
+	}
+	_, address := testutil.NewKey(t)
+	for _, flag := range []sighash.Flag{sighash.AllForkID, sighash.SingleForkID | sighash.AnyOneCanPay} {
+		s, err := AppendPushTxWithSigHash(&bscript.Script{}, flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, err = AppendP2PKH(s, address); err != nil {
+			t.Fatal(err)
+		}
+		if !IsOpPushTx(s) {
+			t.Errorf("expected push tx with flag %x to be push tx", flag)
+		}
+		if f, ok := PushTxSigHash(s); !ok || f != flag {
+			t.Errorf("expected flag %x, got %x", flag, f)
+		}
+	}
+
+	// the bytes are unchanged from the hand written script
+	expected := "0079aa517f7c818b7c7e263044022079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f8179802207c7e01417e2102b405d7f0322a89d0f9f3a98e6f938fdc1c969a8d1382a2bf66a71ae74a1e83b0ad"
+	s, err := AppendPushTxVerify(&bscript.Script{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(*s) != expected {
+		t.Errorf("expected %s, got %x", expected, *s)
+	}
+
+	p2pkh, err := bscript.NewP2PKHFromAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidFlag := append(bscript.Script{}, *s...)
+	invalidFlag[52] = byte(sighash.All)
+	for _, s := range []*bscript.Script{p2pkh, &bscript.Script{}, &invalidFlag} {
+		if IsOpPushTx(s) {
+			t.Errorf("expected %x not to be push tx", *s)
+		}
+	}
+}
+
+func TestAppendCheckSigPKH(t *testing.T) {
+	t.Parallel()
+	pkh := bytes.Repeat([]byte{0xab}, 20)
+	var tests = []struct {
+		name     string
+		append   func(s *bscript.Script, pkh []byte) (*bscript.Script, error)
+		expected string
+	}{
+		{"check sig", AppendCheckSigPKH, "76a914" + hex.EncodeToString(pkh) + "88ac"},
+		{"check sig verify", AppendCheckSigPKHVerify, "76a914" + hex.EncodeToString(pkh) + "88ad"},
+	}
+	for _, test := range tests {
+		s, err := test.append(&bscript.Script{}, pkh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(*s) != test.expected {
+			t.Errorf("%s failed: expected %s, got %x", test.name, test.expected, *s)
+		}
+		if _, err = test.append(&bscript.Script{}, pkh[:19]); err == nil {
+			t.Errorf("%s failed: expected a 19 byte public key hash to be rejected", test.name)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+package pushtx
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+/*
+Owner Conditions of an OpPush Transaction
+-----------------------------------------
+
+P2PKH: <sig> <pubKey> <preimage>
+Locking Script: <Optimized OP_PUSH_TX> OP_DROP <P2PKH>
+
+Multisig: OP_0 <sig>... <preimage>
+Locking Script: <Optimized OP_PUSH_TX> OP_DROP <m> <pubKey>... <n> OP_CHECKMULTISIG
+
+P2PKH or Timeout: <sig> <pubKey> <1 for the owner, 0 for the refund> <preimage>
+Locking Script: <Optimized OP_PUSH_TX> <P2PKH or check nLocktime and refund P2PKH>
+
+Hash Puzzle: <secret> <preimage>
+Locking Script: <Optimized OP_PUSH_TX> OP_DROP OP_SHA256 <hash> OP_EQUAL
+
+R Puzzle: <sig with r of k> <pubKey> <preimage>
+Locking Script: <Optimized OP_PUSH_TX> OP_DROP <split r from sig> OP_HASH160 <rHash> OP_EQUALVERIFY OP_CHECKSIG
+*/
+
+// Owner is the condition appended after the push tx check of an output
+type Owner interface {
+	// AppendOwner assumes the verified preimage on top of the stack
+	AppendOwner(s *bscript.Script) (*bscript.Script, error)
+}
+
+// P2PKHOwner can spend with a signature of Address
+type P2PKHOwner struct {
+	Address string
+}
+
+// AppendOwner implements Owner
+func (o *P2PKHOwner) AppendOwner(s *bscript.Script) (*bscript.Script, error) {
+	return script.NewBuilder(s).
+		Opcodes(bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendP2PKH(s, o.Address)
+		}).
+		Script()
+}
+
+// MultisigOwner can spend with signatures of M of the PubKeys
+type MultisigOwner struct {
+	M       int
+	PubKeys []*bec.PublicKey
+}
+
+// AppendOwner implements Owner
+func (o *MultisigOwner) AppendOwner(s *bscript.Script) (*bscript.Script, error) {
+	pubKeys := make([][]byte, len(o.PubKeys))
+	for i, pubKey := range o.PubKeys {
+		pubKeys[i] = pubKey.SerialiseCompressed()
+	}
+	return script.NewBuilder(s).
+		Opcodes(bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendMultisig(s, o.M, pubKeys)
+		}).
+		Script()
+}
+
+// TimeoutOwner can spend with a signature of Address at any time,
+// or with a signature of RefundAddress once LockTime is reached
+type TimeoutOwner struct {
+	Address       string
+	RefundAddress string
+	LockTime      uint32
+}
+
+// AppendOwner implements Owner
+func (o *TimeoutOwner) AppendOwner(s *bscript.Script) (*bscript.Script, error) {
+	return script.AppendP2PKHOrTimeout(s, o.Address, o.RefundAddress, o.LockTime)
+}
+
+// HashPuzzleOwner can spend with the secret whose SHA256 is Hash
+type HashPuzzleOwner struct {
+	Hash []byte
+}
+
+// AppendOwner implements Owner
+func (o *HashPuzzleOwner) AppendOwner(s *bscript.Script) (*bscript.Script, error) {
+	return script.NewBuilder(s).
+		Opcodes(bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendHashPuzzle(s, o.Hash)
+		}).
+		Script()
+}
+
+// RPuzzleOwner can spend by signing with the k whose r has the HASH160 RHash, see RPuzzleHash
+type RPuzzleOwner struct {
+	RHash []byte
+}
+
+// AppendOwner implements Owner
+func (o *RPuzzleOwner) AppendOwner(s *bscript.Script) (*bscript.Script, error) {
+	return script.NewBuilder(s).
+		Opcodes(bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendRPuzzle(s, o.RHash)
+		}).
+		Script()
+}
+
+// AddOpPushTransactionOutputWithOwner adds a push tx output spendable under the owner condition
+func AddOpPushTransactionOutputWithOwner(tx *bt.Tx, owner Owner, satoshis uint64) (*bt.Tx, error) {
+	lockingScript, err := NewOwnerLockingScript(owner)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{
+		Satoshis:      satoshis,
+		LockingScript: lockingScript,
+	})
+	return tx, nil
+}
+
+// AddOpPushTransactionOutputWithSigHash adds a push tx output spendable under the owner condition
+// with the sighash flag only, e.g. SINGLE | ANYONECANPAY lets others add inputs and outputs
+func AddOpPushTransactionOutputWithSigHash(tx *bt.Tx, owner Owner, satoshis uint64, sigHashFlag sighash.Flag) (*bt.Tx, error) {
+	lockingScript, err := NewOwnerLockingScriptWithSigHash(owner, sigHashFlag)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{
+		Satoshis:      satoshis,
+		LockingScript: lockingScript,
+	})
+	return tx, nil
+}
+
+// NewOwnerLockingScript returns the locking script of a push tx output with the owner condition
+func NewOwnerLockingScript(owner Owner) (*bscript.Script, error) {
+	return NewOwnerLockingScriptWithSigHash(owner, sighash.AllForkID)
+}
+
+// NewOwnerLockingScriptWithSigHash returns the locking script of a push tx output with the owner condition
+// spendable with the sighash flag only
+func NewOwnerLockingScriptWithSigHash(owner Owner, sigHashFlag sighash.Flag) (*bscript.Script, error) {
+	var err error
+	s := &bscript.Script{}
+	if s, err = script.AppendPushTxVerifyWithSigHash(s, sigHashFlag); err != nil {
+		return nil, err
+	}
+	return owner.AppendOwner(s)
+}
+
+// ParseOwner returns the owner condition of a push tx locking script of any sighash flag,
+// see script.PushTxSigHash for the flag. Addresses are returned for mainnet
+func ParseOwner(lockingScript *bscript.Script) (Owner, error) {
+	sigHashFlag, ok := script.PushTxSigHash(lockingScript)
+	if !ok {
+		return nil, errors.New("locking script is not push tx")
+	}
+	prefix, err := script.AppendPushTxVerifyWithSigHash(&bscript.Script{}, sigHashFlag)
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(*lockingScript)
+	parts, err := bscript.DecodeParts(b[len(*prefix):])
+	if err != nil || len(parts) < 4 {
+		return nil, errors.New("unknown owner condition")
+	}
+
+	var candidates []Owner
+	last := len(parts) - 1
+	switch {
+	case isOp(parts[0], bscript.OpDROP) && isOp(parts[last], bscript.OpCHECKSIG) && len(parts) == 6:
+		if address, err := mainnetAddress(parts[3]); err == nil {
+			candidates = append(candidates, &P2PKHOwner{Address: address})
+		}
+	case isOp(parts[0], bscript.OpDROP) && isOp(parts[1], bscript.OpOVER) && isOp(parts[last], bscript.OpCHECKSIG):
+		candidates = append(candidates, &RPuzzleOwner{RHash: parts[last-2]})
+	case isOp(parts[0], bscript.OpDROP) && isOp(parts[last], bscript.OpCHECKMULTISIG):
+		m, ok := smallInt(parts[1])
+		if !ok {
+			break
+		}
+		var pubKeys []*bec.PublicKey
+		for _, p := range parts[2 : last-1] {
+			pubKey, err := bec.ParsePubKey(p, bec.S256())
+			if err != nil {
+				break
+			}
+			pubKeys = append(pubKeys, pubKey)
+		}
+		candidates = append(candidates, &MultisigOwner{M: int(m), PubKeys: pubKeys})
+	case isOp(parts[0], bscript.OpDROP) && isOp(parts[last], bscript.OpEQUAL) && len(parts) == 4:
+		candidates = append(candidates, &HashPuzzleOwner{Hash: parts[2]})
+	case isOp(parts[0], bscript.OpSWAP) && isOp(parts[last], bscript.OpENDIF) && len(parts) > 10:
+		address, err := mainnetAddress(parts[5])
+		if err != nil {
+			break
+		}
+		refundAddress, err := mainnetAddress(parts[last-3])
+		if err != nil {
+			break
+		}
+		// a one byte part may be a small number opcode or the number itself
+		lockTimePart := parts[last-9]
+		if n, ok := smallInt(lockTimePart); ok {
+			candidates = append(candidates, &TimeoutOwner{Address: address, RefundAddress: refundAddress, LockTime: uint32(n)})
+		}
+		candidates = append(candidates, &TimeoutOwner{Address: address, RefundAddress: refundAddress, LockTime: uint32(decodeNumber(lockTimePart))})
+	}
+
+	for _, owner := range candidates {
+		template, err := NewOwnerLockingScriptWithSigHash(owner, sigHashFlag)
+		if err == nil && template.Equals(lockingScript) {
+			return owner, nil
+		}
+	}
+	return nil, errors.New("unknown owner condition")
+}
+
+func isOp(part []byte, op byte) bool {
+	return len(part) == 1 && part[0] == op
+}
+
+func smallInt(part []byte) (int64, bool) {
+	if len(part) == 1 && part[0] >= bscript.Op1 && part[0] <= bscript.Op16 {
+		return int64(part[0] - bscript.Op1 + 1), true
+	}
+	return 0, false
+}
+
+// decodeNumber decodes a little endian sign-magnitude script number
+func decodeNumber(b []byte) int64 {
+	if len(b) == 0 || len(b) > 8 {
+		return 0
+	}
+	var n int64
+	for i, v := range b {
+		n |= int64(v) << (8 * i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		n &^= int64(0x80) << (8 * (len(b) - 1))
+		return -n
+	}
+	return n
+}
+
+func mainnetAddress(pkh []byte) (string, error) {
+	if len(pkh) != 20 {
+		return "", errors.New("public key hash must be 20 bytes")
+	}
+	a, err := bscript.NewAddressFromPublicKeyHash(pkh, true)
+	if err != nil {
+		return "", err
+	}
+	return a.AddressString, nil
+}
+
+// UnlockOwner unlocks push tx outputs with a multisig, P2PKH or timeout, hash puzzle or R puzzle owner.
+// P2PKH or timeout signs with the first signer, taking the owner path if it matches the owner address.
+// R puzzle signs with K and a new key, as only r is checked
+type UnlockOwner struct {
+	Signers []Signer
+	Secret  []byte
+	K       *big.Int
+}
+
+// Implements the bt.Unlocker interface
+func (u *UnlockOwner) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	params.SigHashFlags = InputSigHashFlags(tx, params)
+	owner, err := ParseOwner(tx.Inputs[params.InputIdx].PreviousTxScript)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := owner.(*P2PKHOwner); ok {
+		if len(u.Signers) == 0 {
+			return nil, errors.New("P2PKH owner needs a signer")
+		}
+		unlocker := &UnlockPushTx{Signer: u.Signers[0]}
+		return unlocker.UnlockingScript(ctx, tx, params)
+	}
+
+	preimage, err := LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+	digest := crypto.Sha256d(preimage)
+
+	b := script.NewBuilder(&bscript.Script{})
+	switch o := owner.(type) {
+	case *MultisigOwner:
+		b.Opcodes(bscript.Op0)
+		pubKeys := make([]*bec.PublicKey, len(u.Signers))
+		for i, signer := range u.Signers {
+			if pubKeys[i], err = signer.PublicKey(ctx); err != nil {
+				return nil, err
+			}
+		}
+		signed := 0
+		for _, pubKey := range o.PubKeys {
+			if signed == o.M {
+				break
+			}
+			for i, signer := range u.Signers {
+				if !pubKeys[i].IsEqual(pubKey) {
+					continue
+				}
+				sig, err := signWith(ctx, signer, digest, params.SigHashFlags)
+				if err != nil {
+					return nil, err
+				}
+				b.PushData(sig)
+				signed++
+				break
+			}
+		}
+		if signed < o.M {
+			return nil, errors.New("not enough signers for the multisig owner")
+		}
+	case *TimeoutOwner:
+		if len(u.Signers) == 0 {
+			return nil, errors.New("P2PKH or timeout owner needs a signer")
+		}
+		pubKey, err := u.Signers[0].PublicKey(ctx)
+		if err != nil {
+			return nil, err
+		}
+		a, err := bscript.NewAddressFromString(o.Address)
+		if err != nil {
+			return nil, err
+		}
+		branch := int64(0)
+		if a.PublicKeyHash == hex.EncodeToString(crypto.Hash160(pubKey.SerialiseCompressed())) {
+			branch = 1
+		}
+		sig, err := signWith(ctx, u.Signers[0], digest, params.SigHashFlags)
+		if err != nil {
+			return nil, err
+		}
+		b.PushData(sig).PushData(pubKey.SerialiseCompressed()).Number(branch)
+	case *RPuzzleOwner:
+		if u.K == nil {
+			return nil, errors.New("R puzzle owner needs k")
+		}
+		k, err := bec.NewPrivateKey(bec.S256())
+		if err != nil {
+			return nil, err
+		}
+		sig, err := SignWithK(k, u.K, digest)
+		if err != nil {
+			return nil, err
+		}
+		sigBytes := append(sig.Serialise(), byte(params.SigHashFlags))
+		b.PushData(sigBytes).PushData(k.PubKey().SerialiseCompressed())
+	case *HashPuzzleOwner:
+		if u.Secret == nil {
+			return nil, errors.New("hash puzzle owner needs the secret")
+		}
+		b.Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendPushDataMinimal(s, u.Secret)
+		})
+	}
+	return b.PushData(preimage).Script()
+}
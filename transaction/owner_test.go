@@ -0,0 +1,212 @@
+package pushtx
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+func TestSpendOwner(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	bobKey, bob := testutil.NewKey(t)
+	carolKey, _ := testutil.NewKey(t)
+	malloryKey, _ := testutil.NewKey(t)
+	secret := []byte("open sesame")
+	hash := sha256.Sum256(secret)
+	multisig := &MultisigOwner{M: 2, PubKeys: []*bec.PublicKey{aliceKey.PubKey(), bobKey.PubKey(), carolKey.PubKey()}}
+	timeout := &TimeoutOwner{Address: alice, RefundAddress: bob, LockTime: 800000}
+	k, otherK := newK(t), newK(t)
+	rHash, err := RPuzzleHash(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rPuzzle := &RPuzzleOwner{RHash: rHash}
+
+	var tests = []struct {
+		name          string
+		owner         Owner
+		getter        *Getter
+		lockTime      uint32
+		expectedError bool
+	}{
+		{"p2pkh", &P2PKHOwner{Address: alice}, &Getter{PrivateKey: aliceKey}, 0, false},
+		{"multisig first and last", multisig, &Getter{PrivateKeys: []*bec.PrivateKey{carolKey, aliceKey}}, 0, false},
+		{"multisig wrong key", multisig, &Getter{PrivateKeys: []*bec.PrivateKey{aliceKey, malloryKey}}, 0, true},
+		{"timeout owner", timeout, &Getter{PrivateKey: aliceKey}, 0, false},
+		{"timeout refund", timeout, &Getter{PrivateKey: bobKey}, 800000, false},
+		{"timeout refund too early", timeout, &Getter{PrivateKey: bobKey}, 799000, true},
+		{"timeout stranger", timeout, &Getter{PrivateKey: malloryKey}, 800000, true},
+		{"hash puzzle", &HashPuzzleOwner{Hash: hash[:]}, ownerGetter(&Getter{}, secret, nil), 0, false},
+		{"hash puzzle wrong secret", &HashPuzzleOwner{Hash: hash[:]}, ownerGetter(&Getter{}, []byte("open barley"), nil), 0, true},
+		{"r puzzle", rPuzzle, ownerGetter(&Getter{}, nil, k), 0, false},
+		{"r puzzle wrong k", rPuzzle, ownerGetter(&Getter{}, nil, otherK), 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.NewFundingUTXO(t, alice, 100000)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := AddOpPushTransactionOutputWithOwner(tx, test.owner, 90000); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.FillAllInputs(context.Background(), &Getter{PrivateKey: aliceKey}); err != nil {
+				t.Fatal(err)
+			}
+
+			owner, err := ParseOwner(tx.Outputs[0].LockingScript)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(owner, test.owner) {
+				t.Errorf("%s failed: expected parsed owner %+v, got %+v", test.name, test.owner, owner)
+			}
+
+			spend := bt.NewTx()
+			if err = spend.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+				t.Fatal(err)
+			}
+			if err = spend.PayToAddress(bob, 89000); err != nil {
+				t.Fatal(err)
+			}
+			if test.lockTime > 0 {
+				SetLockTime(spend, test.lockTime)
+			}
+			err = spend.FillAllInputs(context.Background(), test.getter)
+			if err == nil {
+				err = testutil.Verify(spend)
+			}
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected owner condition to reject the spend", test.name)
+			}
+		})
+	}
+}
+
+// TestRPuzzleNeverUsesGetterKey checks the R puzzle signature is made with a new key every time,
+// as signing with k and the key of the Getter would reveal the key to anyone who knows k
+func TestRPuzzleNeverUsesGetterKey(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	k := newK(t)
+	rHash, err := RPuzzleHash(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(testutil.NewFundingUTXO(t, address, 100000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = AddOpPushTransactionOutputWithOwner(tx, &RPuzzleOwner{RHash: rHash}, 90000); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.FillAllInputs(context.Background(), &Getter{PrivateKey: privateKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	var unlocking [][]byte
+	for i := 0; i < 2; i++ {
+		spend := bt.NewTx()
+		if err = spend.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err = spend.PayToAddress(address, uint64(89000-i)); err != nil {
+			t.Fatal(err)
+		}
+		if err = spend.FillAllInputs(context.Background(), ownerGetter(&Getter{PrivateKey: privateKey}, nil, k)); err != nil {
+			t.Fatal(err)
+		}
+		if err = testutil.Verify(spend); err != nil {
+			t.Fatalf("r puzzle spend failed verification: %v", err)
+		}
+		parts, err := bscript.DecodeParts(*spend.Inputs[0].UnlockingScript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, part := range parts {
+			if bytes.Equal(part, privateKey.PubKey().SerialiseCompressed()) {
+				t.Fatal("expected the key of the Getter not to sign the R puzzle")
+			}
+		}
+		unlocking = append(unlocking, *spend.Inputs[0].UnlockingScript)
+	}
+	// unlocking scripts are <sig> <pubKey> <preimage>
+	first, _ := bscript.DecodeParts(unlocking[0])
+	second, _ := bscript.DecodeParts(unlocking[1])
+	if bytes.Equal(first[1], second[1]) {
+		t.Error("expected a new key for every R puzzle signature")
+	}
+}
+
+func newK(t *testing.T) *big.Int {
+	t.Helper()
+	k, err := rand.Int(rand.Reader, new(big.Int).Sub(bec.S256().N, big.NewInt(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.Add(k, big.NewInt(1))
+}
+
+func TestSignWithK(t *testing.T) {
+	t.Parallel()
+	privateKey, _ := testutil.NewKey(t)
+	digest := sha256.Sum256([]byte("digest"))
+	for i := 0; i < 20; i++ {
+		k := newK(t)
+		sig, err := SignWithK(privateKey, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sig.Verify(digest[:], privateKey.PubKey()) {
+			t.Fatal("expected signature made with k to verify")
+		}
+		r, err := RPuzzleR(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// DER signature: 0x30 len 0x02 rlen r ...
+		der := sig.Serialise()
+		if string(der[4:4+der[3]]) != string(r) {
+			t.Errorf("expected r %x in signature %x", r, der)
+		}
+	}
+}
+
+func TestParseOwnerSmallLockTime(t *testing.T) {
+	t.Parallel()
+	_, alice := testutil.NewKey(t)
+	_, bob := testutil.NewKey(t)
+	for _, lockTime := range []uint32{1, 16, 17, 81, 96, 128, 500000001} {
+		owner := &TimeoutOwner{Address: alice, RefundAddress: bob, LockTime: lockTime}
+		lockingScript, err := NewOwnerLockingScript(owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseOwner(lockingScript)
+		if err != nil {
+			t.Fatalf("lock time %d failed: %v", lockTime, err)
+		}
+		if !reflect.DeepEqual(parsed, owner) {
+			t.Errorf("lock time %d failed: got %+v", lockTime, parsed)
+		}
+	}
+}
+
+// ownerGetter sets the registry of the Getter to unlock hash and R puzzle owners with the secret and k
+func ownerGetter(g *Getter, secret []byte, k *big.Int) *Getter {
+	g.Registry = NewRegistry(append(DefaultRegistrations(), NewOwnerRegistration(secret, k))...)
+	return g
+}
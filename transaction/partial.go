@@ -0,0 +1,622 @@
+package pushtx
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/bscript/interpreter"
+	"github.com/libsv/go-bt/v2/sighash"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+/*
+Partially Signed Transaction
+----------------------------
+
+Binary: "pstx" 0xff <version> <varint length> <tx> <0, or 1 and the 4 byte locked nLocktime> <varint count> <input>...
+
+Input: <varint length> <previous locking script> <8 byte previous satoshis> <4 byte sighash flags>
+<varint length> <unlocker> <varint count> (<varint length> <public key> <varint length> <signature>)...
+
+JSON carries the same fields with bytes as hex. Numbers are little endian.
+
+The push tx preimages commit to nLocktime, so it is chosen once by LockLowS for all push tx inputs
+and locked. After that the inputs and outputs must not change, so every party signs the same preimages.
+*/
+
+var partialTxMagic = []byte{'p', 's', 't', 'x', 0xff}
+
+const partialTxVersion = 1
+
+// PartialSig is a signature collected for a multisig owner, with the sighash flag appended
+type PartialSig struct {
+	PubKey    []byte
+	Signature []byte
+}
+
+// PartialInput carries what is needed to sign an input of a PartialTx
+type PartialInput struct {
+	PreviousTxScript   *bscript.Script
+	PreviousTxSatoshis uint64
+	// Unlocker is the name of the registration expected to unlock the input
+	Unlocker     string
+	SigHashFlags sighash.Flag
+	// Signatures are collected for multisig owners until Finalize
+	Signatures []PartialSig
+}
+
+// PartialTx is an unsigned or partially signed transaction passed between parties
+type PartialTx struct {
+	Tx     *bt.Tx
+	Inputs []*PartialInput
+	// LockTime is the nLocktime locked by LockLowS, nil until then
+	LockTime *uint32
+}
+
+// NewPartialTx returns a partial transaction of a copy of tx, whose inputs must have their previous outputs.
+// Unlockers are named from the registry, DefaultRegistry if it is nil
+func NewPartialTx(tx *bt.Tx, registry *Registry) (*PartialTx, error) {
+	if registry == nil {
+		registry = DefaultRegistry()
+	}
+	p := &PartialTx{Tx: tx.Clone()}
+	for i, in := range p.Tx.Inputs {
+		if in.PreviousTxScript == nil {
+			return nil, fmt.Errorf("input %d is missing its previous output", i)
+		}
+		reg, ok := registry.Match(in.PreviousTxScript)
+		if !ok {
+			return nil, fmt.Errorf("input %d has no unlocker registered", i)
+		}
+		p.Inputs = append(p.Inputs, &PartialInput{
+			PreviousTxScript:   in.PreviousTxScript,
+			PreviousTxSatoshis: in.PreviousTxSatoshis,
+			Unlocker:           reg.Name,
+			SigHashFlags:       InputSigHashFlags(tx, bt.UnlockerParams{InputIdx: uint32(i)}),
+		})
+	}
+	return p, nil
+}
+
+// LockLowS finds an nLocktime for which the preimages of all push tx inputs are low s, and locks it.
+// It must be called before any input is signed, as signatures commit to nLocktime
+func (p *PartialTx) LockLowS(ctx context.Context) error {
+	if p.LockTime != nil {
+		return errors.New("nLocktime is already locked")
+	}
+	var pushTxInputs []uint32
+	for i, in := range p.Inputs {
+		if s := p.Tx.Inputs[i].UnlockingScript; s != nil && len(*s) > 0 {
+			return errors.New("nLocktime must be locked before inputs are signed")
+		}
+		if _, ok := script.PushTxSigHash(in.PreviousTxScript); ok {
+			pushTxInputs = append(pushTxInputs, uint32(i))
+		}
+	}
+	if err := LowSLockTime(ctx, p.Tx, pushTxInputs, sighash.AllForkID); err != nil {
+		return err
+	}
+	lockTime := p.Tx.LockTime
+	p.LockTime = &lockTime
+	return nil
+}
+
+// Sign unlocks the inputs the Getter can sign. P2PKH inputs, and push tx inputs with a P2PKH owner, are
+// signed by the signer of their address and skipped if there is none. Multisig owners collect a signature
+// from each signer of the owner until Finalize. Other inputs are unlocked by the Getter's registry
+func (p *PartialTx) Sign(ctx context.Context, g *Getter) error {
+	signers := g.signers()
+	pubKeys := make([]*bec.PublicKey, len(signers))
+	for i, signer := range signers {
+		var err error
+		if pubKeys[i], err = signer.PublicKey(ctx); err != nil {
+			return err
+		}
+	}
+	signerOf := func(pkh []byte) Signer {
+		for i, pubKey := range pubKeys {
+			if bytes.Equal(crypto.Hash160(pubKey.SerialiseCompressed()), pkh) {
+				return signers[i]
+			}
+		}
+		return nil
+	}
+
+	for i, in := range p.Inputs {
+		txIn := p.Tx.Inputs[i]
+		if txIn.UnlockingScript != nil && len(*txIn.UnlockingScript) > 0 {
+			continue
+		}
+		_, isPushTx := script.PushTxSigHash(in.PreviousTxScript)
+		if isPushTx && p.LockTime == nil {
+			return errors.New("nLocktime must be locked with LockLowS before signing push tx inputs")
+		}
+		var owner Owner
+		if isPushTx {
+			owner, _ = ParseOwner(in.PreviousTxScript)
+		}
+
+		var unlocker bt.Unlocker
+		switch o := owner.(type) {
+		case *MultisigOwner:
+			if err := p.signMultisig(ctx, i, o, signers, pubKeys); err != nil {
+				return err
+			}
+			continue
+		case *P2PKHOwner:
+			signer := signerOf(addressPKH(o.Address))
+			if signer == nil {
+				continue
+			}
+			unlocker = &UnlockPushTx{Signer: signer}
+		default:
+			if in.PreviousTxScript.IsP2PKH() {
+				signer := signerOf((*in.PreviousTxScript)[3:23])
+				if signer == nil {
+					continue
+				}
+				unlocker = &UnlockP2PKH{Signer: signer}
+				break
+			}
+			reg, ok := g.registry().Match(in.PreviousTxScript)
+			if !ok || reg.Name != in.Unlocker {
+				return fmt.Errorf("input %d expects unlocker %s", i, in.Unlocker)
+			}
+			var err error
+			if unlocker, err = reg.Unlocker(ctx, g, in.PreviousTxScript); err != nil {
+				return err
+			}
+		}
+
+		s, err := unlocker.UnlockingScript(ctx, p.Tx, bt.UnlockerParams{InputIdx: uint32(i), SigHashFlags: in.SigHashFlags})
+		if err != nil {
+			return err
+		}
+		if p.LockTime != nil && p.Tx.LockTime != *p.LockTime {
+			return errors.New("inputs or outputs changed after nLocktime was locked")
+		}
+		txIn.UnlockingScript = s
+	}
+	return nil
+}
+
+// signMultisig adds the signatures of the signers of the multisig owner of input i
+func (p *PartialTx) signMultisig(ctx context.Context, i int, o *MultisigOwner, signers []Signer, pubKeys []*bec.PublicKey) error {
+	digest, err := p.digest(i)
+	if err != nil {
+		return err
+	}
+	in := p.Inputs[i]
+	for j, signer := range signers {
+		pubKey := pubKeys[j].SerialiseCompressed()
+		if !o.hasPubKey(pubKey) || in.signature(pubKey) != nil {
+			continue
+		}
+		sig, err := signWith(ctx, signer, digest, in.SigHashFlags)
+		if err != nil {
+			return err
+		}
+		in.Signatures = append(in.Signatures, PartialSig{PubKey: pubKey, Signature: sig})
+	}
+	return nil
+}
+
+// digest returns the digest signed for push tx input i, failing if the preimage is not low s
+func (p *PartialTx) digest(i int) ([]byte, error) {
+	preimage, err := p.Tx.CalcInputPreimage(uint32(i), p.Inputs[i].SigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+	if !pushtxpreimage.IsLowS(preimage) {
+		return nil, errors.New("inputs or outputs changed after nLocktime was locked")
+	}
+	return crypto.Sha256d(preimage), nil
+}
+
+func (o *MultisigOwner) hasPubKey(pubKey []byte) bool {
+	for _, k := range o.PubKeys {
+		if bytes.Equal(k.SerialiseCompressed(), pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (in *PartialInput) signature(pubKey []byte) []byte {
+	for _, sig := range in.Signatures {
+		if bytes.Equal(sig.PubKey, pubKey) {
+			return sig.Signature
+		}
+	}
+	return nil
+}
+
+func addressPKH(address string) []byte {
+	a, err := bscript.NewAddressFromString(address)
+	if err != nil {
+		return nil
+	}
+	pkh, _ := hex.DecodeString(a.PublicKeyHash)
+	return pkh
+}
+
+// Finalize returns the signed transaction, building the unlocking scripts of multisig owners from
+// their collected signatures. It fails if any input is not unlocked
+func (p *PartialTx) Finalize() (*bt.Tx, error) {
+	if p.LockTime != nil && p.Tx.LockTime != *p.LockTime {
+		return nil, errors.New("nLocktime does not match the locked nLocktime")
+	}
+	tx := p.Tx.Clone()
+	for i, in := range p.Inputs {
+		txIn := tx.Inputs[i]
+		if txIn.UnlockingScript != nil && len(*txIn.UnlockingScript) > 0 {
+			continue
+		}
+		owner, err := ParseOwner(in.PreviousTxScript)
+		if err != nil {
+			return nil, fmt.Errorf("input %d is not signed", i)
+		}
+		o, ok := owner.(*MultisigOwner)
+		if !ok {
+			return nil, fmt.Errorf("input %d is not signed", i)
+		}
+
+		b := script.NewBuilder(&bscript.Script{}).Opcodes(bscript.Op0)
+		signed := 0
+		for _, pubKey := range o.PubKeys {
+			if signed == o.M {
+				break
+			}
+			if sig := in.signature(pubKey.SerialiseCompressed()); sig != nil {
+				b.PushData(sig)
+				signed++
+			}
+		}
+		if signed < o.M {
+			return nil, fmt.Errorf("input %d has %d of %d signatures", i, signed, o.M)
+		}
+		preimage, err := tx.CalcInputPreimage(uint32(i), in.SigHashFlags)
+		if err != nil {
+			return nil, err
+		}
+		if txIn.UnlockingScript, err = b.PushData(preimage).Script(); err != nil {
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// MergePartialTx combines the unlocking scripts and signatures of partial transactions of the same transaction.
+// It fails if any signature or unlocking script does not verify, so one bad copy cannot block Finalize
+func MergePartialTx(partials ...*PartialTx) (*PartialTx, error) {
+	if len(partials) == 0 {
+		return nil, errors.New("nothing to merge")
+	}
+	merged, err := NewPartialTxFromBytes(partials[0].Bytes())
+	if err != nil {
+		return nil, err
+	}
+	for i, in := range merged.Inputs {
+		if s := merged.Tx.Inputs[i].UnlockingScript; s != nil && len(*s) > 0 {
+			if err = merged.verifyUnlockingScript(i, s); err != nil {
+				return nil, err
+			}
+		}
+		for _, sig := range in.Signatures {
+			if err = merged.verifySignature(i, sig); err != nil {
+				return nil, err
+			}
+		}
+	}
+	unsigned := unsignedBytes(merged.Tx)
+	for _, p := range partials[1:] {
+		if !bytes.Equal(unsignedBytes(p.Tx), unsigned) || len(p.Inputs) != len(merged.Inputs) {
+			return nil, errors.New("partial transactions are of different transactions")
+		}
+		if p.LockTime != nil && merged.LockTime == nil {
+			lockTime := *p.LockTime
+			merged.LockTime = &lockTime
+		}
+		for i, in := range p.Inputs {
+			mergedIn := merged.Inputs[i]
+			if !in.PreviousTxScript.Equals(mergedIn.PreviousTxScript) || in.PreviousTxSatoshis != mergedIn.PreviousTxSatoshis || in.SigHashFlags != mergedIn.SigHashFlags {
+				return nil, fmt.Errorf("input %d has different previous outputs", i)
+			}
+			if s := p.Tx.Inputs[i].UnlockingScript; s != nil && len(*s) > 0 {
+				if err = merged.verifyUnlockingScript(i, s); err != nil {
+					return nil, err
+				}
+				txIn := merged.Tx.Inputs[i]
+				if txIn.UnlockingScript == nil || len(*txIn.UnlockingScript) == 0 {
+					txIn.UnlockingScript = bscript.NewFromBytes(*s)
+				}
+			}
+			for _, sig := range in.Signatures {
+				if err = merged.verifySignature(i, sig); err != nil {
+					return nil, err
+				}
+				if mergedIn.signature(sig.PubKey) == nil {
+					mergedIn.Signatures = append(mergedIn.Signatures, sig)
+				}
+			}
+		}
+	}
+	return merged, nil
+}
+
+// verifyUnlockingScript runs input i unlocked by s through the script interpreter
+func (p *PartialTx) verifyUnlockingScript(i int, s *bscript.Script) error {
+	tx := p.Tx.Clone()
+	tx.Inputs[i].UnlockingScript = bscript.NewFromBytes(*s)
+	prevOutput := &bt.Output{Satoshis: p.Inputs[i].PreviousTxSatoshis, LockingScript: p.Inputs[i].PreviousTxScript}
+	if err := interpreter.NewEngine().Execute(
+		interpreter.WithTx(tx, i, prevOutput),
+		interpreter.WithForkID(),
+		interpreter.WithAfterGenesis(),
+	); err != nil {
+		return fmt.Errorf("input %d has an unlocking script that does not verify: %v", i, err)
+	}
+	return nil
+}
+
+// verifySignature checks sig is a valid signature of input i by its public key
+func (p *PartialTx) verifySignature(i int, sig PartialSig) error {
+	digest, err := p.digest(i)
+	if err != nil {
+		return err
+	}
+	n := len(sig.Signature)
+	if n == 0 || sighash.Flag(sig.Signature[n-1]) != p.Inputs[i].SigHashFlags {
+		return fmt.Errorf("input %d has a signature with the wrong sighash flags", i)
+	}
+	pubKey, err := bec.ParsePubKey(sig.PubKey, bec.S256())
+	if err != nil {
+		return fmt.Errorf("input %d has an invalid public key", i)
+	}
+	signature, err := bec.ParseDERSignature(sig.Signature[:n-1], bec.S256())
+	if err != nil {
+		return fmt.Errorf("input %d has an invalid signature", i)
+	}
+	if !signature.Verify(digest, pubKey) {
+		return fmt.Errorf("input %d has a signature that does not verify for public key %x", i, sig.PubKey)
+	}
+	return nil
+}
+
+// unsignedBytes returns the transaction bytes without unlocking scripts
+func unsignedBytes(tx *bt.Tx) []byte {
+	unsigned := tx.Clone()
+	for _, in := range unsigned.Inputs {
+		in.UnlockingScript = &bscript.Script{}
+	}
+	return unsigned.Bytes()
+}
+
+// Bytes returns the binary encoding of the partial transaction
+func (p *PartialTx) Bytes() []byte {
+	b := append([]byte{}, partialTxMagic...)
+	b = append(b, partialTxVersion)
+	txBytes := p.Tx.Bytes()
+	b = append(b, bt.VarInt(len(txBytes)).Bytes()...)
+	b = append(b, txBytes...)
+	if p.LockTime == nil {
+		b = append(b, 0)
+	} else {
+		b = append(b, 1, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], *p.LockTime)
+	}
+	b = append(b, bt.VarInt(len(p.Inputs)).Bytes()...)
+	for _, in := range p.Inputs {
+		b = appendVarBytes(b, *in.PreviousTxScript)
+		n := make([]byte, 12)
+		binary.LittleEndian.PutUint64(n, in.PreviousTxSatoshis)
+		binary.LittleEndian.PutUint32(n[8:], uint32(in.SigHashFlags))
+		b = append(b, n...)
+		b = appendVarBytes(b, []byte(in.Unlocker))
+		b = append(b, bt.VarInt(len(in.Signatures)).Bytes()...)
+		for _, sig := range in.Signatures {
+			b = appendVarBytes(b, sig.PubKey)
+			b = appendVarBytes(b, sig.Signature)
+		}
+	}
+	return b
+}
+
+func appendVarBytes(b, data []byte) []byte {
+	b = append(b, bt.VarInt(len(data)).Bytes()...)
+	return append(b, data...)
+}
+
+// NewPartialTxFromBytes decodes a partial transaction from its binary encoding
+func NewPartialTxFromBytes(b []byte) (*PartialTx, error) {
+	if !bytes.HasPrefix(b, partialTxMagic) || len(b) <= len(partialTxMagic) {
+		return nil, errors.New("not a partial transaction")
+	}
+	if b[len(partialTxMagic)] != partialTxVersion {
+		return nil, fmt.Errorf("unknown partial transaction version %d", b[len(partialTxMagic)])
+	}
+	r := bytes.NewReader(b[len(partialTxMagic)+1:])
+
+	txBytes, err := readVarBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &PartialTx{}
+	if p.Tx, err = bt.NewTxFromBytes(txBytes); err != nil {
+		return nil, err
+	}
+	locked, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if locked == 1 {
+		var lockTime uint32
+		if err = binary.Read(r, binary.LittleEndian, &lockTime); err != nil {
+			return nil, err
+		}
+		p.LockTime = &lockTime
+	}
+
+	var count bt.VarInt
+	if _, err = count.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if int(count) != len(p.Tx.Inputs) {
+		return nil, errors.New("partial transaction must describe every input")
+	}
+	for i := 0; i < int(count); i++ {
+		in := &PartialInput{}
+		lockingScript, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		in.PreviousTxScript = bscript.NewFromBytes(lockingScript)
+		var flags uint32
+		if err = binary.Read(r, binary.LittleEndian, &in.PreviousTxSatoshis); err != nil {
+			return nil, err
+		}
+		if err = binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return nil, err
+		}
+		in.SigHashFlags = sighash.Flag(flags)
+		unlocker, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		in.Unlocker = string(unlocker)
+
+		var sigs bt.VarInt
+		if _, err = sigs.ReadFrom(r); err != nil {
+			return nil, err
+		}
+		for j := 0; j < int(sigs); j++ {
+			var sig PartialSig
+			if sig.PubKey, err = readVarBytes(r); err != nil {
+				return nil, err
+			}
+			if sig.Signature, err = readVarBytes(r); err != nil {
+				return nil, err
+			}
+			in.Signatures = append(in.Signatures, sig)
+		}
+		p.Inputs = append(p.Inputs, in)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("partial transaction has trailing bytes")
+	}
+	p.setPreviousOutputs()
+	return p, nil
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	var length bt.VarInt
+	if _, err := length.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	if uint64(length) > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// setPreviousOutputs copies the previous outputs of the inputs into the transaction so it can be signed
+func (p *PartialTx) setPreviousOutputs() {
+	for i, in := range p.Inputs {
+		p.Tx.Inputs[i].PreviousTxScript = in.PreviousTxScript
+		p.Tx.Inputs[i].PreviousTxSatoshis = in.PreviousTxSatoshis
+	}
+}
+
+type partialTxJSON struct {
+	Tx       string              `json:"tx"`
+	LockTime *uint32             `json:"lockTime,omitempty"`
+	Inputs   []*partialInputJSON `json:"inputs"`
+}
+
+type partialInputJSON struct {
+	PreviousTxScript   string            `json:"previousTxScript"`
+	PreviousTxSatoshis uint64            `json:"previousTxSatoshis"`
+	Unlocker           string            `json:"unlocker"`
+	SigHashFlags       uint32            `json:"sigHashFlags"`
+	Signatures         []*partialSigJSON `json:"signatures,omitempty"`
+}
+
+type partialSigJSON struct {
+	PubKey    string `json:"pubKey"`
+	Signature string `json:"signature"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PartialTx) MarshalJSON() ([]byte, error) {
+	j := &partialTxJSON{Tx: p.Tx.String(), LockTime: p.LockTime}
+	for _, in := range p.Inputs {
+		ij := &partialInputJSON{
+			PreviousTxScript:   in.PreviousTxScript.String(),
+			PreviousTxSatoshis: in.PreviousTxSatoshis,
+			Unlocker:           in.Unlocker,
+			SigHashFlags:       uint32(in.SigHashFlags),
+		}
+		for _, sig := range in.Signatures {
+			ij.Signatures = append(ij.Signatures, &partialSigJSON{PubKey: hex.EncodeToString(sig.PubKey), Signature: hex.EncodeToString(sig.Signature)})
+		}
+		j.Inputs = append(j.Inputs, ij)
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *PartialTx) UnmarshalJSON(b []byte) error {
+	var j partialTxJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	tx, err := bt.NewTxFromString(j.Tx)
+	if err != nil {
+		return err
+	}
+	if len(j.Inputs) != len(tx.Inputs) {
+		return errors.New("partial transaction must describe every input")
+	}
+	decoded := &PartialTx{Tx: tx, LockTime: j.LockTime}
+	for _, ij := range j.Inputs {
+		lockingScript, err := bscript.NewFromHexString(ij.PreviousTxScript)
+		if err != nil {
+			return err
+		}
+		in := &PartialInput{
+			PreviousTxScript:   lockingScript,
+			PreviousTxSatoshis: ij.PreviousTxSatoshis,
+			Unlocker:           ij.Unlocker,
+			SigHashFlags:       sighash.Flag(ij.SigHashFlags),
+		}
+		for _, sj := range ij.Signatures {
+			var sig PartialSig
+			if sig.PubKey, err = hex.DecodeString(sj.PubKey); err != nil {
+				return err
+			}
+			if sig.Signature, err = hex.DecodeString(sj.Signature); err != nil {
+				return err
+			}
+			in.Signatures = append(in.Signatures, sig)
+		}
+		decoded.Inputs = append(decoded.Inputs, in)
+	}
+	decoded.setPreviousOutputs()
+	*p = *decoded
+	return nil
+}
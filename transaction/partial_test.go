@@ -0,0 +1,156 @@
+package pushtx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+func TestPartialTx(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	bobKey, _ := testutil.NewKey(t)
+	carolKey, _ := testutil.NewKey(t)
+	daveKey, dave := testutil.NewKey(t)
+	multisig := &MultisigOwner{M: 2, PubKeys: []*bec.PublicKey{aliceKey.PubKey(), bobKey.PubKey(), carolKey.PubKey()}}
+
+	funding := bt.NewTx()
+	if err := funding.FromUTXOs(testutil.NewFundingUTXO(t, alice, 100000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AddOpPushTransactionOutputWithOwner(funding, multisig, 90000); err != nil {
+		t.Fatal(err)
+	}
+	if err := funding.FillAllInputs(context.Background(), &Getter{PrivateKey: aliceKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	spend := bt.NewTx()
+	if err := spend.FromUTXOs(testutil.OutputUTXO(funding, 0), testutil.NewFundingUTXO(t, dave, 10000)); err != nil {
+		t.Fatal(err)
+	}
+	if err := spend.PayToAddress(dave, 99000); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPartialTx(spend, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Inputs[0].Unlocker != "pushtx owner" || p.Inputs[1].Unlocker != "p2pkh" {
+		t.Errorf("expected unlockers pushtx owner and p2pkh, got %s and %s", p.Inputs[0].Unlocker, p.Inputs[1].Unlocker)
+	}
+	if err = p.Sign(context.Background(), &Getter{PrivateKey: aliceKey}); err == nil {
+		t.Error("expected signing push tx inputs before nLocktime is locked to fail")
+	}
+	if err = p.LockLowS(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// each party signs their own copy, passed as JSON or binary
+	viaJSON := func(p *PartialTx) *PartialTx {
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := &PartialTx{}
+		if err = json.Unmarshal(b, decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Bytes(), p.Bytes()) {
+			t.Error("expected JSON to round trip")
+		}
+		return decoded
+	}
+	viaBytes := func(p *PartialTx) *PartialTx {
+		decoded, err := NewPartialTxFromBytes(p.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Bytes(), p.Bytes()) {
+			t.Error("expected binary to round trip")
+		}
+		return decoded
+	}
+	sign := func(p *PartialTx, key *bec.PrivateKey) *PartialTx {
+		if err := p.Sign(context.Background(), &Getter{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	aliceSigned := viaJSON(sign(viaJSON(p), aliceKey))
+	carolSigned := viaBytes(sign(viaBytes(p), carolKey))
+	daveSigned := viaJSON(sign(viaBytes(p), daveKey))
+
+	if len(aliceSigned.Inputs[0].Signatures) != 1 || len(daveSigned.Inputs[0].Signatures) != 0 {
+		t.Error("expected only the multisig owners to sign the push tx input")
+	}
+	if _, err = daveSigned.Finalize(); err == nil {
+		t.Error("expected finalize to fail without the multisig signatures")
+	}
+	half, err := MergePartialTx(aliceSigned, daveSigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = half.Finalize(); err == nil {
+		t.Error("expected finalize to fail with 1 of 2 multisig signatures")
+	}
+
+	merged, err := MergePartialTx(aliceSigned, carolSigned, daveSigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := merged.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.LockTime != *p.LockTime {
+		t.Errorf("expected locked nLocktime %d, got %d", *p.LockTime, tx.LockTime)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Errorf("finalized transaction failed verification: %v", err)
+	}
+
+	// signatures that do not verify are rejected rather than merged
+	forged := viaBytes(carolSigned)
+	forged.Inputs[0].Signatures[0].Signature = aliceSigned.Inputs[0].Signatures[0].Signature
+	if _, err = MergePartialTx(aliceSigned, forged); err == nil {
+		t.Error("expected merging a signature by the wrong key to fail")
+	}
+	if _, err = MergePartialTx(forged, aliceSigned); err == nil {
+		t.Error("expected merging onto a signature by the wrong key to fail")
+	}
+
+	// unlocking scripts that do not verify are rejected rather than filling the input
+	corrupt := viaBytes(daveSigned)
+	corrupt.Tx.Inputs[1].UnlockingScript = bscript.NewFromBytes([]byte{bscript.Op0, bscript.Op0})
+	if _, err = MergePartialTx(aliceSigned, corrupt); err == nil {
+		t.Error("expected merging an unlocking script that does not verify to fail")
+	}
+	if _, err = MergePartialTx(corrupt, aliceSigned); err == nil {
+		t.Error("expected merging onto an unlocking script that does not verify to fail")
+	}
+
+	// signatures of a changed transaction cannot be merged with the original
+	changed := viaBytes(p)
+	if err = changed.Tx.PayToAddress(dave, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = MergePartialTx(changed, aliceSigned); err == nil {
+		t.Error("expected merging partials of different transactions to fail")
+	}
+}
+
+func TestNewPartialTxFromBytesRejectsGarbage(t *testing.T) {
+	t.Parallel()
+	for _, b := range [][]byte{nil, []byte("pstx"), append(append([]byte{}, partialTxMagic...), 2), append(append([]byte{}, partialTxMagic...), 1, 0xff)} {
+		if _, err := NewPartialTxFromBytes(b); err == nil {
+			t.Errorf("expected %x to be rejected", b)
+		}
+	}
+}
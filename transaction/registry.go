@@ -0,0 +1,146 @@
+package pushtx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sort"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+// Priorities of the default registrations. Contract templates start with the push tx
+// script, so they are registered above PriorityPushTx to be matched first
+const (
+	PriorityPushTx   = 0
+	PriorityOwner    = 10
+	PriorityContract = 50
+	PriorityP2PKH    = 100
+)
+
+// UnlockerFunc returns the unlocker of a matched locking script, using the keys of the Getter
+type UnlockerFunc func(ctx context.Context, g *Getter, lockingScript *bscript.Script) (bt.Unlocker, error)
+
+// Registration pairs a locking script matcher with its unlocker.
+// Unlockers that need more than the keys of the Getter, such as a secret or a contract operation,
+// are built by a function taking it, e.g. NewOwnerRegistration, and capture it in the UnlockerFunc
+type Registration struct {
+	Name     string
+	Priority int
+	Match    func(lockingScript *bscript.Script) bool
+	Unlocker UnlockerFunc
+}
+
+// Registry finds the unlocker of a locking script from its registrations,
+// trying higher priorities first and registrations of equal priority in order.
+// It can't be changed once built, so it is safe to share between goroutines
+type Registry struct {
+	registrations []Registration
+}
+
+// NewRegistry returns a registry of the registrations. A registration replaces any earlier one of the
+// same name, so NewRegistry(append(DefaultRegistrations(), NewOwnerRegistration(secret, nil))...)
+// unlocks hash puzzle owners with the secret
+func NewRegistry(registrations ...Registration) *Registry {
+	r := &Registry{}
+	for _, reg := range registrations {
+		replaced := false
+		for i, existing := range r.registrations {
+			if existing.Name == reg.Name {
+				r.registrations[i] = reg
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			r.registrations = append(r.registrations, reg)
+		}
+	}
+	sort.SliceStable(r.registrations, func(i, j int) bool {
+		return r.registrations[i].Priority > r.registrations[j].Priority
+	})
+	return r
+}
+
+var defaultRegistry = NewRegistry(DefaultRegistrations()...)
+
+// DefaultRegistry returns the registry of DefaultRegistrations, used by Getters without a Registry
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// DefaultRegistrations returns the registrations of P2PKH, push tx owners and push tx,
+// for building registries that extend the defaults
+func DefaultRegistrations() []Registration {
+	return []Registration{
+		{
+			Name:     "p2pkh",
+			Priority: PriorityP2PKH,
+			Match:    func(s *bscript.Script) bool { return s.IsP2PKH() },
+			Unlocker: func(ctx context.Context, g *Getter, s *bscript.Script) (bt.Unlocker, error) {
+				signers := g.signers()
+				if len(signers) == 0 {
+					return nil, errors.New("P2PKH needs a private key or signer")
+				}
+				return &UnlockP2PKH{Signer: signers[0]}, nil
+			},
+		},
+		NewOwnerRegistration(nil, nil),
+		{
+			Name:     "pushtx",
+			Priority: PriorityPushTx,
+			Match:    script.IsOpPushTx,
+			Unlocker: func(ctx context.Context, g *Getter, s *bscript.Script) (bt.Unlocker, error) {
+				signers := g.signers()
+				if len(signers) == 0 {
+					return nil, errors.New("push tx needs a private key or signer")
+				}
+				return &UnlockPushTx{Signer: signers[0]}, nil
+			},
+		},
+	}
+}
+
+// NewOwnerRegistration returns the registration of push tx owners other than P2PKH,
+// unlocking hash puzzle owners with the secret and R puzzle owners with k
+func NewOwnerRegistration(secret []byte, k *big.Int) Registration {
+	return Registration{
+		Name:     "pushtx owner",
+		Priority: PriorityOwner,
+		Match: func(s *bscript.Script) bool {
+			if !script.IsOpPushTx(s) {
+				return false
+			}
+			owner, err := ParseOwner(s)
+			if err != nil {
+				return false
+			}
+			_, ok := owner.(*P2PKHOwner)
+			return !ok
+		},
+		Unlocker: func(ctx context.Context, g *Getter, s *bscript.Script) (bt.Unlocker, error) {
+			return &UnlockOwner{Signers: g.signers(), Secret: secret, K: k}, nil
+		},
+	}
+}
+
+// Match returns the first registration matching the locking script
+func (r *Registry) Match(lockingScript *bscript.Script) (Registration, bool) {
+	for _, reg := range r.registrations {
+		if reg.Match(lockingScript) {
+			return reg, true
+		}
+	}
+	return Registration{}, false
+}
+
+// Unlocker returns the unlocker of the first registration matching the locking script
+func (r *Registry) Unlocker(ctx context.Context, g *Getter, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	reg, ok := r.Match(lockingScript)
+	if !ok {
+		return nil, errors.New("no unlocker registered for locking script")
+	}
+	return reg.Unlocker(ctx, g, lockingScript)
+}
@@ -0,0 +1,99 @@
+package pushtx
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+type namedUnlocker struct {
+	name string
+}
+
+func (u *namedUnlocker) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	return &bscript.Script{}, nil
+}
+
+func named(name string, priority int, match bool) Registration {
+	return Registration{
+		Name:     name,
+		Priority: priority,
+		Match:    func(s *bscript.Script) bool { return match },
+		Unlocker: func(ctx context.Context, g *Getter, s *bscript.Script) (bt.Unlocker, error) {
+			return &namedUnlocker{name: name}, nil
+		},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name          string
+		registrations []Registration
+		expected      string
+	}{
+		{"highest priority", []Registration{named("low", 1, true), named("high", 2, true)}, "high"},
+		{"registration order", []Registration{named("first", 1, true), named("second", 1, true)}, "first"},
+		{"skips no match", []Registration{named("high", 2, false), named("low", 1, true)}, "low"},
+		{"replaces same name", []Registration{named("same", 1, true), named("same", 1, false)}, ""},
+		{"no match", []Registration{named("none", 1, false)}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getter := &Getter{Registry: NewRegistry(test.registrations...)}
+			unlocker, err := getter.Unlocker(context.Background(), &bscript.Script{})
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("%s failed: expected error", test.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := unlocker.(*namedUnlocker).name; name != test.expected {
+				t.Errorf("%s failed: expected %s, got %s", test.name, test.expected, name)
+			}
+		})
+	}
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	p2pkh, err := bscript.NewP2PKHFromAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTx, err := NewOwnerLockingScript(&P2PKHOwner{Address: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, err := NewOwnerLockingScript(&HashPuzzleOwner{Hash: make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getter := &Getter{PrivateKey: privateKey}
+	for _, test := range []struct {
+		name          string
+		lockingScript *bscript.Script
+		expected      bt.Unlocker
+	}{
+		{"p2pkh", p2pkh, &UnlockP2PKH{}},
+		{"push tx", pushTx, &UnlockPushTx{}},
+		{"owner", owner, &UnlockOwner{}},
+	} {
+		unlocker, err := getter.Unlocker(context.Background(), test.lockingScript)
+		if err != nil {
+			t.Fatalf("%s failed: %v", test.name, err)
+		}
+		if got, want := fmt.Sprintf("%T", unlocker), fmt.Sprintf("%T", test.expected); got != want {
+			t.Errorf("%s failed: expected %s, got %s", test.name, want, got)
+		}
+	}
+}
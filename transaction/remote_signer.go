@@ -0,0 +1,174 @@
+package pushtx
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/libsv/go-bk/bec"
+)
+
+/*
+Remote Signer Protocol
+----------------------
+
+GET  <url>/keys/<keyID>       -> {"publicKey": "<hex compressed public key>"}
+POST <url>/keys/<keyID>/sign  {"digest": "<hex 32 bytes>"} -> {"signature": "<hex DER signature>"}
+
+Errors are returned with a non 200 status and {"error": "<message>"}.
+*/
+
+type remotePublicKey struct {
+	PublicKey string `json:"publicKey"`
+}
+
+type remoteSignRequest struct {
+	Digest string `json:"digest"`
+}
+
+type remoteSignature struct {
+	Signature string `json:"signature"`
+}
+
+type remoteError struct {
+	Error string `json:"error"`
+}
+
+// RemoteSigner signs with a key held by a remote signing service
+type RemoteSigner struct {
+	URL   string
+	KeyID string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// PublicKey implements Signer
+func (s *RemoteSigner) PublicKey(ctx context.Context) (*bec.PublicKey, error) {
+	var res remotePublicKey
+	if err := s.do(ctx, http.MethodGet, "", nil, &res); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(res.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return bec.ParsePubKey(b, bec.S256())
+}
+
+// Sign implements Signer. The signature is checked against the public key of the service
+// so a misbehaving service cannot produce an invalid transaction
+func (s *RemoteSigner) Sign(ctx context.Context, digest []byte) (*bec.Signature, error) {
+	var res remoteSignature
+	if err := s.do(ctx, http.MethodPost, "/sign", &remoteSignRequest{Digest: hex.EncodeToString(digest)}, &res); err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(res.Signature)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := bec.ParseDERSignature(b, bec.S256())
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := s.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !sig.Verify(digest, pubKey) {
+		return nil, errors.New("remote signer returned an invalid signature")
+	}
+	return sig, nil
+}
+
+func (s *RemoteSigner) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	endpoint := strings.TrimRight(s.URL, "/") + "/keys/" + url.PathEscape(s.KeyID) + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		var e remoteError
+		if err = json.NewDecoder(res.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("remote signer returned %s", res.Status)
+		}
+		return fmt.Errorf("remote signer returned %s: %s", res.Status, e.Error)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// NewRemoteSignerHandler serves the remote signer protocol for the signers by key ID.
+// It is meant for tests and local development, a production service needs authentication
+func NewRemoteSignerHandler(signers map[string]Signer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/keys/")
+		keyID, action := path, ""
+		if i := strings.Index(path, "/"); i >= 0 {
+			keyID, action = path[:i], path[i:]
+		}
+		signer, ok := signers[keyID]
+		if !ok || !strings.HasPrefix(r.URL.Path, "/keys/") {
+			writeJSON(w, http.StatusNotFound, &remoteError{Error: "unknown key"})
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			pubKey, err := signer.PublicKey(r.Context())
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, &remoteError{Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, &remotePublicKey{PublicKey: hex.EncodeToString(pubKey.SerialiseCompressed())})
+		case action == "/sign" && r.Method == http.MethodPost:
+			var req remoteSignRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, &remoteError{Error: err.Error()})
+				return
+			}
+			digest, err := hex.DecodeString(req.Digest)
+			if err != nil || len(digest) != 32 {
+				writeJSON(w, http.StatusBadRequest, &remoteError{Error: "digest must be 32 bytes of hex"})
+				return
+			}
+			sig, err := signer.Sign(r.Context(), digest)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, &remoteError{Error: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, &remoteSignature{Signature: hex.EncodeToString(sig.Serialise())})
+		default:
+			writeJSON(w, http.StatusNotFound, &remoteError{Error: "unknown endpoint"})
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,57 @@
+package pushtx
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bk/crypto"
+)
+
+// RPuzzleR returns r of signatures made with k, encoded as in a DER signature
+func RPuzzleR(k *big.Int) ([]byte, error) {
+	curve := bec.S256()
+	if k.Sign() <= 0 || k.Cmp(curve.N) >= 0 {
+		return nil, errors.New("k must be between 1 and the curve order")
+	}
+	x, _ := curve.ScalarBaseMult(k.Bytes())
+	r := new(big.Int).Mod(x, curve.N).Bytes()
+	// DER integers are signed, so r gets a leading zero byte if its top bit is set
+	if r[0]&0x80 != 0 {
+		r = append([]byte{0x00}, r...)
+	}
+	return r, nil
+}
+
+// RPuzzleHash returns the HASH160 of r for an R puzzle owner spendable with k
+func RPuzzleHash(k *big.Int) ([]byte, error) {
+	r, err := RPuzzleR(k)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Hash160(r), nil
+}
+
+// SignWithK signs digest with the private key using the nonce k, so the signature has the r of k.
+// Signing two digests with the same k and key reveals the key, so the key should be used once
+func SignWithK(privateKey *bec.PrivateKey, k *big.Int, digest []byte) (*bec.Signature, error) {
+	curve := bec.S256()
+	if k.Sign() <= 0 || k.Cmp(curve.N) >= 0 {
+		return nil, errors.New("k must be between 1 and the curve order")
+	}
+	x, _ := curve.ScalarBaseMult(k.Bytes())
+	r := new(big.Int).Mod(x, curve.N)
+	if r.Sign() == 0 {
+		return nil, errors.New("k gives r of zero")
+	}
+	// s = k^-1 (z + r d) mod N
+	z := new(big.Int).SetBytes(digest)
+	s := new(big.Int).Mul(r, privateKey.D)
+	s.Add(s, z)
+	s.Mul(s, new(big.Int).ModInverse(k, curve.N))
+	s.Mod(s, curve.N)
+	if s.Sign() == 0 {
+		return nil, errors.New("k gives s of zero")
+	}
+	return &bec.Signature{R: r, S: s}, nil
+}
@@ -0,0 +1,113 @@
+package pushtx
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+func TestSpendWithSigHash(t *testing.T) {
+	t.Parallel()
+	aliceKey, alice := testutil.NewKey(t)
+	bobKey, bob := testutil.NewKey(t)
+	multisig := &MultisigOwner{M: 1, PubKeys: []*bec.PublicKey{aliceKey.PubKey(), bobKey.PubKey()}}
+
+	var tests = []struct {
+		name        string
+		flag        sighash.Flag
+		owner       Owner
+		addInput    bool
+		addOutput   bool
+		expectValid bool
+	}{
+		{"all", sighash.AllForkID, &P2PKHOwner{Address: alice}, false, false, true},
+		{"none", sighash.NoneForkID, &P2PKHOwner{Address: alice}, false, false, true},
+		{"single", sighash.SingleForkID, &P2PKHOwner{Address: alice}, false, false, true},
+		{"all anyonecanpay", sighash.AllForkID | sighash.AnyOneCanPay, &P2PKHOwner{Address: alice}, false, false, true},
+		{"all with input added", sighash.AllForkID, &P2PKHOwner{Address: alice}, true, false, false},
+		{"all anyonecanpay with input added", sighash.AllForkID | sighash.AnyOneCanPay, &P2PKHOwner{Address: alice}, true, false, true},
+		{"all anyonecanpay with input and output added", sighash.AllForkID | sighash.AnyOneCanPay, &P2PKHOwner{Address: alice}, true, true, false},
+		{"single with output added", sighash.SingleForkID, &P2PKHOwner{Address: alice}, false, true, true},
+		{"single with input and output added", sighash.SingleForkID, &P2PKHOwner{Address: alice}, true, true, false},
+		{"single anyonecanpay with input and output added", sighash.SingleForkID | sighash.AnyOneCanPay, &P2PKHOwner{Address: alice}, true, true, true},
+		{"none anyonecanpay with input and output added", sighash.NoneForkID | sighash.AnyOneCanPay, &P2PKHOwner{Address: alice}, true, true, true},
+		{"multisig single anyonecanpay", sighash.SingleForkID | sighash.AnyOneCanPay, multisig, true, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.NewFundingUTXO(t, alice, 100000)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := AddOpPushTransactionOutputWithSigHash(tx, test.owner, 90000, test.flag); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.FillAllInputs(context.Background(), &Getter{PrivateKey: aliceKey}); err != nil {
+				t.Fatal(err)
+			}
+			if flag, ok := script.PushTxSigHash(tx.Outputs[0].LockingScript); !ok || flag != test.flag {
+				t.Fatalf("expected sighash flag %x, got %x", test.flag, flag)
+			}
+			owner, err := ParseOwner(tx.Outputs[0].LockingScript)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(owner, test.owner) {
+				t.Errorf("%s failed: expected parsed owner %+v, got %+v", test.name, test.owner, owner)
+			}
+
+			spend := bt.NewTx()
+			if err = spend.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+				t.Fatal(err)
+			}
+			if err = spend.PayToAddress(alice, 89000); err != nil {
+				t.Fatal(err)
+			}
+			if err = spend.FillAllInputs(context.Background(), &Getter{PrivateKey: aliceKey}); err != nil {
+				t.Fatal(err)
+			}
+
+			// another party extends the signed transaction
+			if test.addInput {
+				if err = spend.FromUTXOs(testutil.NewFundingUTXO(t, bob, 10000)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.addOutput {
+				if err = spend.PayToAddress(bob, 9000); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if test.addInput {
+				unlocker := &UnlockP2PKH{Signer: &PrivateKeySigner{PrivateKey: bobKey}}
+				if err = spend.FillInput(context.Background(), unlocker, bt.UnlockerParams{InputIdx: 1, SigHashFlags: sighash.AllForkID}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = testutil.Verify(spend)
+			if err != nil && test.expectValid {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && !test.expectValid {
+				t.Errorf("%s failed: expected the extended transaction to be rejected", test.name)
+			}
+		})
+	}
+}
+
+func TestInvalidSigHash(t *testing.T) {
+	t.Parallel()
+	_, alice := testutil.NewKey(t)
+	for _, flag := range []sighash.Flag{sighash.All, sighash.Old | sighash.ForkID, 0x04 | sighash.ForkID, sighash.AllForkID | 0x20} {
+		if _, err := NewOwnerLockingScriptWithSigHash(&P2PKHOwner{Address: alice}, flag); err == nil {
+			t.Errorf("expected sighash flag %x to be rejected", flag)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pushtx
+
+import (
+	"context"
+	"errors"
+	"math/big"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/libsv/go-bt/v2/sighash"
+)
+
+// Signer signs transaction digests, so unlocking does not need the private key in memory
+type Signer interface {
+	// PublicKey returns the public key signatures verify against
+	PublicKey(ctx context.Context) (*bec.PublicKey, error)
+	// Sign returns the signature of the 32 byte digest
+	Sign(ctx context.Context, digest []byte) (*bec.Signature, error)
+}
+
+// PrivateKeySigner signs with a private key held in memory
+type PrivateKeySigner struct {
+	PrivateKey *bec.PrivateKey
+}
+
+// PublicKey implements Signer
+func (s *PrivateKeySigner) PublicKey(ctx context.Context) (*bec.PublicKey, error) {
+	return s.PrivateKey.PubKey(), nil
+}
+
+// Sign implements Signer
+func (s *PrivateKeySigner) Sign(ctx context.Context, digest []byte) (*bec.Signature, error) {
+	return s.PrivateKey.Sign(digest)
+}
+
+// PKCS11Session is the part of a PKCS#11 session used for signing. It is implemented
+// by wrapping the session of a PKCS#11 binding for the HSM in use
+type PKCS11Session interface {
+	// FindKey returns the handle of the secp256k1 private key with the label, and the serialised public key
+	FindKey(label string) (handle uint, pubKey []byte, err error)
+	// SignECDSA signs the digest with CKM_ECDSA, returning r || s as 32 bytes each
+	SignECDSA(handle uint, digest []byte) ([]byte, error)
+}
+
+// PKCS11Signer signs with the key of the label in a PKCS#11 session
+type PKCS11Signer struct {
+	Session PKCS11Session
+	Label   string
+}
+
+// PublicKey implements Signer
+func (s *PKCS11Signer) PublicKey(ctx context.Context) (*bec.PublicKey, error) {
+	_, pubKey, err := s.Session.FindKey(s.Label)
+	if err != nil {
+		return nil, err
+	}
+	return bec.ParsePubKey(pubKey, bec.S256())
+}
+
+// Sign implements Signer
+func (s *PKCS11Signer) Sign(ctx context.Context, digest []byte) (*bec.Signature, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	handle, _, err := s.Session.FindKey(s.Label)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := s.Session.SignECDSA(handle, digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) != 64 {
+		return nil, errors.New("PKCS#11 signature must be r || s of 32 bytes each")
+	}
+	// Serialise makes s low, so signatures from the HSM are canonical
+	return &bec.Signature{R: new(big.Int).SetBytes(rs[:32]), S: new(big.Int).SetBytes(rs[32:])}, nil
+}
+
+// signWith returns the signature of digest by the signer with the sighash flag appended
+func signWith(ctx context.Context, signer Signer, digest []byte, sigHashFlags sighash.Flag) ([]byte, error) {
+	sig, err := signer.Sign(ctx, digest)
+	if err != nil {
+		return nil, err
+	}
+	return append(sig.Serialise(), byte(sigHashFlags)), nil
+}
+
+// UnlockP2PKH unlocks P2PKH outputs with a Signer
+type UnlockP2PKH struct {
+	Signer Signer
+}
+
+// Implements the bt.Unlocker interface
+func (u *UnlockP2PKH) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
+	if params.SigHashFlags == 0 {
+		params.SigHashFlags = sighash.AllForkID
+	}
+	if !tx.Inputs[params.InputIdx].PreviousTxScript.IsP2PKH() {
+		return nil, errors.New("locking script is not P2PKH")
+	}
+	sh, err := tx.CalcInputSignatureHash(params.InputIdx, params.SigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := u.Signer.PublicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := u.Signer.Sign(ctx, sh)
+	if err != nil {
+		return nil, err
+	}
+	return bscript.NewP2PKHUnlockingScript(pubKey.SerialiseCompressed(), sig.Serialise(), params.SigHashFlags)
+}
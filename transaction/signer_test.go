@@ -0,0 +1,110 @@
+package pushtx
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+// fakePKCS11Session holds keys by label as an HSM would
+type fakePKCS11Session struct {
+	keys   []*bec.PrivateKey
+	labels map[string]uint
+}
+
+func (s *fakePKCS11Session) FindKey(label string) (uint, []byte, error) {
+	handle, ok := s.labels[label]
+	if !ok {
+		return 0, nil, errors.New("CKR_KEY_HANDLE_INVALID")
+	}
+	return handle, s.keys[handle].PubKey().SerialiseCompressed(), nil
+}
+
+func (s *fakePKCS11Session) SignECDSA(handle uint, digest []byte) ([]byte, error) {
+	sig, err := s.keys[handle].Sign(digest)
+	if err != nil {
+		return nil, err
+	}
+	rs := make([]byte, 64)
+	sig.R.FillBytes(rs[:32])
+	sig.S.FillBytes(rs[32:])
+	return rs, nil
+}
+
+// lyingSigner claims one public key but signs with another key
+type lyingSigner struct {
+	claimed *bec.PublicKey
+	key     *bec.PrivateKey
+}
+
+func (s *lyingSigner) PublicKey(ctx context.Context) (*bec.PublicKey, error) {
+	return s.claimed, nil
+}
+
+func (s *lyingSigner) Sign(ctx context.Context, digest []byte) (*bec.Signature, error) {
+	return s.key.Sign(digest)
+}
+
+func TestSigners(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	malloryKey, _ := testutil.NewKey(t)
+	session := &fakePKCS11Session{keys: []*bec.PrivateKey{malloryKey, privateKey}, labels: map[string]uint{"mallory": 0, "alice": 1}}
+	server := httptest.NewServer(NewRemoteSignerHandler(map[string]Signer{
+		"alice": &PrivateKeySigner{PrivateKey: privateKey},
+		"liar":  &lyingSigner{claimed: privateKey.PubKey(), key: malloryKey},
+	}))
+	defer server.Close()
+
+	var tests = []struct {
+		name          string
+		signer        Signer
+		expectedError bool
+	}{
+		{"private key", &PrivateKeySigner{PrivateKey: privateKey}, false},
+		{"pkcs11", &PKCS11Signer{Session: session, Label: "alice"}, false},
+		{"pkcs11 unknown label", &PKCS11Signer{Session: session, Label: "bob"}, true},
+		{"pkcs11 wrong key", &PKCS11Signer{Session: session, Label: "mallory"}, true},
+		{"remote", &RemoteSigner{URL: server.URL, KeyID: "alice", Client: server.Client()}, false},
+		{"remote unknown key", &RemoteSigner{URL: server.URL, KeyID: "bob", Client: server.Client()}, true},
+		{"remote invalid signature", &RemoteSigner{URL: server.URL, KeyID: "liar", Client: server.Client()}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.NewFundingUTXO(t, address, 100000)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := AddOpPushTransactionOutput(tx, address, 90000); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.FillAllInputs(context.Background(), &Getter{PrivateKey: privateKey}); err != nil {
+				t.Fatal(err)
+			}
+
+			// spends the push tx output and a P2PKH input with the signer
+			spend := bt.NewTx()
+			if err := spend.FromUTXOs(testutil.OutputUTXO(tx, 0), testutil.NewFundingUTXO(t, address, 10000)); err != nil {
+				t.Fatal(err)
+			}
+			if err := spend.PayToAddress(address, 99000); err != nil {
+				t.Fatal(err)
+			}
+			err := spend.FillAllInputs(context.Background(), &Getter{Signer: test.signer})
+			if err == nil {
+				err = testutil.Verify(spend)
+			}
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected error", test.name)
+			}
+		})
+	}
+}
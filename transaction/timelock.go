@@ -0,0 +1,83 @@
+package pushtx
+
+import (
+	"context"
+	"errors"
+
+	"github.com/libsv/go-bk/bec"
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/bscript"
+	"github.com/murray-distributed-technologies/go-pushtx/script"
+)
+
+/*
+Time Locked OP_PUSH_TX Output
+-----------------------------
+
+Unlocking Script: <sig> <pubKey> <preimage>
+
+Locking Script: <Optimized OP_PUSH_TX> <check nLocktime and nSequence> OP_DROP <P2PKH>
+
+OP_CHECKLOCKTIMEVERIFY is disabled after genesis, so the lock is enforced by reading nLocktime from the
+preimage. nSequence of the input must be non-final for the network to hold the transaction until nLocktime.
+*/
+
+// NonFinalSequenceNumber is the nSequence used for inputs of time locked transactions
+const NonFinalSequenceNumber = bt.DefaultSequenceNumber - 1
+
+// AddTimeLockedOpPushTransactionOutput adds an output which can only be spent by address once lockTime is reached
+func AddTimeLockedOpPushTransactionOutput(tx *bt.Tx, address string, satoshis uint64, lockTime uint32) (*bt.Tx, error) {
+	lockingScript, err := NewTimeLockedLockingScript(address, lockTime)
+	if err != nil {
+		return nil, err
+	}
+	tx.AddOutput(&bt.Output{
+		Satoshis:      satoshis,
+		LockingScript: lockingScript,
+	})
+	return tx, nil
+}
+
+// NewTimeLockedLockingScript returns the locking script of a time locked OP_PUSH_TX output
+func NewTimeLockedLockingScript(address string, lockTime uint32) (*bscript.Script, error) {
+	return script.NewBuilder(&bscript.Script{}).
+		Append(script.AppendPushTxVerify).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendCheckLockTime(s, lockTime)
+		}).
+		Opcodes(bscript.OpDROP).
+		Append(func(s *bscript.Script) (*bscript.Script, error) {
+			return script.AppendP2PKH(s, address)
+		}).
+		Script()
+}
+
+// SetLockTime sets nLocktime of the transaction and makes every input non-final so it is enforced.
+// The low s search counts up from lockTime, so the final nLocktime may be slightly later
+func SetLockTime(tx *bt.Tx, lockTime uint32) {
+	tx.LockTime = lockTime
+	for _, in := range tx.Inputs {
+		in.SequenceNumber = NonFinalSequenceNumber
+	}
+}
+
+// SpendTimeLockedOutput spends a time locked utxo to address, paying the fee out of the utxo
+func SpendTimeLockedOutput(ctx context.Context, utxo *bt.UTXO, lockTime uint32, address string, privateKey *bec.PrivateKey) (*bt.Tx, error) {
+	if utxo.LockingScript.IsP2PKH() {
+		return nil, errors.New("utxo is not time locked")
+	}
+	tx := bt.NewTx()
+	if err := tx.FromUTXOs(utxo); err != nil {
+		return nil, err
+	}
+	if err := tx.PayToAddress(address, 0); err != nil {
+		return nil, err
+	}
+	SetLockTime(tx, lockTime)
+
+	unlocker := Getter{PrivateKey: privateKey}
+	if err := FillAllInputsWithChange(ctx, tx, &unlocker, 0, bt.NewFeeQuote()); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
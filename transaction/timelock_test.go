@@ -0,0 +1,89 @@
+package pushtx
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+)
+
+func TestSpendTimeLockedOutput(t *testing.T) {
+	t.Parallel()
+	var tests = []struct {
+		name          string
+		lockTime      uint32
+		txLockTime    uint32
+		sequence      uint32
+		expectedError bool
+	}{
+		{"height reached", 800000, 800000, NonFinalSequenceNumber, false},
+		{"height passed", 800000, 900000, NonFinalSequenceNumber, false},
+		{"height not reached", 800000, 799000, NonFinalSequenceNumber, true},
+		{"final sequence", 800000, 800000, bt.DefaultSequenceNumber, true},
+		{"timestamp for height lock", 800000, 500000001, NonFinalSequenceNumber, true},
+		{"timestamp reached", 1700000000, 1700000000, NonFinalSequenceNumber, false},
+		{"timestamp not reached", 1700000000, 1600000000, NonFinalSequenceNumber, true},
+	}
+	privateKey, address := testutil.NewKey(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tx := bt.NewTx()
+			if err := tx.FromUTXOs(testutil.NewFundingUTXO(t, address, 100000)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := AddTimeLockedOpPushTransactionOutput(tx, address, 90000, test.lockTime); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.FillAllInputs(context.Background(), &Getter{PrivateKey: privateKey}); err != nil {
+				t.Fatal(err)
+			}
+
+			spend := bt.NewTx()
+			if err := spend.FromUTXOs(testutil.OutputUTXO(tx, 0)); err != nil {
+				t.Fatal(err)
+			}
+			if err := spend.PayToAddress(address, 89000); err != nil {
+				t.Fatal(err)
+			}
+			spend.LockTime = test.txLockTime
+			spend.Inputs[0].SequenceNumber = test.sequence
+			if err := spend.FillAllInputs(context.Background(), &Getter{PrivateKey: privateKey}); err != nil {
+				t.Fatal(err)
+			}
+			if spend.LockTime < test.txLockTime {
+				t.Errorf("%s failed: low s search moved nLocktime from %d down to %d", test.name, test.txLockTime, spend.LockTime)
+			}
+
+			err := testutil.Verify(spend)
+			if err != nil && !test.expectedError {
+				t.Errorf("%s failed: unexpected error %v", test.name, err)
+			}
+			if err == nil && test.expectedError {
+				t.Errorf("%s failed: expected time lock to reject nLocktime %d", test.name, spend.LockTime)
+			}
+		})
+	}
+}
+
+func TestSpendTimeLockedOutputBuilder(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	lockingScript, err := NewTimeLockedLockingScript(address, 750000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxo := testutil.NewFundingUTXO(t, address, 10000)
+	utxo.LockingScript = lockingScript
+
+	tx, err := SpendTimeLockedOutput(context.Background(), utxo, 750000, address, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = testutil.Verify(tx); err != nil {
+		t.Fatalf("time locked spend failed verification: %v", err)
+	}
+	if tx.LockTime < 750000 || tx.Inputs[0].SequenceNumber == bt.DefaultSequenceNumber {
+		t.Errorf("expected nLocktime at least 750000 with a non-final input, got %d", tx.LockTime)
+	}
+}
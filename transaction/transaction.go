@@ -10,7 +10,6 @@ import (
 	"github.com/libsv/go-bt/v2"
 	"github.com/libsv/go-bt/v2/bscript"
 	"github.com/libsv/go-bt/v2/sighash"
-	btunlocker "github.com/libsv/go-bt/v2/unlocker"
 	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
 	"github.com/murray-distributed-technologies/go-pushtx/script"
 )
@@ -26,18 +25,52 @@ Locking Script: <Optimized OP_PUSH_TX> <P2PKH>
 
 */
 
+// Result describes a transaction built by BuildOpPushTransaction
+type Result struct {
+	Tx   *bt.Tx
+	TxID string
+	// PushTxIndex is the index of the OP_PUSH_TX output
+	PushTxIndex int
+	// ChangeIndex is the index of the change output, or -1 if there is none
+	ChangeIndex int
+	Fee         uint64
+	LockTime    uint32
+	// LockTimeDelta is how far nLockTime was moved up from its starting value to get a low s preimage.
+	// It is the number of nLockTimes tried after the first, not a count of signing attempts
+	LockTimeDelta uint32
+	// Iterations is the number of preimages tried to find one that is low s, LockTimeDelta + 1,
+	// or 0 if the input is not push tx and nLockTime was not searched
+	Iterations uint32
+}
+
+// NewOpPushTransaction returns the hex of the transaction built by BuildOpPushTransaction.
+// It keeps its original signature for existing callers and cannot be cancelled.
+//
+// Deprecated: use BuildOpPushTransaction, which takes a context and returns the transaction with its fee and nLockTime
 func NewOpPushTransaction(input *bt.Input, txId, address, changeAddress string, privateKey *bec.PrivateKey, satoshis uint64) (string, error) {
+	result, err := BuildOpPushTransaction(context.Background(), input, txId, address, changeAddress, privateKey, satoshis)
+	if err != nil {
+		return "", err
+	}
+	return result.Tx.String(), nil
+}
+
+// BuildOpPushTransaction spends the input to an OP_PUSH_TX output of address in output 0, with change to changeAddress
+func BuildOpPushTransaction(ctx context.Context, input *bt.Input, txId, address, changeAddress string, privateKey *bec.PrivateKey, satoshis uint64) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	var err error
 	tx := bt.NewTx()
 
 	err = tx.From(txId, input.PreviousTxOutIndex, input.PreviousTxScript.String(), input.PreviousTxSatoshis)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// add OP_PUSH_TX in output 0
 	if tx, err = AddOpPushTransactionOutput(tx, address, satoshis); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Add change output
@@ -45,13 +78,13 @@ func NewOpPushTransaction(input *bt.Input, txId, address, changeAddress string,
 	if input.PreviousTxScript.IsP2PKH() {
 		fq := bt.NewFeeQuote()
 		if err = tx.ChangeToAddress(changeAddress, fq); err != nil {
-			return "", err
+			return nil, err
 		}
 	}
 	if !input.PreviousTxScript.IsP2PKH() {
 		lockingScript, err := bscript.NewP2PKHFromAddress(changeAddress)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		amount := (input.PreviousTxSatoshis - satoshis - 500)
 		changeOutput := bt.Output{
@@ -63,71 +96,90 @@ func NewOpPushTransaction(input *bt.Input, txId, address, changeAddress string,
 	}
 
 	unlocker := Getter{PrivateKey: privateKey}
+	lockTime := tx.LockTime
 
 	// Sign Input
-	if err = tx.FillAllInputs(context.Background(), &unlocker); err != nil {
-		return "", err
+	if err = tx.FillAllInputs(ctx, &unlocker); err != nil {
+		return nil, err
 	}
 
-	return tx.String(), nil
-}
-
-func AddOpPushTransactionOutput(tx *bt.Tx, address string, satoshis uint64) (*bt.Tx, error) {
-	var err error
-	s := &bscript.Script{}
-	if s, err = script.AppendPushTx(s); err != nil {
-		return nil, err
+	result := &Result{
+		Tx:            tx,
+		TxID:          tx.TxID(),
+		PushTxIndex:   0,
+		ChangeIndex:   -1,
+		Fee:           tx.TotalInputSatoshis() - tx.TotalOutputSatoshis(),
+		LockTime:      tx.LockTime,
+		LockTimeDelta: tx.LockTime - lockTime,
 	}
-	if s, err = script.AppendP2PKH(s, address); err != nil {
-		return nil, err
+	if _, ok := script.PushTxSigHash(input.PreviousTxScript); ok {
+		result.Iterations = result.LockTimeDelta + 1
 	}
-	lockingScript := s
-	output := bt.Output{
-		Satoshis:      satoshis,
-		LockingScript: lockingScript,
+	if len(tx.Outputs) > 1 {
+		result.ChangeIndex = 1
 	}
-	tx.AddOutput(&output)
-	return tx, nil
+	return result, nil
+}
 
+func AddOpPushTransactionOutput(tx *bt.Tx, address string, satoshis uint64) (*bt.Tx, error) {
+	return AddOpPushTransactionOutputWithOwner(tx, &P2PKHOwner{Address: address}, satoshis)
 }
 
 type Getter struct {
 	PrivateKey *bec.PrivateKey
+	// Signer is used instead of PrivateKey so the key does not have to be in memory
+	Signer Signer
+	// PrivateKeys and Signers sign for multisig owners along with PrivateKey or Signer
+	PrivateKeys []*bec.PrivateKey
+	Signers     []Signer
+	// Registry matches locking scripts to unlockers, DefaultRegistry is used if it is nil.
+	// Secrets and contract arguments are given to the registrations it is built from
+	Registry *Registry
 }
 
+// Unlocker returns the unlocker of the first match in Registry, or DefaultRegistry if it is nil
 func (g *Getter) Unlocker(ctx context.Context, lockingScript *bscript.Script) (bt.Unlocker, error) {
+	return g.registry().Unlocker(ctx, g, lockingScript)
+}
 
-	// if locking script is p2pkh do not add preimage to unlocking script
-	if lockingScript.IsP2PKH() {
-		return &btunlocker.Simple{PrivateKey: g.PrivateKey}, nil
-	}
-	// if locking script is OP_PUSH_TX add preimage to end of unlocking script
-	if script.IsOpPushTx(lockingScript) {
-		return &UnlockPushTx{PrivateKey: g.PrivateKey}, nil
+func (g *Getter) registry() *Registry {
+	if g.Registry == nil {
+		return DefaultRegistry()
 	}
-	return nil, errors.New("locking script not P2PKH or PushTx")
+	return g.Registry
+}
 
+// signers returns Signer or PrivateKey, followed by Signers and PrivateKeys
+func (g *Getter) signers() []Signer {
+	var signers []Signer
+	if g.Signer != nil {
+		signers = append(signers, g.Signer)
+	} else if g.PrivateKey != nil {
+		signers = append(signers, &PrivateKeySigner{PrivateKey: g.PrivateKey})
+	}
+	signers = append(signers, g.Signers...)
+	for _, k := range g.PrivateKeys {
+		signers = append(signers, &PrivateKeySigner{PrivateKey: k})
+	}
+	return signers
 }
 
 type UnlockPushTx struct {
 	PrivateKey *bec.PrivateKey
+	// Signer is used instead of PrivateKey if it is set
+	Signer Signer
+	// Args are pushed before the signature for contracts that take arguments
+	Args [][]byte
 }
 
 // TODO: Currently only supports input 0
 // Implements the bt.Unlocker interface
 func (u *UnlockPushTx) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt.UnlockerParams) (*bscript.Script, error) {
-	if params.SigHashFlags == 0 {
-		params.SigHashFlags = sighash.AllForkID
-	}
-	preimage, err := tx.CalcInputPreimage(params.InputIdx, params.SigHashFlags)
+	params.SigHashFlags = InputSigHashFlags(tx, params)
+	preimage, err := LowSPreimage(ctx, tx, params.InputIdx, params.SigHashFlags)
 	if err != nil {
 		return nil, err
 	}
-	preimage, nLockTime, err := pushtxpreimage.CheckForLowS(preimage)
-	if err != nil {
-		return nil, err
-	}
-	tx.LockTime = nLockTime
 
 	// defaultHex is used to fix a bug in the original client (see if statement in the CalcInputSignatureHash func)
 	var defaultHex = []byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
@@ -138,15 +190,23 @@ func (u *UnlockPushTx) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt
 		sh = preimage
 	}
 
-	sig, err := u.PrivateKey.Sign(sh)
+	signer := u.Signer
+	if signer == nil {
+		signer = &PrivateKeySigner{PrivateKey: u.PrivateKey}
+	}
+	sig, err := signer.Sign(ctx, sh)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := signer.PublicKey(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	pubKey := u.PrivateKey.PubKey().SerialiseCompressed()
+	pubKey := pk.SerialiseCompressed()
 	signature := sig.Serialise()
 
-	uscript, err := script.NewPushTxUnlockingScript(pubKey, preimage, signature, params.SigHashFlags)
+	uscript, err := script.NewPushTxUnlockingScriptWithArgs(u.Args, pubKey, preimage, signature, params.SigHashFlags)
 	if err != nil {
 		return nil, err
 	}
@@ -154,3 +214,119 @@ func (u *UnlockPushTx) UnlockingScript(ctx context.Context, tx *bt.Tx, params bt
 	return uscript, nil
 
 }
+
+// InputSigHashFlags returns the sighash flag a push tx input must be signed with, which is set by its
+// locking script. Other inputs use the flag of the params, defaulting to SIGHASH_ALL | SIGHASH_FORKID
+func InputSigHashFlags(tx *bt.Tx, params bt.UnlockerParams) sighash.Flag {
+	if in := tx.InputIdx(int(params.InputIdx)); in != nil && in.PreviousTxScript != nil {
+		if flag, ok := script.PushTxSigHash(in.PreviousTxScript); ok {
+			return flag
+		}
+	}
+	if params.SigHashFlags == 0 {
+		return sighash.AllForkID
+	}
+	return params.SigHashFlags
+}
+
+// LowSPreimage returns the preimage of the input, malleating the nLockTime of the transaction
+// until the preimage satisfies the low s requirement of Optimized OP_PUSH_TX, or ctx is done
+func LowSPreimage(ctx context.Context, tx *bt.Tx, inputIdx uint32, sigHashFlags sighash.Flag) ([]byte, error) {
+	preimage, err := tx.CalcInputPreimage(inputIdx, sigHashFlags)
+	if err != nil {
+		return nil, err
+	}
+	preimage, nLockTime, err := pushtxpreimage.CheckForLowSContext(ctx, preimage)
+	if err != nil {
+		return nil, err
+	}
+	tx.LockTime = nLockTime
+	return preimage, nil
+}
+
+// LowSLockTime malleates the nLockTime of the transaction until the preimages of all the given
+// inputs are low s, so transactions spending more than one push tx input can be signed.
+// Each input uses the sighash flag of its locking script, or sigHashFlags if it has none.
+// As with CheckForLowS, nLockTime counts up no further than preimage.MaxLockTime
+func LowSLockTime(ctx context.Context, tx *bt.Tx, inputIdxs []uint32, sigHashFlags sighash.Flag) error {
+	last := pushtxpreimage.MaxLockTime(tx.LockTime)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lowS := true
+		for _, idx := range inputIdxs {
+			flag := InputSigHashFlags(tx, bt.UnlockerParams{InputIdx: idx, SigHashFlags: sigHashFlags})
+			preimage, err := tx.CalcInputPreimage(idx, flag)
+			if err != nil {
+				return err
+			}
+			if !pushtxpreimage.IsLowS(preimage) {
+				lowS = false
+				break
+			}
+		}
+		if lowS {
+			return nil
+		}
+		if tx.LockTime == last {
+			return pushtxpreimage.ErrNoLowSLockTime
+		}
+		tx.LockTime++
+	}
+}
+
+// Fee returns the fee a transaction of this size must pay under the standard fee of the fee quote
+func Fee(tx *bt.Tx, fq *bt.FeeQuote) (uint64, error) {
+	fee, err := fq.Fee(bt.FeeTypeStandard)
+	if err != nil {
+		return 0, err
+	}
+	size := uint64(tx.Size())
+	sats, perBytes := uint64(fee.MiningFee.Satoshis), uint64(fee.MiningFee.Bytes)
+	// round up so the fee never falls short of the quote
+	return (size*sats + perBytes - 1) / perBytes, nil
+}
+
+// FillAllInputsWithChange signs all inputs and pays everything left over after the fee to the change output.
+// Output values are fixed size, so the transaction is signed once to measure it, and again once the change is set.
+// If more than one push tx input is spent, their indexes must be given so nLockTime is low s for all of them
+func FillAllInputsWithChange(ctx context.Context, tx *bt.Tx, ug bt.UnlockerGetter, changeIdx int, fq *bt.FeeQuote, pushTxInputs ...uint32) error {
+	if changeIdx < 0 || changeIdx >= len(tx.Outputs) {
+		return errors.New("change output does not exist")
+	}
+	change := tx.Outputs[changeIdx]
+	others := tx.TotalOutputSatoshis() - change.Satoshis
+	total := tx.TotalInputSatoshis()
+	if total < others {
+		return bt.ErrInsufficientFunds
+	}
+	change.Satoshis = total - others
+
+	// signatures can differ in length by a byte, so repeat until the fee is covered
+	for i := 0; i < 3; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if len(pushTxInputs) > 1 {
+			if err := LowSLockTime(ctx, tx, pushTxInputs, sighash.AllForkID); err != nil {
+				return err
+			}
+		}
+		if err := tx.FillAllInputs(ctx, ug); err != nil {
+			return err
+		}
+		fee, err := Fee(tx, fq)
+		if err != nil {
+			return err
+		}
+		if total-others-change.Satoshis >= fee {
+			return nil
+		}
+		if total-others < fee {
+			return bt.ErrInsufficientFunds
+		}
+		change.Satoshis = total - others - fee
+	}
+	return errors.New("could not settle fee for change output")
+}
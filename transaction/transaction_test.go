@@ -0,0 +1,146 @@
+package pushtx
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/libsv/go-bt/v2"
+	"github.com/libsv/go-bt/v2/sighash"
+	"github.com/murray-distributed-technologies/go-pushtx/internal/testutil"
+	pushtxpreimage "github.com/murray-distributed-technologies/go-pushtx/preimage"
+)
+
+func TestBuildOpPushTransaction(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	funding := testutil.NewFundingUTXO(t, address, 100000)
+	first, err := BuildOpPushTransaction(context.Background(), &bt.Input{
+		PreviousTxSatoshis: funding.Satoshis,
+		PreviousTxScript:   funding.LockingScript,
+		PreviousTxOutIndex: funding.Vout,
+	}, funding.TxIDStr(), address, address, privateKey, 90000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushTx := testutil.OutputUTXO(first.Tx, uint32(first.PushTxIndex))
+
+	var tests = []struct {
+		name   string
+		utxo   *bt.UTXO
+		pushTx bool
+	}{
+		{"p2pkh input", funding, false},
+		{"push tx input", pushTx, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := &bt.Input{
+				PreviousTxSatoshis: test.utxo.Satoshis,
+				PreviousTxScript:   test.utxo.LockingScript,
+				PreviousTxOutIndex: test.utxo.Vout,
+			}
+			result, err := BuildOpPushTransaction(context.Background(), input, test.utxo.TxIDStr(), address, address, privateKey, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = testutil.Verify(result.Tx); err != nil {
+				t.Errorf("%s failed verification: %v", test.name, err)
+			}
+			if result.TxID != result.Tx.TxID() {
+				t.Errorf("%s failed: expected txid %s, got %s", test.name, result.Tx.TxID(), result.TxID)
+			}
+			if len(result.Tx.Outputs) != 2 || result.ChangeIndex != 1 {
+				t.Errorf("%s failed: expected change in output 1 of 2, got %d of %d", test.name, result.ChangeIndex, len(result.Tx.Outputs))
+			}
+			if result.Tx.Outputs[result.PushTxIndex].Satoshis != 1000 {
+				t.Errorf("%s failed: expected push tx output of 1000, got %d", test.name, result.Tx.Outputs[result.PushTxIndex].Satoshis)
+			}
+			if fee := result.Tx.TotalInputSatoshis() - result.Tx.TotalOutputSatoshis(); result.Fee != fee || fee == 0 {
+				t.Errorf("%s failed: expected fee %d, got %d", test.name, fee, result.Fee)
+			}
+			if result.LockTime != result.Tx.LockTime || result.LockTimeDelta != result.Tx.LockTime {
+				t.Errorf("%s failed: expected nLocktime %d moved up as far from 0, got %d and %d", test.name, result.Tx.LockTime, result.LockTime, result.LockTimeDelta)
+			}
+			if iterations := lowSIterations(t, result.Tx, test.pushTx); result.Iterations != iterations {
+				t.Errorf("%s failed: expected %d low s iterations, got %d", test.name, iterations, result.Iterations)
+			}
+
+			rawTx, err := NewOpPushTransaction(input, test.utxo.TxIDStr(), address, address, privateKey, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rawTx != result.Tx.String() {
+				t.Errorf("%s failed: expected the hex wrapper to return the same transaction", test.name)
+			}
+		})
+	}
+}
+
+func TestBuildOpPushTransactionCanceled(t *testing.T) {
+	t.Parallel()
+	privateKey, address := testutil.NewKey(t)
+	funding := testutil.NewFundingUTXO(t, address, 100000)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := BuildOpPushTransaction(ctx, &bt.Input{
+		PreviousTxSatoshis: funding.Satoshis,
+		PreviousTxScript:   funding.LockingScript,
+		PreviousTxOutIndex: funding.Vout,
+	}, funding.TxIDStr(), address, address, privateKey, 90000)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLowSLockTimeStaysInRange(t *testing.T) {
+	t.Parallel()
+	_, address := testutil.NewKey(t)
+	deploy, err := AddOpPushTransactionOutput(bt.NewTx(), address, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := bt.NewTx()
+	if err = tx.FromUTXOs(testutil.OutputUTXO(deploy, 0)); err != nil {
+		t.Fatal(err)
+	}
+	tx.LockTime = math.MaxUint32
+	// change the output until the preimage needs malleating at the last nLockTime
+	for sats := uint64(1); ; sats++ {
+		tx.Outputs = nil
+		if err = tx.PayToAddress(address, sats); err != nil {
+			t.Fatal(err)
+		}
+		preimage, err := tx.CalcInputPreimage(0, sighash.AllForkID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pushtxpreimage.IsLowS(preimage) {
+			break
+		}
+	}
+	if err = LowSLockTime(context.Background(), tx, []uint32{0}, sighash.AllForkID); !errors.Is(err, pushtxpreimage.ErrNoLowSLockTime) {
+		t.Errorf("expected ErrNoLowSLockTime, got %v", err)
+	}
+	if tx.LockTime != math.MaxUint32 {
+		t.Errorf("expected nLockTime not to wrap, got %d", tx.LockTime)
+	}
+}
+
+// lowSIterations counts the preimages of input 0 from nLocktime 0 up to the first low s one
+func lowSIterations(t *testing.T, tx *bt.Tx, pushTx bool) uint32 {
+	if !pushTx {
+		return 0
+	}
+	tx = tx.Clone()
+	for tx.LockTime = 0; ; tx.LockTime++ {
+		preimage, err := tx.CalcInputPreimage(0, sighash.AllForkID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pushtxpreimage.IsLowS(preimage) {
+			return tx.LockTime + 1
+		}
+	}
+}